@@ -0,0 +1,133 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// nodeSelectorOperators maps label selector operators to node selector operators. Equality is
+// expressed as In with a single value.
+var nodeSelectorOperators = map[selection.Operator]corev1.NodeSelectorOperator{
+	selection.Equals:       corev1.NodeSelectorOpIn,
+	selection.DoubleEquals: corev1.NodeSelectorOpIn,
+	selection.In:           corev1.NodeSelectorOpIn,
+	selection.NotEquals:    corev1.NodeSelectorOpNotIn,
+	selection.NotIn:        corev1.NodeSelectorOpNotIn,
+	selection.Exists:       corev1.NodeSelectorOpExists,
+	selection.DoesNotExist: corev1.NodeSelectorOpDoesNotExist,
+	selection.GreaterThan:  corev1.NodeSelectorOpGt,
+	selection.LessThan:     corev1.NodeSelectorOpLt,
+}
+
+// parseNodeAffinity parses a label selector, such as "vpc-endpoints=true,!spot", into the node
+// selector requirements that nodes running the pod must satisfy.
+func parseNodeAffinity(value string) ([]corev1.NodeSelectorRequirement, error) {
+	selector, err := labels.Parse(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	requirements, _ := selector.Requirements()
+
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("no node label requirements")
+	}
+
+	var nodeRequirements []corev1.NodeSelectorRequirement
+
+	for _, requirement := range requirements {
+		operator, ok := nodeSelectorOperators[requirement.Operator()]
+
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator %q", requirement.Operator())
+		}
+
+		nodeRequirements = append(nodeRequirements, corev1.NodeSelectorRequirement{
+			Key:      requirement.Key(),
+			Operator: operator,
+			Values:   requirement.Values().List(),
+		})
+	}
+
+	return nodeRequirements, nil
+}
+
+// addNodeAffinity returns the patch requiring nodes of the pod to satisfy requirements. They
+// are added to every existing required node selector term, since terms are alternatives, so
+// that the pod's own affinity is kept and narrowed rather than overwritten. Requirements a term
+// already has are not added again, so that a reinjection does not repeat them.
+func addNodeAffinity(affinity *corev1.Affinity, requirements []corev1.NodeSelectorRequirement, basePath string) PatchOperation {
+	selector := &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: requirements}}}
+
+	switch {
+	case affinity == nil:
+		return PatchOperation{
+			Op:    "add",
+			Path:  basePath,
+			Value: corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: selector}},
+		}
+	case affinity.NodeAffinity == nil:
+		return PatchOperation{
+			Op:    "add",
+			Path:  basePath + "/nodeAffinity",
+			Value: corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: selector},
+		}
+	case affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil:
+		return PatchOperation{
+			Op:    "add",
+			Path:  basePath + "/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution",
+			Value: selector,
+		}
+	}
+
+	merged := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.DeepCopy()
+
+	if len(merged.NodeSelectorTerms) == 0 {
+		merged.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+
+	for i := range merged.NodeSelectorTerms {
+		for _, requirement := range requirements {
+			if !containsNodeSelectorRequirement(merged.NodeSelectorTerms[i].MatchExpressions, requirement) {
+				merged.NodeSelectorTerms[i].MatchExpressions = append(merged.NodeSelectorTerms[i].MatchExpressions, requirement)
+			}
+		}
+	}
+
+	return PatchOperation{
+		Op:    "replace",
+		Path:  basePath + "/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution",
+		Value: merged,
+	}
+}
+
+// containsNodeSelectorRequirement reports whether requirements has requirement.
+func containsNodeSelectorRequirement(requirements []corev1.NodeSelectorRequirement, requirement corev1.NodeSelectorRequirement) bool {
+	for i := range requirements {
+		if reflect.DeepEqual(requirements[i], requirement) {
+			return true
+		}
+	}
+
+	return false
+}
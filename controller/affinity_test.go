@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseNodeAffinity(t *testing.T) {
+	requirements, err := parseNodeAffinity("vpc-endpoints=true,topology.kubernetes.io/zone in (us-west-2b,us-west-2a),!spot")
+	assert.Nil(t, err, "Should parse the selector")
+	assert.Equal(t, []corev1.NodeSelectorRequirement{
+		{Key: "spot", Operator: corev1.NodeSelectorOpDoesNotExist, Values: []string{}},
+		{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"us-west-2a", "us-west-2b"}},
+		{Key: "vpc-endpoints", Operator: corev1.NodeSelectorOpIn, Values: []string{"true"}},
+	}, requirements)
+
+	_, err = parseNodeAffinity("vpc-endpoints in")
+	assert.NotNil(t, err, "Should reject a malformed selector")
+}
+
+func TestWebhookServer_mutateNodeAffinity(t *testing.T) {
+	vpcEndpoints := corev1.NodeSelectorRequirement{Key: "vpc-endpoints", Operator: corev1.NodeSelectorOpIn, Values: []string{"true"}}
+	arm64 := corev1.NodeSelectorRequirement{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"}}
+	amd64 := corev1.NodeSelectorRequirement{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64"}}
+	preferred := []corev1.PreferredSchedulingTerm{{Weight: 1, Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{arm64}}}}
+	podAntiAffinity := &corev1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{TopologyKey: "kubernetes.io/hostname"}}}
+
+	var testCases = []struct {
+		name         string
+		affinity     *corev1.Affinity
+		expected     *corev1.Affinity
+		errorMessage string
+	}{
+		{
+			name:     "TestNoAffinity",
+			affinity: nil,
+			expected: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{vpcEndpoints}},
+				}},
+			}},
+			errorMessage: "Should add the affinity",
+		},
+		{
+			name:     "TestPodAntiAffinity",
+			affinity: &corev1.Affinity{PodAntiAffinity: podAntiAffinity},
+			expected: &corev1.Affinity{
+				PodAntiAffinity: podAntiAffinity,
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{vpcEndpoints}},
+					}},
+				},
+			},
+			errorMessage: "Should keep the pod's other affinity",
+		},
+		{
+			name:     "TestPreferredNodeAffinity",
+			affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: preferred}},
+			expected: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: preferred,
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{vpcEndpoints}},
+				}},
+			}},
+			errorMessage: "Should keep the pod's preferred node affinity",
+		},
+		{
+			name: "TestRequiredNodeAffinity",
+			affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{arm64}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{amd64}},
+				}},
+			}},
+			expected: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{arm64, vpcEndpoints}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{amd64, vpcEndpoints}},
+				}},
+			}},
+			errorMessage: "Should require the node labels in each of the pod's node selector terms",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey:       "true",
+					signingProxyWebhookAnnotationHostKey:         "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationNodeAffinityKey: "vpc-endpoints=true",
+				}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app"}},
+					Affinity:   tc.affinity,
+				},
+			}
+
+			admissionReview := newAdmissionReview(t, pod)
+
+			response, err := whsvr.mutate(context.Background(), admissionReview)
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			patch, err := jsonpatch.DecodePatch(response.Patch)
+			assert.Nil(t, err, "Should decode the patch")
+
+			patched, err := patch.Apply(admissionReview.Request.Object.Raw)
+			assert.Nil(t, err, "Should apply the patch")
+
+			var patchedPod corev1.Pod
+			assert.Nil(t, json.Unmarshal(patched, &patchedPod), "Should decode the patched pod")
+			assert.Equal(t, tc.expected, patchedPod.Spec.Affinity, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestInvalidNodeAffinity", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey:       "true",
+				signingProxyWebhookAnnotationHostKey:         "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationNodeAffinityKey: "vpc-endpoints in",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should deny an invalid node affinity")
+		assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationNodeAffinityKey, "Should name the offending annotation")
+	})
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"sigs.k8s.io/yaml"
+)
+
+// sidecarArgsValues holds the resolved sidecar parameters. Its fields are the variables
+// available to the --args-template, e.g. {{.Host}}.
+type sidecarArgsValues struct {
+	Host              string
+	Name              string
+	Region            string
+	UnsignedPayload   bool
+	UpstreamURLScheme string
+	PathPrefix        string // Path prepended to each upstream request, if set
+	SignHost          string
+	CustomHeaders     string
+	RoleArn           string
+	RoleExternalId    string
+	RoleSessionName   string
+	Port              int
+	Socket            string // Unix socket path the proxy listens on instead of Port, if set
+}
+
+// defaultArgs assembles the sidecar command line used when no args template is set. The proxy
+// listens on values.Socket with socketFlag if a socket is set, and on values.Port otherwise.
+func (values sidecarArgsValues) defaultArgs(socketFlag string) []string {
+	args := []string{"--name", values.Name, "--region", values.Region, "--host", values.Host}
+
+	if values.Socket != "" {
+		args = append(args, socketFlag, values.Socket)
+	} else {
+		args = append(args, "--port", fmt.Sprintf(":%d", values.Port))
+	}
+
+	if values.UnsignedPayload {
+		args = append(args, "--unsigned-payload")
+	}
+
+	args = append(args, "--upstream-url-scheme", values.UpstreamURLScheme)
+
+	if values.PathPrefix != "" {
+		args = append(args, "--upstream-path-prefix", values.PathPrefix)
+	}
+
+	if values.SignHost != "" {
+		args = append(args, "--sign-host", values.SignHost)
+	}
+
+	if values.CustomHeaders != "" {
+		args = append(args, "--custom-headers", values.CustomHeaders)
+	}
+
+	if values.RoleArn != "" {
+		args = append(args, "--role-arn", values.RoleArn)
+
+		if values.RoleExternalId != "" {
+			args = append(args, "--role-external-id", values.RoleExternalId)
+		}
+
+		if values.RoleSessionName != "" {
+			args = append(args, "--role-session-name", values.RoleSessionName)
+		}
+	}
+
+	return args
+}
+
+// argsTemplate is a parsed args template. A template written as a YAML list, e.g.
+// ["--host", "{{.Host}}"], holds one template per argument. Otherwise it holds a single template
+// whose output is split on whitespace.
+type argsTemplate struct {
+	templates []*template.Template
+	list      bool
+}
+
+// parseArgsTemplate parses an args template, failing on unknown functions or syntax errors.
+func parseArgsTemplate(text string) (*argsTemplate, error) {
+	elements := []string{text}
+	list := strings.HasPrefix(strings.TrimSpace(text), "[")
+
+	if list {
+		if err := yaml.Unmarshal([]byte(text), &elements); err != nil {
+			return nil, fmt.Errorf("Invalid args template: not a list of arguments: %v", err)
+		}
+	}
+
+	parsed := &argsTemplate{list: list}
+
+	for _, element := range elements {
+		tmpl, err := template.New("args").Option("missingkey=error").Parse(element)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid args template: %v", err)
+		}
+
+		parsed.templates = append(parsed.templates, tmpl)
+	}
+
+	return parsed, nil
+}
+
+// renderArgsTemplate renders the args template with the resolved values into the sidecar
+// arguments. Each element of a list template renders one argument, left out if empty, so that
+// values are passed as they are whatever they contain. The output of other templates is split
+// on whitespace, which fails if a value containing whitespace was rendered, rather than passing
+// the proxy a value split into several arguments.
+func renderArgsTemplate(text string, values sidecarArgsValues) ([]string, error) {
+	parsed, err := parseArgsTemplate(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+
+	for _, tmpl := range parsed.templates {
+		var rendered bytes.Buffer
+
+		if err := tmpl.Execute(&rendered, values); err != nil {
+			return nil, fmt.Errorf("Error rendering args template: %v", err)
+		}
+
+		output := rendered.String()
+
+		if parsed.list {
+			if output != "" {
+				args = append(args, output)
+			}
+
+			continue
+		}
+
+		if name, value, ok := values.splitValue(output); ok {
+			return nil, fmt.Errorf("Error rendering args template: .%s %q contains whitespace and would be split into several arguments, write the template as a list such as [\"--custom-headers\", \"{{.CustomHeaders}}\"]", name, value)
+		}
+
+		args = append(args, strings.Fields(output)...)
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Error rendering args template: no arguments produced")
+	}
+
+	return args, nil
+}
+
+// splitValue returns the name and value of a string value containing whitespace that appears in
+// output, which splitting output on whitespace would break apart.
+func (values sidecarArgsValues) splitValue(output string) (string, string, bool) {
+	fields := reflect.ValueOf(values)
+
+	for i := 0; i < fields.NumField(); i++ {
+		if fields.Field(i).Kind() != reflect.String {
+			continue
+		}
+
+		value := fields.Field(i).String()
+
+		if strings.IndexFunc(value, unicode.IsSpace) >= 0 && strings.Contains(output, value) {
+			return fields.Type().Field(i).Name, value, true
+		}
+	}
+
+	return "", "", false
+}
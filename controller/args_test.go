@@ -0,0 +1,171 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderArgsTemplate(t *testing.T) {
+	values := sidecarArgsValues{
+		Host:              "aps-workspaces.us-west-2.amazonaws.com",
+		Name:              "aps",
+		Region:            "us-west-2",
+		UpstreamURLScheme: "https",
+		RoleArn:           "arn:aws:iam::123456789012:role/proxy",
+		CustomHeaders:     "X-Team: data platform",
+		PathPrefix:        `/x","--host","evil.example.com`,
+	}
+
+	var testCases = []struct {
+		name         string
+		template     string
+		expected     []string
+		valid        bool
+		errorMessage string
+	}{
+		{
+			name:         "TestCustomOrder",
+			template:     "--verbose --host {{.Host}} --region {{.Region}} --name {{.Name}}",
+			expected:     []string{"--verbose", "--host", "aps-workspaces.us-west-2.amazonaws.com", "--region", "us-west-2", "--name", "aps"},
+			valid:        true,
+			errorMessage: "Should render the arguments in the template order",
+		},
+		{
+			name:         "TestConditional",
+			template:     "--name {{.Name}}{{if .RoleArn}} --role-arn {{.RoleArn}}{{end}}{{if .UnsignedPayload}} --unsigned-payload{{end}}",
+			expected:     []string{"--name", "aps", "--role-arn", "arn:aws:iam::123456789012:role/proxy"},
+			valid:        true,
+			errorMessage: "Should render conditional arguments",
+		},
+		{
+			name:         "TestList",
+			template:     `["--name", "{{.Name}}", "--custom-headers", "{{.CustomHeaders}}"]`,
+			expected:     []string{"--name", "aps", "--custom-headers", "X-Team: data platform"},
+			valid:        true,
+			errorMessage: "Should keep a value containing whitespace as one argument of a list",
+		},
+		{
+			name:         "TestListInjectedArgs",
+			template:     `["--host", "{{.Host}}", "--upstream-path-prefix", "{{.PathPrefix}}"]`,
+			expected:     []string{"--host", "aps-workspaces.us-west-2.amazonaws.com", "--upstream-path-prefix", `/x","--host","evil.example.com`},
+			valid:        true,
+			errorMessage: "Should not let a value with quotes and commas add arguments to a list",
+		},
+		{
+			name:         "TestListConditional",
+			template:     `["--name", "{{.Name}}", "{{if .UnsignedPayload}}--unsigned-payload{{end}}"]`,
+			expected:     []string{"--name", "aps"},
+			valid:        true,
+			errorMessage: "Should leave out list elements rendering empty",
+		},
+		{
+			name:         "TestSplitValue",
+			template:     "--name {{.Name}} --custom-headers {{.CustomHeaders}}",
+			valid:        false,
+			errorMessage: "Should fail when a value containing whitespace would be split",
+		},
+		{
+			name:         "TestUnusedSplitValue",
+			template:     "--name {{.Name}}",
+			expected:     []string{"--name", "aps"},
+			valid:        true,
+			errorMessage: "Should ignore values containing whitespace that are not rendered",
+		},
+		{
+			name:         "TestMalformedList",
+			template:     `["--name", "{{.Name}}"`,
+			valid:        false,
+			errorMessage: "Should fail when the list cannot be decoded",
+		},
+		{
+			name:         "TestMalformedTemplate",
+			template:     "--name {{.Name",
+			valid:        false,
+			errorMessage: "Should fail on a malformed template",
+		},
+		{
+			name:         "TestUnknownField",
+			template:     "--name {{.Nmae}}",
+			valid:        false,
+			errorMessage: "Should fail on an unknown variable",
+		},
+		{
+			name:         "TestEmptyOutput",
+			template:     "{{if .UnsignedPayload}}--unsigned-payload{{end}}",
+			valid:        false,
+			errorMessage: "Should fail when no arguments are rendered",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := renderArgsTemplate(tc.template, values)
+			assert.Equal(t, tc.valid, err == nil, tc.errorMessage)
+			assert.Equal(t, tc.expected, args, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateArgsTemplate(t *testing.T) {
+	annotations := map[string]string{
+		signingProxyWebhookAnnotationInjectKey: "true",
+		signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+	}
+
+	t.Run("TestArgsTemplate", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			config:          Config{ArgsTemplate: "--host={{.Host}} --region={{.Region}} --port=:8005 --verbose"},
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Equal(t, []string{"--host=aps-workspaces.us-west-2.amazonaws.com", "--region=us-west-2", "--port=:8005", "--verbose"}, container.Args, "Should use the rendered arguments")
+	})
+
+	t.Run("TestArgsTemplateError", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			config:          Config{ArgsTemplate: "--host {{.Hots}}"},
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should deny the pod when the template fails")
+		assert.Empty(t, response.Patch, "Should not inject the sidecar")
+	})
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// circuitBreaker stops describing namespaces after consecutive failures, so that admission
+// requests fail fast while the API server is unavailable instead of each waiting for its own
+// retries. Once the cooldown has passed, requests go through again, and the breaker trips
+// again on the next failure unless one of them succeeds.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex     sync.Mutex
+	failures  int       // Consecutive failures, reset by a success
+	openUntil time.Time // End of the cooldown, zero if the breaker never tripped
+}
+
+// newCircuitBreaker returns a breaker tripping after threshold consecutive failures for
+// cooldown, or nil if threshold is not positive.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns an error while the breaker is open.
+func (b *circuitBreaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if remaining := b.openUntil.Sub(b.now()); remaining > 0 {
+		return fmt.Errorf("Circuit breaker open after %d consecutive failures, retrying in %s", b.failures, remaining.Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+// record counts the outcome of a call allowed by the breaker, tripping it once the failures
+// reach the threshold. Errors showing that the API server answered, such as a missing
+// namespace or missing permissions, are not failures, and calls canceled by the caller are
+// not counted.
+func (b *circuitBreaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err == nil || k8serrors.IsNotFound(err) || k8serrors.IsForbidden(err) || k8serrors.IsUnauthorized(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
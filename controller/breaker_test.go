@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"aws-signingproxy-admissioncontroller/controller/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	unavailable := k8serrors.NewServiceUnavailable("API server unavailable")
+
+	newBreaker := func() (*circuitBreaker, *time.Time) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		breaker := newCircuitBreaker(3, time.Minute)
+		breaker.now = func() time.Time { return now }
+		return breaker, &now
+	}
+
+	t.Run("TestDisabled", func(t *testing.T) {
+		assert.Nil(t, newCircuitBreaker(0, time.Minute), "Should not create a breaker without a threshold")
+	})
+
+	t.Run("TestTrips", func(t *testing.T) {
+		breaker, _ := newBreaker()
+
+		for i := 0; i < 2; i++ {
+			assert.Nil(t, breaker.allow(), "Should allow calls below the threshold")
+			breaker.record(unavailable)
+		}
+
+		assert.Nil(t, breaker.allow(), "Should allow calls below the threshold")
+		breaker.record(unavailable)
+		assert.NotNil(t, breaker.allow(), "Should trip after consecutive failures")
+	})
+
+	t.Run("TestSuccessResets", func(t *testing.T) {
+		breaker, _ := newBreaker()
+
+		breaker.record(unavailable)
+		breaker.record(unavailable)
+		breaker.record(nil)
+		breaker.record(unavailable)
+		assert.Nil(t, breaker.allow(), "Should only trip on consecutive failures")
+	})
+
+	t.Run("TestAnsweredErrorsIgnored", func(t *testing.T) {
+		breaker, _ := newBreaker()
+
+		for i := 0; i < 3; i++ {
+			breaker.record(k8serrors.NewNotFound(corev1.Resource("namespaces"), "testNamespace"))
+			breaker.record(context.Canceled)
+		}
+
+		assert.Nil(t, breaker.allow(), "Should not trip on errors answered by the API server or canceled calls")
+	})
+
+	t.Run("TestRecovers", func(t *testing.T) {
+		breaker, now := newBreaker()
+
+		for i := 0; i < 3; i++ {
+			breaker.record(unavailable)
+		}
+
+		*now = now.Add(59 * time.Second)
+		assert.NotNil(t, breaker.allow(), "Should stay open during the cooldown")
+
+		*now = now.Add(time.Second)
+		assert.Nil(t, breaker.allow(), "Should allow calls after the cooldown")
+
+		breaker.record(unavailable)
+		assert.NotNil(t, breaker.allow(), "Should trip again on the first failure after the cooldown")
+
+		*now = now.Add(time.Minute)
+		breaker.record(nil)
+		breaker.record(unavailable)
+		assert.Nil(t, breaker.allow(), "Should close after a success")
+	})
+}
+
+func TestWebhookServer_mutateNamespaceBreaker(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+		},
+	}
+
+	for _, failOpen := range []bool{true, false} {
+		failingKubernetesClient := &mocks.KubernetesNamespaceClient{}
+		failingKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+			nil, k8serrors.NewServiceUnavailable("API server unavailable"))
+
+		whsvr := &WebhookServer{
+			namespaceClient:  failingKubernetesClient,
+			namespaceBreaker: newCircuitBreaker(2, time.Minute),
+			config: Config{
+				FailOpen:                failOpen,
+				NamespaceRetryAttempts:  3,
+				NamespaceRetryBaseDelay: metav1.Duration{Duration: time.Millisecond},
+			},
+		}
+
+		for i := 0; i < 5; i++ {
+			response, _ := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Equal(t, failOpen, response.Allowed, "Should follow the fail-open setting")
+			assert.Empty(t, response.Patch, "Should not patch the pod")
+		}
+
+		failingKubernetesClient.AssertNumberOfCalls(t, "Get", 2*3)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SelfSignedCertValidity is how long certificates generated with --self-bootstrap-certs are valid.
+// They are regenerated on every start of the controller.
+const SelfSignedCertValidity = 365 * 24 * time.Hour
+
+// SplitWebhookService splits a <namespace>/<name> reference to the webhook's Service.
+func SplitWebhookService(reference string) (string, string, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(reference)
+
+	if err != nil || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("Invalid webhook Service %q, expected <namespace>/<name>", reference)
+	}
+
+	return namespace, name, nil
+}
+
+// GenerateSelfSignedCert returns a PEM encoded self-signed certificate and private key for the
+// DNS names the API server uses to reach the Service name in namespace. The certificate is its
+// own CA, so it doubles as the caBundle of the webhook configuration.
+func GenerateSelfSignedCert(namespace, name string, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error generating private key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error generating serial number: %v", err)
+	}
+
+	host := fmt.Sprintf("%s.%s.svc", name, namespace)
+	now := time.Now()
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{name, name + "." + namespace, host, host + ".cluster.local"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error encoding private key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// WriteCertFiles writes the PEM encoded certificate and private key, creating their directories.
+// The private key is only readable by the controller.
+func WriteCertFiles(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	for _, file := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{path: certFile, data: certPEM, mode: 0644},
+		{path: keyFile, data: keyPEM, mode: 0600},
+	} {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return fmt.Errorf("Error creating directory of %q: %v", file.path, err)
+		}
+
+		if err := os.WriteFile(file.path, file.data, file.mode); err != nil {
+			return fmt.Errorf("Error writing %q: %v", file.path, err)
+		}
+	}
+
+	return nil
+}
+
+// PatchWebhookCABundle sets the caBundle of every webhook in the MutatingWebhookConfiguration name
+// to caBundle, so the API server trusts a self-signed certificate. Requires permission to get and
+// update the configuration.
+func PatchWebhookCABundle(ctx context.Context, k8sClient kubernetes.Interface, name string, caBundle []byte) error {
+	webhookConfigs := k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	webhookConfig, err := webhookConfigs.Get(ctx, name, metav1.GetOptions{})
+
+	if err != nil {
+		return fmt.Errorf("Error getting MutatingWebhookConfiguration %s: %v", name, err)
+	}
+
+	if len(webhookConfig.Webhooks) == 0 {
+		return fmt.Errorf("MutatingWebhookConfiguration %s has no webhooks", name)
+	}
+
+	for i := range webhookConfig.Webhooks {
+		webhookConfig.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+
+	if _, err := webhookConfigs.Update(ctx, webhookConfig, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("Error updating caBundle of MutatingWebhookConfiguration %s: %v", name, err)
+	}
+
+	return nil
+}
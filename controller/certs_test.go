@@ -0,0 +1,123 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSignedCert("kube-system", "sigv4-webhook", time.Hour)
+	assert.Nil(t, err, "Should generate the certificate")
+
+	_, err = tls.X509KeyPair(certPEM, keyPEM)
+	assert.Nil(t, err, "Should generate a matching key pair")
+
+	block, _ := pem.Decode(certPEM)
+	assert.NotNil(t, block, "Should PEM encode the certificate")
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err, "Should parse the certificate")
+	assert.True(t, cert.NotAfter.Before(time.Now().Add(2*time.Hour)), "Should expire after the validity")
+
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(certPEM), "Should be usable as a CA bundle")
+
+	for _, host := range []string{"sigv4-webhook.kube-system.svc", "sigv4-webhook.kube-system.svc.cluster.local"} {
+		_, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.Nil(t, err, "Should be trusted for %s with itself as the CA bundle", host)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "sigv4-webhook.default.svc", Roots: roots})
+	assert.NotNil(t, err, "Should not be valid for the Service in another namespace")
+}
+
+func TestSplitWebhookService(t *testing.T) {
+	namespace, name, err := SplitWebhookService("kube-system/sigv4-webhook")
+	assert.Nil(t, err, "Should split the reference")
+	assert.Equal(t, "kube-system", namespace)
+	assert.Equal(t, "sigv4-webhook", name)
+
+	for _, reference := range []string{"", "sigv4-webhook", "kube-system/", "a/b/c"} {
+		_, _, err := SplitWebhookService(reference)
+		assert.NotNil(t, err, "Should reject %q", reference)
+	}
+}
+
+func TestWriteCertFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	certPEM, keyPEM, err := GenerateSelfSignedCert("kube-system", "sigv4-webhook", time.Hour)
+	assert.Nil(t, err, "Should generate the certificate")
+	assert.Nil(t, WriteCertFiles(certFile, keyFile, certPEM, keyPEM), "Should write the files")
+
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err, "Should load the written key pair")
+
+	info, err := os.Stat(keyFile)
+	assert.Nil(t, err, "Should write the key")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Should restrict the key's permissions")
+}
+
+func TestPatchWebhookCABundle(t *testing.T) {
+	webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "sigv4-webhook"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "pods.sigv4.aws", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("old")}},
+			{Name: "workloads.sigv4.aws"},
+		},
+	}
+
+	t.Run("TestPatched", func(t *testing.T) {
+		client := fake.NewSimpleClientset(webhookConfig.DeepCopy())
+
+		assert.Nil(t, PatchWebhookCABundle(context.Background(), client, "sigv4-webhook", []byte("new")), "Should patch the caBundle")
+
+		updated, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), "sigv4-webhook", metav1.GetOptions{})
+		assert.Nil(t, err, "Should get the configuration")
+
+		for _, webhook := range updated.Webhooks {
+			assert.Equal(t, []byte("new"), webhook.ClientConfig.CABundle, "Should set the caBundle of %s", webhook.Name)
+		}
+	})
+
+	t.Run("TestNotFound", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+
+		err := PatchWebhookCABundle(context.Background(), client, "sigv4-webhook", []byte("new"))
+		assert.NotNil(t, err, "Should fail without the configuration")
+	})
+
+	t.Run("TestNoWebhooks", func(t *testing.T) {
+		client := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "sigv4-webhook"}})
+
+		err := PatchWebhookCABundle(context.Background(), client, "sigv4-webhook", []byte("new"))
+		assert.NotNil(t, err, "Should fail without webhooks to patch")
+	})
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// ChaosEnableEnv must be set to "true" in the controller's environment for the chaos settings
+// to take effect, so that they cannot be enabled in a release deployment by a flag alone.
+const ChaosEnableEnv = "SIGNING_PROXY_WEBHOOK_ENABLE_CHAOS"
+
+// chaosInjector deliberately delays and fails AdmissionReview requests, to exercise the
+// webhook's failurePolicy and the monitoring of its errors and latency.
+type chaosInjector struct {
+	errorRate float64
+	latency   time.Duration
+	random    func() float64
+}
+
+// newChaosInjector returns an injector for the chaos settings of config, nil if none are set,
+// or an error if they are set without ChaosEnableEnv.
+func newChaosInjector(config Config) (*chaosInjector, error) {
+	if config.ChaosErrorRate <= 0 && config.ChaosLatency.Duration <= 0 {
+		return nil, nil
+	}
+
+	if os.Getenv(ChaosEnableEnv) != "true" {
+		return nil, fmt.Errorf("Chaos error rate and latency require the %s=true environment variable", ChaosEnableEnv)
+	}
+
+	return &chaosInjector{
+		errorRate: config.ChaosErrorRate,
+		latency:   config.ChaosLatency.Duration,
+		random:    rand.Float64,
+	}, nil
+}
+
+// inject delays the request by the configured latency and returns whether it should fail.
+// It returns early, without failing the request, if ctx is done during the delay.
+func (c *chaosInjector) inject(ctx context.Context) bool {
+	if c.latency > 0 {
+		timer := time.NewTimer(c.latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return c.errorRate > 0 && c.random() < c.errorRate
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewChaosInjector(t *testing.T) {
+	t.Run("TestDisabled", func(t *testing.T) {
+		chaos, err := newChaosInjector(Config{})
+		assert.Nil(t, err, "Should succeed")
+		assert.Nil(t, chaos, "Should not inject chaos unless configured")
+	})
+
+	t.Run("TestRequiresEnv", func(t *testing.T) {
+		t.Setenv(ChaosEnableEnv, "")
+
+		_, err := NewWebhookServer(nil, fake.NewSimpleClientset(), Config{ChaosErrorRate: 0.5})
+		assert.NotNil(t, err, "Should refuse chaos settings without the environment variable")
+		assert.Contains(t, err.Error(), ChaosEnableEnv, "Should name the environment variable")
+	})
+
+	t.Run("TestEnabled", func(t *testing.T) {
+		t.Setenv(ChaosEnableEnv, "true")
+
+		chaos, err := newChaosInjector(Config{ChaosErrorRate: 0.5, ChaosLatency: metav1.Duration{Duration: time.Second}})
+		assert.Nil(t, err, "Should succeed")
+		assert.Equal(t, 0.5, chaos.errorRate)
+		assert.Equal(t, time.Second, chaos.latency)
+	})
+}
+
+func TestWebhookServer_HandlerChaosErrorRate(t *testing.T) {
+	t.Setenv(ChaosEnableEnv, "true")
+
+	errorRate := 0.3
+	requests := 2000
+
+	whsvr, err := NewWebhookServer(nil, fake.NewSimpleClientset(), Config{ChaosErrorRate: errorRate})
+	assert.Nil(t, err, "Should create the webhook server")
+
+	whsvr.namespaceClient = newNamespaceClient(map[string]string{})
+	whsvr.chaos.random = rand.New(rand.NewSource(1)).Float64
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	body := newAdmissionReviewBody(t, "admission.k8s.io/v1", pod)
+
+	failed := 0
+
+	for i := 0; i < requests; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		whsvr.Handler(recorder, request)
+
+		switch recorder.Code {
+		case http.StatusInternalServerError:
+			failed++
+		case http.StatusOK:
+		default:
+			t.Fatalf("Unexpected response code %d", recorder.Code)
+		}
+	}
+
+	assert.InDelta(t, errorRate, float64(failed)/float64(requests), 0.05, "Should fail about the configured fraction of requests")
+}
+
+func TestChaosInjector_inject(t *testing.T) {
+	t.Run("TestLatency", func(t *testing.T) {
+		chaos := &chaosInjector{latency: 20 * time.Millisecond, random: rand.Float64}
+
+		start := time.Now()
+		assert.False(t, chaos.inject(context.Background()), "Should not fail requests without an error rate")
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "Should delay the request")
+	})
+
+	t.Run("TestLatencyCanceled", func(t *testing.T) {
+		chaos := &chaosInjector{errorRate: 1, latency: time.Hour, random: rand.Float64}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.False(t, chaos.inject(ctx), "Should stop delaying and not fail a canceled request")
+	})
+
+	t.Run("TestAlwaysFail", func(t *testing.T) {
+		chaos := &chaosInjector{errorRate: 1, random: rand.Float64}
+		assert.True(t, chaos.inject(context.Background()), "Should fail every request at a rate of 1")
+	})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+var (
+	admissionScheme = runtime.NewScheme()
+	admissionCodecs = serializer.NewCodecFactory(admissionScheme)
+)
+
+func init() {
+	utilruntime.Must(admissionv1.AddToScheme(admissionScheme))
+	utilruntime.Must(v1beta1.AddToScheme(admissionScheme))
+}
+
+// decodeAdmissionReview decodes an admission.k8s.io/v1 or v1beta1 AdmissionReview, returning it
+// as v1beta1 for mutate along with the version it was sent in.
+func decodeAdmissionReview(body []byte) (*v1beta1.AdmissionReview, *schema.GroupVersionKind, error) {
+	object, gvk, err := admissionCodecs.UniversalDeserializer().Decode(body, nil, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch review := object.(type) {
+	case *v1beta1.AdmissionReview:
+		return review, gvk, nil
+	case *admissionv1.AdmissionReview:
+		return &v1beta1.AdmissionReview{Request: convertAdmissionRequestToV1beta1(review.Request)}, gvk, nil
+	default:
+		return nil, nil, fmt.Errorf("Unsupported AdmissionReview version %v", gvk)
+	}
+}
+
+// encodeAdmissionReview encodes the admission response in the version of the AdmissionReview
+// it answers, since the API server rejects responses in a different version.
+func encodeAdmissionReview(response *v1beta1.AdmissionResponse, gvk *schema.GroupVersionKind) ([]byte, error) {
+	info, ok := runtime.SerializerInfoForMediaType(admissionCodecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+
+	if !ok {
+		return nil, fmt.Errorf("No serializer registered for %s", runtime.ContentTypeJSON)
+	}
+
+	var review runtime.Object
+
+	switch gvk.GroupVersion() {
+	case v1beta1.SchemeGroupVersion:
+		review = &v1beta1.AdmissionReview{Response: response}
+	case admissionv1.SchemeGroupVersion:
+		review = &admissionv1.AdmissionReview{Response: convertAdmissionResponseToV1(response)}
+	default:
+		return nil, fmt.Errorf("Unsupported AdmissionReview version %v", gvk)
+	}
+
+	return runtime.Encode(admissionCodecs.EncoderForVersion(info.Serializer, gvk.GroupVersion()), review)
+}
+
+func convertAdmissionRequestToV1beta1(request *admissionv1.AdmissionRequest) *v1beta1.AdmissionRequest {
+	if request == nil {
+		return nil
+	}
+
+	return &v1beta1.AdmissionRequest{
+		UID:                request.UID,
+		Kind:               request.Kind,
+		Resource:           request.Resource,
+		SubResource:        request.SubResource,
+		RequestKind:        request.RequestKind,
+		RequestResource:    request.RequestResource,
+		RequestSubResource: request.RequestSubResource,
+		Name:               request.Name,
+		Namespace:          request.Namespace,
+		Operation:          v1beta1.Operation(request.Operation),
+		UserInfo:           request.UserInfo,
+		Object:             request.Object,
+		OldObject:          request.OldObject,
+		DryRun:             request.DryRun,
+		Options:            request.Options,
+	}
+}
+
+func convertAdmissionResponseToV1(response *v1beta1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	if response == nil {
+		return nil
+	}
+
+	converted := &admissionv1.AdmissionResponse{
+		UID:              response.UID,
+		Allowed:          response.Allowed,
+		Result:           response.Result,
+		Patch:            response.Patch,
+		AuditAnnotations: response.AuditAnnotations,
+		Warnings:         response.Warnings,
+	}
+
+	if response.PatchType != nil {
+		patchType := admissionv1.PatchType(*response.PatchType)
+		converted.PatchType = &patchType
+	}
+
+	return converted
+}
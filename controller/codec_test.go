@@ -0,0 +1,159 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newAdmissionReviewBody(t *testing.T, apiVersion string, pod *corev1.Pod) []byte {
+	raw, err := json.Marshal(pod)
+	assert.Nil(t, err, "Should marshal pod")
+
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "AdmissionReview",
+		"request": map[string]interface{}{
+			"uid":       "test-uid",
+			"namespace": "testNamespace",
+			"operation": "CREATE",
+			"dryRun":    true,
+			"object":    json.RawMessage(raw),
+		},
+	})
+	assert.Nil(t, err, "Should marshal AdmissionReview")
+
+	return body
+}
+
+func TestDecodeAdmissionReview(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+
+	var testCases = []struct {
+		name       string
+		apiVersion string
+	}{
+		{name: "TestDecodeV1", apiVersion: "admission.k8s.io/v1"},
+		{name: "TestDecodeV1beta1", apiVersion: "admission.k8s.io/v1beta1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			review, gvk, err := decodeAdmissionReview(newAdmissionReviewBody(t, tc.apiVersion, pod))
+
+			assert.Nil(t, err, "Should decode AdmissionReview")
+			assert.Equal(t, tc.apiVersion, gvk.GroupVersion().String(), "Should report the request version")
+			assert.Equal(t, "test-uid", string(review.Request.UID), "Should keep the request UID")
+			assert.Equal(t, "testNamespace", review.Request.Namespace, "Should keep the request namespace")
+			assert.Equal(t, v1beta1.Create, review.Request.Operation, "Should keep the request operation")
+			assert.True(t, *review.Request.DryRun, "Should keep the request dry run flag")
+
+			var decoded corev1.Pod
+			assert.Nil(t, json.Unmarshal(review.Request.Object.Raw, &decoded), "Should keep the request object")
+			assert.Equal(t, "app", decoded.Name, "Should keep the request object")
+		})
+	}
+
+	t.Run("TestUnsupportedKind", func(t *testing.T) {
+		_, _, err := decodeAdmissionReview([]byte(`{"apiVersion":"v1","kind":"Pod"}`))
+		assert.NotNil(t, err, "Should reject objects that are not an AdmissionReview")
+	})
+}
+
+func TestEncodeAdmissionReview(t *testing.T) {
+	patchType := v1beta1.PatchTypeJSONPatch
+	response := &v1beta1.AdmissionResponse{
+		UID:       "test-uid",
+		Allowed:   true,
+		Patch:     []byte(`[]`),
+		PatchType: &patchType,
+	}
+
+	t.Run("TestEncodeV1", func(t *testing.T) {
+		gvk := admissionv1.SchemeGroupVersion.WithKind("AdmissionReview")
+		body, err := encodeAdmissionReview(response, &gvk)
+		assert.Nil(t, err, "Should encode AdmissionReview")
+
+		var review admissionv1.AdmissionReview
+		assert.Nil(t, json.Unmarshal(body, &review), "Should unmarshal AdmissionReview")
+		assert.Equal(t, "admission.k8s.io/v1", review.APIVersion, "Should answer in the request version")
+		assert.Equal(t, "AdmissionReview", review.Kind, "Should set the kind")
+		assert.Equal(t, "test-uid", string(review.Response.UID), "Should keep the response UID")
+		assert.Equal(t, admissionv1.PatchTypeJSONPatch, *review.Response.PatchType, "Should keep the patch type")
+	})
+
+	t.Run("TestEncodeV1beta1", func(t *testing.T) {
+		gvk := v1beta1.SchemeGroupVersion.WithKind("AdmissionReview")
+		body, err := encodeAdmissionReview(response, &gvk)
+		assert.Nil(t, err, "Should encode AdmissionReview")
+
+		var review v1beta1.AdmissionReview
+		assert.Nil(t, json.Unmarshal(body, &review), "Should unmarshal AdmissionReview")
+		assert.Equal(t, "admission.k8s.io/v1beta1", review.APIVersion, "Should answer in the request version")
+		assert.True(t, review.Response.Allowed, "Should keep the response")
+	})
+}
+
+func TestWebhookServer_HandlerVersions(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	for _, apiVersion := range []string{"admission.k8s.io/v1", "admission.k8s.io/v1beta1"} {
+		t.Run(apiVersion, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(newAdmissionReviewBody(t, apiVersion, pod)))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+
+			whsvr.Handler(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code, "Should accept the AdmissionReview")
+
+			var review struct {
+				metav1.TypeMeta
+				Response struct {
+					UID     string `json:"uid"`
+					Allowed bool   `json:"allowed"`
+					Patch   []byte `json:"patch"`
+				} `json:"response"`
+			}
+			assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review), "Should unmarshal AdmissionReview")
+			assert.Equal(t, apiVersion, review.APIVersion, "Should answer in the request version")
+			assert.Equal(t, "test-uid", review.Response.UID, "Should answer the request UID")
+			assert.True(t, review.Response.Allowed, "Should allow the pod")
+			assert.NotEmpty(t, review.Response.Patch, "Should return the sidecar patch")
+		})
+	}
+}
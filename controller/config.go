@@ -0,0 +1,333 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultMaxRequestBytes     = 3 * 1024 * 1024
+	DefaultMaxAnnotationLength = 1024
+
+	DefaultNamespaceRetryAttempts   = 3
+	DefaultNamespaceRetryBaseDelay  = 100 * time.Millisecond
+	DefaultNamespaceGetTimeout      = 8 * time.Second
+	DefaultNamespaceBreakerCooldown = 30 * time.Second
+
+	LogLevelInfo  = "info"  // Log the decision for each pod
+	LogLevelDebug = "debug" // Also log the patch of each pod, including its role ARN
+
+	SidecarPositionFirst = "first" // Insert the sidecar before the pod's containers
+	SidecarPositionLast  = "last"  // Append the sidecar after the pod's containers
+)
+
+// Config holds the controller-level settings of the webhook server. It can be loaded
+// from a YAML file with LoadConfig, with command line flags overriding file values.
+type Config struct {
+	MaxRequestBytes     int64 `json:"maxRequestBytes,omitempty"`     // Maximum accepted size of an AdmissionReview request body, unlimited if not positive
+	MaxAnnotationLength int   `json:"maxAnnotationLength,omitempty"` // Maximum length of the values of the pod's settings annotations, unlimited if not positive
+	FailOpen            bool  `json:"failOpen,omitempty"`            // Allow pods unmodified when the namespace cannot be described
+	DryRun              bool  `json:"dryRun,omitempty"`              // Compute and log patches without applying them
+
+	LogLevel string `json:"logLevel,omitempty"` // Verbosity of the controller's logs, "debug" adding the patch of each pod to the "info" logs
+
+	SidecarPosition string `json:"sidecarPosition,omitempty"` // Position of the sidecar among the pod's containers, "first" or "last", last if empty
+
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"` // Requests handled at once before rejecting with 429, unlimited if not positive
+
+	ChaosErrorRate float64         `json:"chaosErrorRate,omitempty"` // Fraction of requests deliberately failed with a 500, requires SIGNING_PROXY_WEBHOOK_ENABLE_CHAOS=true
+	ChaosLatency   metav1.Duration `json:"chaosLatency,omitempty"`   // Delay deliberately added to every request, requires SIGNING_PROXY_WEBHOOK_ENABLE_CHAOS=true
+
+	NamespaceRetryAttempts  int             `json:"namespaceRetryAttempts,omitempty"`  // Attempts at describing the namespace before giving up, a single attempt if not positive
+	NamespaceRetryBaseDelay metav1.Duration `json:"namespaceRetryBaseDelay,omitempty"` // Delay before the first retry, doubled after each further attempt
+	NamespaceGetTimeout     metav1.Duration `json:"namespaceGetTimeout,omitempty"`     // Time allowed for describing the namespace including retries, unlimited if not positive
+
+	NamespaceBreakerThreshold int             `json:"namespaceBreakerThreshold,omitempty"` // Consecutive failures at describing namespaces that trip the circuit breaker, disabled if not positive
+	NamespaceBreakerCooldown  metav1.Duration `json:"namespaceBreakerCooldown,omitempty"`  // Time the tripped breaker fails namespace lookups without calling the API server
+
+	Image         string   `json:"image,omitempty"`         // Sidecar image, overriding the AWS-SIGV4-PROXY-IMAGE environment variable
+	AllowedHosts  []string `json:"allowedHosts,omitempty"`  // Glob patterns of permitted upstream hosts, all hosts are allowed if empty
+	DefaultRegion string   `json:"defaultRegion,omitempty"` // Region used when none can be resolved from annotations, labels or the host
+	RequireDigest bool     `json:"requireDigest,omitempty"` // Reject sidecar images that are not pinned by digest
+
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"` // Default imagePullPolicy of the sidecar, IfNotPresent if empty
+
+	VerifyImage         string          `json:"verifyImage,omitempty"`         // Check that the sidecar image exists in its registry, denying the pod if "deny" or warning if "warn"
+	VerifyImageCacheTTL metav1.Duration `json:"verifyImageCacheTTL,omitempty"` // How long the result of an image check is reused
+
+	ValidateRegions        bool `json:"validateRegions,omitempty"`        // Reject malformed regions and regions missing from the bundled region list
+	AllowUnknownRegions    bool `json:"allowUnknownRegions,omitempty"`    // With ValidateRegions, accept well-formed regions missing from the bundled region list
+	InheritProxyEnv        bool `json:"inheritProxyEnv,omitempty"`        // Pass the controller's HTTP_PROXY, HTTPS_PROXY and NO_PROXY to sidecars without proxy annotations
+	AddEgressLabel         bool `json:"addEgressLabel,omitempty"`         // Label injected pods sigv4-proxy-egress=allowed for NetworkPolicies to select
+	RequireIRSA            bool `json:"requireIRSA,omitempty"`            // Reject pods without a role-arn whose ServiceAccount has no IRSA role
+	NativeSidecars         bool `json:"nativeSidecars,omitempty"`         // Inject the sidecar as an init container with restartPolicy Always
+	AnnotateResolvedConfig bool `json:"annotateResolvedConfig,omitempty"` // Write the resolved sidecar parameters to the resolved-config annotation
+	StrictInject           bool `json:"strictInject,omitempty"`           // Reject pods setting inject=true without a host or service instead of skipping them
+	LimitRangeDefaults     bool `json:"limitRangeDefaults,omitempty"`     // Give sidecars without resources the container defaults of the namespace's LimitRanges
+	SetGOMAXPROCS          bool `json:"setGOMAXPROCS,omitempty"`          // Set the sidecar's GOMAXPROCS from its CPU limit
+	InjectOnUpdate         bool `json:"injectOnUpdate,omitempty"`         // Also inject pods on UPDATE requests, not only on CREATE
+	ReinjectOnChange       bool `json:"reinjectOnChange,omitempty"`       // Replace the sidecar of workload templates whose config checksum is outdated
+	ReinjectMergeArgs      bool `json:"reinjectMergeArgs,omitempty"`      // Keep the flags users added to a replaced sidecar's args
+	ProjectedToken         bool `json:"projectedToken,omitempty"`         // Give sidecars with a role ARN a projected ServiceAccount token to assume it with web identity
+	CheckResourceQuota     bool `json:"checkResourceQuota,omitempty"`     // Deny pods whose sidecar resources exceed the namespace's ResourceQuotas
+
+	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`  // Selector of namespaces injected by default, sidecar-inject=true if unset
+	ExcludedNamespaces []string              `json:"excludedNamespaces,omitempty"` // Namespaces never injected
+	MeshExclusion      []string              `json:"meshExclusion,omitempty"`      // Service meshes, istio or linkerd, annotated to not intercept the sidecar port
+	NamespaceRoleArns  map[string]string     `json:"namespaceRoleArns,omitempty"`  // Default role ARN by namespace, used when neither annotation nor label sets one
+	AnnotationEnv      map[string]string     `json:"annotationEnv,omitempty"`      // Environment variables of the sidecar set from pod annotations, by annotation key
+	ObjectSelector     labels.Selector       `json:"-"`                            // Selector the pod's own labels must match for injection, nil matches all pods. Only set by --object-selector, not read from the file
+	StatusAnnotation   string                `json:"statusAnnotation,omitempty"`   // Annotation marking injected pods, <annotationPrefix>/status if empty
+	AnnotationPrefix   string                `json:"annotationPrefix,omitempty"`   // Prefix of the pod annotations, sidecar.aws.signing-proxy if empty
+
+	NamespaceDefaultsConfigMap string `json:"namespaceDefaultsConfigMap,omitempty"` // <namespace>/<name> of a ConfigMap mapping namespaces to default annotations
+
+	ControllerVersion string `json:"-"` // Build version of the controller, written to the injected-by annotation of injected pods if set
+
+	ArgsTemplate string `json:"argsTemplate,omitempty"` // Go template rendering the sidecar arguments, replacing the built-in arguments if set
+
+	UnixSocketFlag string `json:"unixSocketFlag,omitempty"` // Flag of the proxy image taking the unix socket path to listen on, unix-socket annotations are rejected if neither this nor an args template is set
+
+	Profiles map[string]ProxyProfile `json:"profiles,omitempty"` // Proxy transport profiles selected with the profile annotation, replacing built-in profiles of the same name
+
+	DefaultResources corev1.ResourceRequirements `json:"defaultResources,omitempty"` // Sidecar resources used when the pod has no resource annotations
+
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty"` // DNS nameservers, searches and options merged into the dnsConfig of injected pods
+}
+
+// DefaultConfig returns the configuration used when no config file is provided.
+func DefaultConfig() Config {
+	return Config{
+		MaxRequestBytes:          DefaultMaxRequestBytes,
+		MaxAnnotationLength:      DefaultMaxAnnotationLength,
+		NamespaceRetryAttempts:   DefaultNamespaceRetryAttempts,
+		NamespaceRetryBaseDelay:  metav1.Duration{Duration: DefaultNamespaceRetryBaseDelay},
+		NamespaceGetTimeout:      metav1.Duration{Duration: DefaultNamespaceGetTimeout},
+		NamespaceBreakerCooldown: metav1.Duration{Duration: DefaultNamespaceBreakerCooldown},
+		VerifyImageCacheTTL:      metav1.Duration{Duration: DefaultVerifyImageCacheTTL},
+	}
+}
+
+// LoadConfig reads the YAML config file at path on top of DefaultConfig. The defaults
+// are returned unchanged if path is empty. A file that does not exist is an error, since
+// the path was set explicitly.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return Config{}, fmt.Errorf("Error reading config file %s: %v", path, err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return Config{}, fmt.Errorf("Error parsing config file %s: %v", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// ParseAnnotationEnv parses a comma separated list of <annotation>=<ENV_VAR> mappings, such
+// as team.example.com/team=SIDECAR_TEAM, into the AnnotationEnv setting.
+func ParseAnnotationEnv(value string) (map[string]string, error) {
+	annotationEnv := map[string]string{}
+
+	for _, mapping := range strings.Split(value, ",") {
+		if mapping = strings.TrimSpace(mapping); mapping == "" {
+			continue
+		}
+
+		annotation, env, ok := strings.Cut(mapping, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid annotation env mapping %q: expected <annotation>=<ENV_VAR>", mapping)
+		}
+
+		annotationEnv[strings.TrimSpace(annotation)] = strings.TrimSpace(env)
+	}
+
+	if err := validateAnnotationEnv(annotationEnv); err != nil {
+		return nil, err
+	}
+
+	return annotationEnv, nil
+}
+
+func validateAnnotationEnv(annotationEnv map[string]string) error {
+	for annotation, env := range annotationEnv {
+		if errs := validation.IsQualifiedName(annotation); len(errs) > 0 {
+			return fmt.Errorf("Invalid annotation %q in annotation env mapping: %s", annotation, strings.Join(errs, ", "))
+		}
+
+		if errs := validation.IsEnvVarName(env); len(errs) > 0 {
+			return fmt.Errorf("Invalid environment variable %q for annotation %s: %s", env, annotation, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// validatePullPolicy rejects values other than Always, IfNotPresent and Never.
+func validatePullPolicy(pullPolicy corev1.PullPolicy) error {
+	switch pullPolicy {
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		return nil
+	default:
+		return fmt.Errorf("Invalid image pull policy %q: expected %s, %s or %s", pullPolicy, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever)
+	}
+}
+
+// proxyImage returns the sidecar image, taken from the config, the AWS-SIGV4-PROXY-IMAGE
+// environment variable or the latest public image.
+func (config *Config) proxyImage() string {
+	return resolve(config.Image, os.Getenv("AWS-SIGV4-PROXY-IMAGE"), "public.ecr.aws/aws-observability/aws-sigv4-proxy:latest")
+}
+
+// Validate checks the settings that cannot be verified while parsing.
+func (config *Config) Validate() error {
+	if err := ValidateHostPatterns(config.AllowedHosts); err != nil {
+		return err
+	}
+
+	if err := ValidateMeshExclusion(config.MeshExclusion); err != nil {
+		return err
+	}
+
+	if config.AnnotationPrefix != "" {
+		if errs := validation.IsDNS1123Subdomain(config.AnnotationPrefix); len(errs) > 0 {
+			return fmt.Errorf("Invalid annotation prefix %q: %s", config.AnnotationPrefix, strings.Join(errs, ", "))
+		}
+	}
+
+	if config.StatusAnnotation != "" {
+		if errs := validation.IsQualifiedName(config.StatusAnnotation); len(errs) > 0 {
+			return fmt.Errorf("Invalid status annotation %q: %s", config.StatusAnnotation, strings.Join(errs, ", "))
+		}
+	}
+
+	if config.ArgsTemplate != "" {
+		if _, err := parseArgsTemplate(config.ArgsTemplate); err != nil {
+			return err
+		}
+	}
+
+	if config.UnixSocketFlag != "" && (!strings.HasPrefix(config.UnixSocketFlag, "-") || strings.ContainsAny(config.UnixSocketFlag, " \t=")) {
+		return fmt.Errorf("Invalid unix socket flag %q: expected a flag name such as --unix-socket", config.UnixSocketFlag)
+	}
+
+	if err := validateAnnotationEnv(config.AnnotationEnv); err != nil {
+		return err
+	}
+
+	for namespace, roleArn := range config.NamespaceRoleArns {
+		if !strings.HasPrefix(roleArn, "arn:") {
+			return fmt.Errorf("Invalid role ARN %q for namespace %s", roleArn, namespace)
+		}
+	}
+
+	if config.NamespaceDefaultsConfigMap != "" {
+		if _, _, err := splitNamespaceDefaultsConfigMap(config.NamespaceDefaultsConfigMap); err != nil {
+			return err
+		}
+	}
+
+	if err := validateDNSConfig(config.DNSConfig); err != nil {
+		return err
+	}
+
+	// The sidecar image is the same for every pod, unlike the transparent init image, which is
+	// only checked for the pods using it.
+	if err := validateImage(config.proxyImage(), config.RequireDigest); err != nil {
+		return err
+	}
+
+	if config.ImagePullPolicy != "" {
+		if err := validatePullPolicy(config.ImagePullPolicy); err != nil {
+			return err
+		}
+	}
+
+	switch config.LogLevel {
+	case "", LogLevelInfo, LogLevelDebug:
+	default:
+		return fmt.Errorf("Invalid log level %q: expected %s or %s", config.LogLevel, LogLevelInfo, LogLevelDebug)
+	}
+
+	switch config.SidecarPosition {
+	case "", SidecarPositionFirst, SidecarPositionLast:
+	default:
+		return fmt.Errorf("Invalid sidecar position %q: expected %s or %s", config.SidecarPosition, SidecarPositionFirst, SidecarPositionLast)
+	}
+
+	switch config.VerifyImage {
+	case "", VerifyImageDeny, VerifyImageWarn:
+	default:
+		return fmt.Errorf("Invalid image verification mode %q: expected %s or %s", config.VerifyImage, VerifyImageDeny, VerifyImageWarn)
+	}
+
+	if config.ChaosErrorRate < 0 || config.ChaosErrorRate > 1 {
+		return fmt.Errorf("Invalid chaos error rate %v: expected a fraction between 0 and 1", config.ChaosErrorRate)
+	}
+
+	if config.ChaosLatency.Duration < 0 {
+		return fmt.Errorf("Invalid chaos latency %s", config.ChaosLatency.Duration)
+	}
+
+	if config.NamespaceRetryBaseDelay.Duration < 0 {
+		return fmt.Errorf("Invalid namespace retry base delay %s", config.NamespaceRetryBaseDelay.Duration)
+	}
+
+	if config.NamespaceBreakerThreshold > 0 && config.NamespaceBreakerCooldown.Duration <= 0 {
+		return fmt.Errorf("Invalid namespace circuit breaker cooldown %s: must be positive", config.NamespaceBreakerCooldown.Duration)
+	}
+
+	for name, profile := range config.Profiles {
+		if name != strings.ToLower(strings.TrimSpace(name)) || name == "" {
+			return fmt.Errorf("Invalid profile name %q: must be lowercase", name)
+		}
+
+		if err := profile.validate(); err != nil {
+			return fmt.Errorf("Invalid profile %s: %v", name, err)
+		}
+	}
+
+	if config.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(config.NamespaceSelector); err != nil {
+			return fmt.Errorf("Invalid namespace selector: %v", err)
+		}
+	}
+
+	return nil
+}
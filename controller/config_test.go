@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const sampleConfig = `
+image: registry.example.com/aws-sigv4-proxy:1.8
+defaultRegion: us-west-2
+failOpen: true
+allowedHosts:
+  - "*.us-west-2.amazonaws.com"
+namespaceSelector:
+  matchLabels:
+    sigv4-proxy: enabled
+excludedNamespaces:
+  - kube-system
+defaultResources:
+  requests:
+    cpu: 100m
+    memory: 64Mi
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600), "Should write config file")
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("TestLoadSampleConfig", func(t *testing.T) {
+		config, err := LoadConfig(writeConfig(t, sampleConfig))
+		assert.Nil(t, err, "Should load the config file")
+
+		assert.Equal(t, "registry.example.com/aws-sigv4-proxy:1.8", config.Image)
+		assert.Equal(t, "us-west-2", config.DefaultRegion)
+		assert.True(t, config.FailOpen)
+		assert.False(t, config.DryRun)
+		assert.Equal(t, []string{"*.us-west-2.amazonaws.com"}, config.AllowedHosts)
+		assert.Equal(t, &metav1.LabelSelector{MatchLabels: map[string]string{"sigv4-proxy": "enabled"}}, config.NamespaceSelector)
+		assert.Equal(t, []string{"kube-system"}, config.ExcludedNamespaces)
+		assert.Equal(t, corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		}, config.DefaultResources.Requests)
+		assert.Equal(t, int64(DefaultMaxRequestBytes), config.MaxRequestBytes, "Should keep defaults for values not in the file")
+	})
+
+	t.Run("TestLoadConfigNoPath", func(t *testing.T) {
+		config, err := LoadConfig("")
+		assert.Nil(t, err, "Should succeed")
+		assert.Equal(t, DefaultConfig(), config, "Should return the defaults")
+	})
+
+	t.Run("TestLoadConfigFileAbsent", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.NotNil(t, err, "Should fail when the config file set explicitly does not exist")
+	})
+
+	t.Run("TestLoadConfigUnknownField", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "defaultRegon: us-west-2\n"))
+		assert.NotNil(t, err, "Should reject unknown fields")
+	})
+
+	t.Run("TestLoadConfigInvalidAllowedHosts", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "allowedHosts: [\"[\"]\n"))
+		assert.NotNil(t, err, "Should reject malformed host patterns")
+	})
+
+	t.Run("TestLoadConfigInvalidNamespaceRoleArn", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "namespaceRoleArns:\n  prod: role/prod\n"))
+		assert.NotNil(t, err, "Should reject a namespace role that is not an ARN")
+	})
+
+	t.Run("TestLoadConfigInvalidStatusAnnotation", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "statusAnnotation: \"not a key\"\n"))
+		assert.NotNil(t, err, "Should reject an invalid status annotation key")
+	})
+
+	t.Run("TestLoadConfigInvalidUnixSocketFlag", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "unixSocketFlag: unix-socket\n"))
+		assert.NotNil(t, err, "Should reject a unix socket flag that is not a flag name")
+	})
+
+	t.Run("TestLoadConfigInvalidVerifyImage", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "verifyImage: block\n"))
+		assert.NotNil(t, err, "Should reject an unknown image verification mode")
+	})
+
+	t.Run("TestLoadConfigInvalidBreakerCooldown", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "namespaceBreakerThreshold: 5\nnamespaceBreakerCooldown: 0s\n"))
+		assert.NotNil(t, err, "Should reject a circuit breaker without a cooldown")
+	})
+
+	t.Run("TestLoadConfigProfiles", func(t *testing.T) {
+		config, err := LoadConfig(writeConfig(t, "profiles:\n  batch:\n    transportMaxIdleConns: 10\n    transportIdleConnTimeout: 2m\n"))
+		assert.Nil(t, err, "Should load the profiles")
+		assert.Equal(t, 10, *config.Profiles["batch"].TransportMaxIdleConns, "Should load the maximum idle connections")
+		assert.Equal(t, 2*time.Minute, config.Profiles["batch"].TransportIdleConnTimeout.Duration, "Should load the idle timeout")
+		assert.Nil(t, config.Profiles["batch"].IdleConnectionTimeout, "Should leave unset settings nil")
+	})
+
+	t.Run("TestLoadConfigInvalidProfile", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "profiles:\n  batch:\n    transportMaxIdleConns: -1\n"))
+		assert.NotNil(t, err, "Should reject a negative connection count")
+
+		_, err = LoadConfig(writeConfig(t, "profiles:\n  Batch: {}\n"))
+		assert.NotNil(t, err, "Should reject a profile name that is not lowercase")
+	})
+
+	t.Run("TestLoadConfigInvalidSidecarPosition", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "sidecarPosition: middle\n"))
+		assert.NotNil(t, err, "Should reject an unknown sidecar position")
+	})
+
+	t.Run("TestLoadConfigDNSConfig", func(t *testing.T) {
+		config, err := LoadConfig(writeConfig(t, "dnsConfig:\n  nameservers: [10.0.0.2]\n  options:\n    - name: ndots\n      value: \"2\"\n"))
+		assert.Nil(t, err, "Should load the DNS config")
+		assert.Equal(t, []string{"10.0.0.2"}, config.DNSConfig.Nameservers)
+		assert.Equal(t, "ndots", config.DNSConfig.Options[0].Name)
+	})
+
+	t.Run("TestLoadConfigInvalidDNSNameserver", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "dnsConfig:\n  nameservers: [dns.example.com]\n"))
+		assert.NotNil(t, err, "Should reject a nameserver that is not an IP address")
+	})
+}
+
+func TestWebhookServer_configNamespaces(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, sampleConfig))
+	assert.Nil(t, err, "Should load the config file")
+
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: nil,
+		config:          config,
+	}
+
+	podObjectMeta := &metav1.ObjectMeta{
+		Annotations: map[string]string{signingProxyWebhookAnnotationHostKey: "aps.us-west-2.amazonaws.com"},
+	}
+
+	assert.True(t, whsvr.shouldMutate(map[string]string{"sigv4-proxy": "enabled"}, podObjectMeta), "Should inject namespaces matching the configured selector")
+	assert.False(t, whsvr.shouldMutate(map[string]string{"sidecar-inject": "true"}, podObjectMeta), "Should not inject namespaces matching only the default selector")
+	assert.True(t, whsvr.isNamespaceExcluded("kube-system"), "Should exclude the configured namespaces")
+	assert.False(t, whsvr.isNamespaceExcluded("default"), "Should not exclude other namespaces")
+	assert.Equal(t, "registry.example.com/aws-sigv4-proxy:1.8", whsvr.getProxyImage(), "Should use the configured image")
+}
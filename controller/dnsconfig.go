@@ -0,0 +1,89 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// validateDNSConfig checks the DNSConfig setting, whose nameservers must be IP addresses.
+func validateDNSConfig(dnsConfig *corev1.PodDNSConfig) error {
+	if dnsConfig == nil {
+		return nil
+	}
+
+	for _, nameserver := range dnsConfig.Nameservers {
+		if net.ParseIP(nameserver) == nil {
+			return fmt.Errorf("Invalid DNS nameserver %q: must be an IP address", nameserver)
+		}
+	}
+
+	for _, option := range dnsConfig.Options {
+		if option.Name == "" {
+			return fmt.Errorf("Invalid DNS option: name must not be empty")
+		}
+	}
+
+	return nil
+}
+
+// addDNSConfig returns the patch merging dnsConfig into the pod's DNS config. Nameservers and
+// search domains missing from the pod are appended, and options are added unless the pod
+// already sets an option of the same name, whose value is kept.
+func addDNSConfig(existing *corev1.PodDNSConfig, dnsConfig *corev1.PodDNSConfig, path string) PatchOperation {
+	if existing == nil {
+		return PatchOperation{Op: "add", Path: path, Value: dnsConfig}
+	}
+
+	merged := existing.DeepCopy()
+	merged.Nameservers = appendMissing(merged.Nameservers, dnsConfig.Nameservers)
+	merged.Searches = appendMissing(merged.Searches, dnsConfig.Searches)
+
+	options := map[string]bool{}
+
+	for _, option := range merged.Options {
+		options[option.Name] = true
+	}
+
+	for _, option := range dnsConfig.Options {
+		if !options[option.Name] {
+			merged.Options = append(merged.Options, *option.DeepCopy())
+		}
+	}
+
+	return PatchOperation{Op: "replace", Path: path, Value: merged}
+}
+
+// appendMissing appends the values not yet in values, keeping their order.
+func appendMissing(values []string, additions []string) []string {
+	present := map[string]bool{}
+
+	for _, value := range values {
+		present[value] = true
+	}
+
+	for _, addition := range additions {
+		if !present[addition] {
+			values = append(values, addition)
+			present[addition] = true
+		}
+	}
+
+	return values
+}
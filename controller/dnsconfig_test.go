@@ -0,0 +1,124 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWebhookServer_mutateDNSConfig(t *testing.T) {
+	ndots, two, five := "ndots", "2", "5"
+
+	dnsConfig := &corev1.PodDNSConfig{
+		Nameservers: []string{"10.0.0.2"},
+		Searches:    []string{"vpce.amazonaws.com"},
+		Options:     []corev1.PodDNSConfigOption{{Name: ndots, Value: &two}, {Name: "edns0"}},
+	}
+
+	var testCases = []struct {
+		name         string
+		existing     *corev1.PodDNSConfig
+		expected     *corev1.PodDNSConfig
+		errorMessage string
+	}{
+		{
+			name:         "TestNoDNSConfig",
+			existing:     nil,
+			expected:     dnsConfig,
+			errorMessage: "Should add the configured DNS config",
+		},
+		{
+			name: "TestExistingDNSConfig",
+			existing: &corev1.PodDNSConfig{
+				Nameservers: []string{"10.0.0.10", "10.0.0.2"},
+				Searches:    []string{"svc.cluster.local"},
+				Options:     []corev1.PodDNSConfigOption{{Name: ndots, Value: &five}},
+			},
+			expected: &corev1.PodDNSConfig{
+				Nameservers: []string{"10.0.0.10", "10.0.0.2"},
+				Searches:    []string{"svc.cluster.local", "vpce.amazonaws.com"},
+				Options:     []corev1.PodDNSConfigOption{{Name: ndots, Value: &five}, {Name: "edns0"}},
+			},
+			errorMessage: "Should merge into the pod's DNS config, keeping its options",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{DNSConfig: dnsConfig},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app"}},
+					DNSConfig:  tc.existing,
+				},
+			}
+
+			admissionReview := newAdmissionReview(t, pod)
+
+			response, err := whsvr.mutate(context.Background(), admissionReview)
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			patch, err := jsonpatch.DecodePatch(response.Patch)
+			assert.Nil(t, err, "Should decode the patch")
+
+			patched, err := patch.Apply(admissionReview.Request.Object.Raw)
+			assert.Nil(t, err, "Should apply the patch")
+
+			var patchedPod corev1.Pod
+			assert.Nil(t, json.Unmarshal(patched, &patchedPod), "Should decode the patched pod")
+			assert.Equal(t, tc.expected, patchedPod.Spec.DNSConfig, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestNotConfigured", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		for _, operation := range decodePatch(t, response) {
+			assert.NotEqual(t, "/spec/dnsConfig", operation.Path, "Should not patch the DNS config unless configured")
+		}
+	})
+}
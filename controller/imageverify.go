@@ -0,0 +1,316 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VerifyImageDeny = "deny" // Reject pods whose sidecar image cannot be found
+	VerifyImageWarn = "warn" // Inject the sidecar with a warning if its image cannot be found
+
+	DefaultVerifyImageCacheTTL = 5 * time.Minute
+
+	defaultRegistry = "registry-1.docker.io"
+
+	// registryRequestTimeout bounds a lookup, including any token request, when the admission
+	// request has no deadline. Otherwise the lookup ends registryResponseMargin before it, so
+	// that the controller still answers before the API server's webhook timeout.
+	registryRequestTimeout = 5 * time.Second
+	registryResponseMargin = time.Second
+
+	// registryErrorCacheTTL is how long a failed lookup is reused, short so that a transient
+	// registry error does not affect pods for the whole cache TTL.
+	registryErrorCacheTTL = 5 * time.Second
+)
+
+// manifestAcceptTypes are the manifest media types accepted from registries, so that
+// registries serving only OCI or multi-arch manifests do not answer 404.
+var manifestAcceptTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// registryClient checks image manifests in a container registry.
+type registryClient interface {
+	manifestExists(ctx context.Context, image string) (bool, error)
+}
+
+// imageVerifier checks that sidecar images exist before they are injected, caching the
+// result of each image so that registries are not queried for every pod.
+type imageVerifier struct {
+	client registryClient
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]imageVerification
+}
+
+type imageVerification struct {
+	err     error
+	expires time.Time
+}
+
+func newImageVerifier(client registryClient, ttl time.Duration) *imageVerifier {
+	return &imageVerifier{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+		cache:  map[string]imageVerification{},
+	}
+}
+
+// verify returns an error if image is not found in its registry or the registry cannot be
+// reached. Whether the image exists is cached for the TTL. Registry errors are cached for
+// registryErrorCacheTTL, so an unreachable registry is not retried for every pod, and errors
+// caused by the admission request ending are not cached.
+func (v *imageVerifier) verify(ctx context.Context, image string) error {
+	v.mu.Lock()
+	cached, ok := v.cache[image]
+	v.mu.Unlock()
+
+	if ok && v.now().Before(cached.expires) {
+		return cached.err
+	}
+
+	lookupDeadline := time.Now().Add(registryRequestTimeout)
+
+	if deadline, ok := ctx.Deadline(); ok && deadline.Add(-registryResponseMargin).Before(lookupDeadline) {
+		lookupDeadline = deadline.Add(-registryResponseMargin)
+	}
+
+	if !time.Now().Before(lookupDeadline) {
+		return fmt.Errorf("Error verifying image %q: no time left before the webhook timeout", image)
+	}
+
+	lookupCtx, cancel := context.WithDeadline(ctx, lookupDeadline)
+	defer cancel()
+
+	var err error
+	ttl := v.ttl
+	found, lookupErr := v.client.manifestExists(lookupCtx, image)
+
+	if lookupErr != nil {
+		err = fmt.Errorf("Error verifying image %q: %v", image, lookupErr)
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if ttl > registryErrorCacheTTL {
+			ttl = registryErrorCacheTTL
+		}
+	} else if !found {
+		err = fmt.Errorf("Image %q not found in its registry", image)
+	}
+
+	v.mu.Lock()
+	v.cache[image] = imageVerification{err: err, expires: v.now().Add(ttl)}
+	v.mu.Unlock()
+
+	return err
+}
+
+// httpRegistryClient queries registries with the Docker Registry HTTP API V2, requesting
+// an anonymous token when the registry asks for one. Registries requiring credentials,
+// such as private ECR repositories, are reported as errors. Requests are bounded by the
+// context passed by imageVerifier rather than a client timeout.
+type httpRegistryClient struct {
+	client *http.Client
+}
+
+func newHTTPRegistryClient() *httpRegistryClient {
+	return &httpRegistryClient{client: &http.Client{}}
+}
+
+func (c *httpRegistryClient) manifestExists(ctx context.Context, image string) (bool, error) {
+	registry, repository, reference := parseImageReference(image)
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, reference)
+
+	response, err := c.headManifest(ctx, manifestURL, "")
+
+	if err != nil {
+		return false, err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized {
+		token, err := c.anonymousToken(ctx, response.Header.Get("WWW-Authenticate"))
+
+		if err != nil {
+			return false, err
+		}
+
+		if response, err = c.headManifest(ctx, manifestURL, token); err != nil {
+			return false, err
+		}
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Unexpected response %s from registry %s", response.Status, registry)
+	}
+}
+
+func (c *httpRegistryClient) headManifest(ctx context.Context, manifestURL string, token string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Accept", strings.Join(manifestAcceptTypes, ", "))
+
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := c.client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body.Close()
+
+	return response, nil
+}
+
+// anonymousToken requests a pull token from the realm of a Bearer WWW-Authenticate challenge.
+func (c *httpRegistryClient) anonymousToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("Registry requires unsupported authentication %q", challenge)
+	}
+
+	params := parseAuthChallenge(challenge[len("bearer "):])
+
+	if params["realm"] == "" {
+		return "", fmt.Errorf("Registry authentication challenge %q has no realm", challenge)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+
+	if err != nil {
+		return "", fmt.Errorf("Invalid registry authentication realm %q: %v", params["realm"], err)
+	}
+
+	query := tokenURL.Query()
+
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+
+	tokenURL.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	response, err := c.client.Do(request)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected response %s requesting a registry token", response.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("Error decoding registry token: %v", err)
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("Registry token response has no token")
+}
+
+// parseAuthChallenge parses the comma separated key="value" parameters of a challenge.
+func parseAuthChallenge(params string) map[string]string {
+	parsed := map[string]string{}
+
+	for _, param := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+
+		if ok {
+			parsed[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return parsed
+}
+
+// parseImageReference splits an image reference into its registry, repository and tag or
+// digest, applying the Docker Hub defaults for references without a registry.
+func parseImageReference(image string) (string, string, string) {
+	name, reference := image, "latest"
+
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		name, reference = image[:i], image[i+1:]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, reference = image[:i], image[i+1:]
+	}
+
+	registry, repository := defaultRegistry, name
+
+	if i := strings.Index(name, "/"); i >= 0 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			registry, repository = first, name[i+1:]
+		}
+	}
+
+	if registry == "docker.io" || registry == "index.docker.io" {
+		registry = defaultRegistry
+	}
+
+	if registry == defaultRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+
+	return registry, repository, reference
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRegistryClient reports the images in found as existing, counts lookups and records the
+// deadline of the last lookup.
+type fakeRegistryClient struct {
+	found    map[string]bool
+	err      error
+	lookups  int
+	deadline time.Time
+}
+
+func (c *fakeRegistryClient) manifestExists(ctx context.Context, image string) (bool, error) {
+	c.lookups++
+	c.deadline, _ = ctx.Deadline()
+	return c.found[image], c.err
+}
+
+func TestImageVerifier_verify(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		found        map[string]bool
+		err          error
+		valid        bool
+		errorMessage string
+	}{
+		{
+			name:         "TestImageFound",
+			found:        map[string]bool{"public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8": true},
+			valid:        true,
+			errorMessage: "Should accept an existing image",
+		},
+		{
+			name:         "TestImageNotFound",
+			found:        map[string]bool{},
+			valid:        false,
+			errorMessage: "Should reject a missing image",
+		},
+		{
+			name:         "TestRegistryUnreachable",
+			err:          errors.New("connection refused"),
+			valid:        false,
+			errorMessage: "Should reject an image that cannot be verified",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := newImageVerifier(&fakeRegistryClient{found: tc.found, err: tc.err}, time.Minute)
+			err := verifier.verify(context.Background(), "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8")
+			assert.Equal(t, tc.valid, err == nil, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestCachedUntilExpiry", func(t *testing.T) {
+		client := &fakeRegistryClient{found: map[string]bool{}}
+		verifier := newImageVerifier(client, time.Minute)
+		now := time.Now()
+		verifier.now = func() time.Time { return now }
+
+		assert.NotNil(t, verifier.verify(context.Background(), "proxy:typo"), "Should reject a missing image")
+		client.found["proxy:typo"] = true
+		assert.NotNil(t, verifier.verify(context.Background(), "proxy:typo"), "Should reuse the cached result")
+		assert.Equal(t, 1, client.lookups, "Should query the registry once")
+
+		now = now.Add(2 * time.Minute)
+		assert.Nil(t, verifier.verify(context.Background(), "proxy:typo"), "Should query the registry again after expiry")
+		assert.Equal(t, 2, client.lookups, "Should query the registry after expiry")
+	})
+
+	t.Run("TestRegistryErrorCachedBriefly", func(t *testing.T) {
+		client := &fakeRegistryClient{err: errors.New("503 Service Unavailable")}
+		verifier := newImageVerifier(client, time.Minute)
+		now := time.Now()
+		verifier.now = func() time.Time { return now }
+
+		assert.NotNil(t, verifier.verify(context.Background(), "proxy:1.8"), "Should reject an image that cannot be verified")
+		assert.NotNil(t, verifier.verify(context.Background(), "proxy:1.8"), "Should reuse the error")
+		assert.Equal(t, 1, client.lookups, "Should not query the registry again right away")
+
+		client.err, client.found = nil, map[string]bool{"proxy:1.8": true}
+		now = now.Add(registryErrorCacheTTL + time.Second)
+		assert.Nil(t, verifier.verify(context.Background(), "proxy:1.8"), "Should query the registry again before the cache TTL")
+		assert.Equal(t, 2, client.lookups, "Should query the registry after the error expired")
+	})
+
+	t.Run("TestCanceledRequestNotCached", func(t *testing.T) {
+		client := &fakeRegistryClient{err: context.Canceled}
+		verifier := newImageVerifier(client, time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.NotNil(t, verifier.verify(ctx, "proxy:1.8"), "Should fail the canceled request")
+
+		client.err, client.found = nil, map[string]bool{"proxy:1.8": true}
+		assert.Nil(t, verifier.verify(context.Background(), "proxy:1.8"), "Should not reuse the error of a canceled request")
+		assert.Equal(t, 2, client.lookups, "Should query the registry again")
+	})
+
+	t.Run("TestRequestDeadline", func(t *testing.T) {
+		client := &fakeRegistryClient{found: map[string]bool{"proxy:1.8": true}}
+		verifier := newImageVerifier(client, time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		assert.Nil(t, verifier.verify(ctx, "proxy:1.8"), "Should accept an existing image")
+
+		requestDeadline, _ := ctx.Deadline()
+		assert.False(t, client.deadline.After(requestDeadline.Add(-registryResponseMargin)), "Should end the lookup before the request deadline")
+	})
+
+	t.Run("TestNoTimeLeft", func(t *testing.T) {
+		client := &fakeRegistryClient{found: map[string]bool{"proxy:1.8": true}}
+		verifier := newImageVerifier(client, time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), registryResponseMargin/2)
+		defer cancel()
+
+		assert.NotNil(t, verifier.verify(ctx, "proxy:1.8"), "Should fail without time for the lookup")
+		assert.Equal(t, 0, client.lookups, "Should not query the registry")
+	})
+}
+
+func TestParseImageReference(t *testing.T) {
+	var testCases = []struct {
+		image      string
+		registry   string
+		repository string
+		reference  string
+	}{
+		{"public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8", "public.ecr.aws", "aws-observability/aws-sigv4-proxy", "1.8"},
+		{"public.ecr.aws/aws-observability/aws-sigv4-proxy", "public.ecr.aws", "aws-observability/aws-sigv4-proxy", "latest"},
+		{"localhost:5000/proxy@sha256:abc", "localhost:5000", "proxy", "sha256:abc"},
+		{"nginx", "registry-1.docker.io", "library/nginx", "latest"},
+		{"docker.io/example/proxy:v1", "registry-1.docker.io", "example/proxy", "v1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.image, func(t *testing.T) {
+			registry, repository, reference := parseImageReference(tc.image)
+			assert.Equal(t, tc.registry, registry, "Registry should match")
+			assert.Equal(t, tc.repository, repository, "Repository should match")
+			assert.Equal(t, tc.reference, reference, "Reference should match")
+		})
+	}
+}
+
+func TestHTTPRegistryClient_manifestExists(t *testing.T) {
+	var server *httptest.Server
+
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			assert.Equal(t, "repository:proxy:pull", r.URL.Query().Get("scope"), "Should request a pull token for the repository")
+			w.Write([]byte(`{"token": "anonymous"}`))
+		case r.Header.Get("Authorization") != "Bearer anonymous":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:proxy:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/proxy/manifests/1.8":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &httpRegistryClient{client: server.Client()}
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	found, err := client.manifestExists(context.Background(), registry+"/proxy:1.8")
+	assert.Nil(t, err, "Should succeed")
+	assert.True(t, found, "Should find the tagged image")
+
+	found, err = client.manifestExists(context.Background(), registry+"/proxy:1.9")
+	assert.Nil(t, err, "Should succeed")
+	assert.False(t, found, "Should not find a missing tag")
+}
+
+func TestWebhookServer_mutateVerifyImage(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		mode         string
+		found        bool
+		allowed      bool
+		warned       bool
+		errorMessage string
+	}{
+		{
+			name:         "TestImageFound",
+			mode:         VerifyImageDeny,
+			found:        true,
+			allowed:      true,
+			errorMessage: "Should inject an existing image",
+		},
+		{
+			name:         "TestImageNotFoundDeny",
+			mode:         VerifyImageDeny,
+			found:        false,
+			allowed:      false,
+			errorMessage: "Should deny a missing image",
+		},
+		{
+			name:         "TestImageNotFoundWarn",
+			mode:         VerifyImageWarn,
+			found:        false,
+			allowed:      true,
+			warned:       true,
+			errorMessage: "Should inject a missing image with a warning",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("AWS-SIGV4-PROXY-IMAGE", "")
+
+			whsvr := &WebhookServer{
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{Image: "public.ecr.aws/aws-observability/aws-sigv4-proxy:typo", VerifyImage: tc.mode},
+				imageVerifier: newImageVerifier(&fakeRegistryClient{
+					found: map[string]bool{"public.ecr.aws/aws-observability/aws-sigv4-proxy:typo": tc.found},
+				}, time.Minute),
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+			assert.Equal(t, tc.allowed, len(response.Patch) > 0, tc.errorMessage)
+			assert.Equal(t, tc.warned, len(response.Warnings) > 0, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_HandlerVerifyImageTimeout(t *testing.T) {
+	t.Setenv("AWS-SIGV4-PROXY-IMAGE", "")
+
+	client := &fakeRegistryClient{found: map[string]bool{"public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8": true}}
+
+	whsvr := &WebhookServer{
+		namespaceClient: newNamespaceClient(map[string]string{}),
+		config:          Config{Image: "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8", VerifyImage: VerifyImageDeny},
+		imageVerifier:   newImageVerifier(client, time.Minute),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/mutate?timeout=3s", bytes.NewReader(newAdmissionReviewBody(t, "admission.k8s.io/v1", pod)))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+
+	whsvr.Handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "Should accept the AdmissionReview")
+	assert.Equal(t, 1, client.lookups, "Should verify the image")
+	assert.True(t, client.deadline.Before(start.Add(3*time.Second-registryResponseMargin).Add(time.Millisecond)), "Should end the lookup before the API server's timeout")
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// podTemplatePaths maps the kinds whose pods can be injected offline to the path of their pod
+// template. Pods are patched as a whole.
+var podTemplatePaths = map[string][]string{
+	"Pod":                   nil,
+	"PodTemplate":           {"template"},
+	"Deployment":            {"spec", "template"},
+	"StatefulSet":           {"spec", "template"},
+	"DaemonSet":             {"spec", "template"},
+	"ReplicaSet":            {"spec", "template"},
+	"ReplicationController": {"spec", "template"},
+	"Job":                   {"spec", "template"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template"},
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// InjectManifest injects the sidecar into the Pods and pod templates of a YAML manifest
+// without a cluster, as the webhook would for pods in namespace with the given labels.
+// Documents of other kinds are passed through unchanged. Checks that need the API server,
+// such as the IRSA ServiceAccount check, are skipped.
+func InjectManifest(config Config, namespace string, nsLabels map[string]string, manifest []byte) ([]byte, error) {
+	config.RequireIRSA = false
+	config.VerifyImage = ""
+	config.DryRun = false
+
+	whsvr := &WebhookServer{config: config}
+
+	var output [][]byte
+
+	for _, document := range yamlDocumentSeparator.Split(string(manifest), -1) {
+		if len(bytes.TrimSpace([]byte(document))) == 0 {
+			continue
+		}
+
+		injected, err := whsvr.injectDocument(namespace, nsLabels, []byte(document))
+
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, injected)
+	}
+
+	return bytes.Join(output, []byte("---\n")), nil
+}
+
+// injectDocument patches the pod or pod template of a single YAML document.
+func (whsvr *WebhookServer) injectDocument(namespace string, nsLabels map[string]string, document []byte) ([]byte, error) {
+	var object map[string]interface{}
+
+	if err := yaml.Unmarshal(document, &object); err != nil {
+		return nil, fmt.Errorf("Error parsing manifest: %v", err)
+	}
+
+	kind, _ := object["kind"].(string)
+	templatePath, ok := podTemplatePaths[kind]
+
+	if !ok {
+		return document, nil
+	}
+
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+
+	if ns, _ := metadata["namespace"].(string); ns != "" {
+		namespace = ns
+	}
+
+	parent, key := object, ""
+
+	if len(templatePath) > 0 {
+		for _, field := range templatePath[:len(templatePath)-1] {
+			if parent, ok = parent[field].(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s %s has no pod template", kind, name)
+			}
+		}
+
+		key = templatePath[len(templatePath)-1]
+	}
+
+	template := object
+
+	if key != "" {
+		if template, ok = parent[key].(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s %s has no pod template", kind, name)
+		}
+	}
+
+	if _, ok := template["metadata"].(map[string]interface{}); !ok {
+		template["metadata"] = map[string]interface{}{}
+	}
+
+	raw, err := json.Marshal(template)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding pod of %s %s: %v", kind, name, err)
+	}
+
+	var pod corev1.Pod
+
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		return nil, fmt.Errorf("Error decoding pod of %s %s: %v", kind, name, err)
+	}
+
+	pod.Namespace = namespace
+
+	patchOperations, err := whsvr.BuildPatch(pod, nsLabels)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error injecting %s %s: %v", kind, name, err)
+	}
+
+	if len(patchOperations) == 0 {
+		return document, nil
+	}
+
+	// The patch is applied to the template as written, so that fields the pod type does not
+	// know about are kept.
+	patchBytes, err := json.Marshal(patchOperations)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling patch: %v", err)
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding patch: %v", err)
+	}
+
+	patched, err := patch.Apply(raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error patching %s %s: %v", kind, name, err)
+	}
+
+	var patchedTemplate map[string]interface{}
+
+	if err := json.Unmarshal(patched, &patchedTemplate); err != nil {
+		return nil, fmt.Errorf("Error decoding patched %s %s: %v", kind, name, err)
+	}
+
+	if key == "" {
+		object = patchedTemplate
+	} else {
+		parent[key] = patchedTemplate
+	}
+
+	return yaml.Marshal(object)
+}
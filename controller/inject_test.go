@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const injectPodManifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+  annotations:
+    sidecar.aws.signing-proxy/inject: "true"
+    sidecar.aws.signing-proxy/host: aps-workspaces.us-west-2.amazonaws.com
+spec:
+  containers:
+  - name: app
+    image: app:1.0
+`
+
+const injectDeploymentManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: monitoring
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+      annotations:
+        sidecar.aws.signing-proxy/inject: "true"
+        sidecar.aws.signing-proxy/host: aps-workspaces.us-west-2.amazonaws.com
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+`
+
+const injectCronJobManifest = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  schedule: "0 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          annotations:
+            sidecar.aws.signing-proxy/inject: "true"
+            sidecar.aws.signing-proxy/host: aps-workspaces.us-west-2.amazonaws.com
+        spec:
+          restartPolicy: OnFailure
+          containers:
+          - name: report
+            image: report:1.0
+`
+
+const injectServiceManifest = `apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  ports:
+  - port: 80
+`
+
+// containerNames returns the names of containers.
+func containerNames(containers []corev1.Container) []string {
+	var names []string
+
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+
+	return names
+}
+
+func TestInjectManifest(t *testing.T) {
+	t.Run("TestPod", func(t *testing.T) {
+		output, err := InjectManifest(Config{}, "default", nil, []byte(injectPodManifest))
+		assert.Nil(t, err, "Should succeed")
+
+		var pod corev1.Pod
+		assert.Nil(t, yaml.Unmarshal(output, &pod), "Should output YAML")
+		assert.Equal(t, []string{"app", signingProxyWebhookContainerName}, containerNames(pod.Spec.Containers), "Should add the proxy container")
+		assert.Equal(t, "injected", pod.Annotations[signingProxyWebhookAnnotationStatusKey], "Should mark the pod as injected")
+	})
+
+	t.Run("TestDeployment", func(t *testing.T) {
+		output, err := InjectManifest(Config{}, "default", nil, []byte(injectDeploymentManifest))
+		assert.Nil(t, err, "Should succeed")
+
+		var deployment appsv1.Deployment
+		assert.Nil(t, yaml.Unmarshal(output, &deployment), "Should output YAML")
+		assert.Equal(t, []string{"app", signingProxyWebhookContainerName}, containerNames(deployment.Spec.Template.Spec.Containers), "Should add the proxy container to the pod template")
+		assert.Equal(t, "app", deployment.Spec.Selector.MatchLabels["app"], "Should keep the rest of the Deployment")
+	})
+
+	t.Run("TestCronJob", func(t *testing.T) {
+		output, err := InjectManifest(Config{}, "default", nil, []byte(injectCronJobManifest))
+		assert.Nil(t, err, "Should succeed")
+
+		var cronJob batchv1.CronJob
+		assert.Nil(t, yaml.Unmarshal(output, &cronJob), "Should output YAML")
+		assert.Equal(t, []string{"report", signingProxyWebhookContainerName}, containerNames(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers), "Should add the proxy container to the job template")
+	})
+
+	t.Run("TestNamespaceLabels", func(t *testing.T) {
+		manifest := strings.NewReplacer(
+			"    sidecar.aws.signing-proxy/inject: \"true\"\n", "",
+			"    sidecar.aws.signing-proxy/host: aps-workspaces.us-west-2.amazonaws.com\n", "",
+		).Replace(injectPodManifest)
+
+		output, err := InjectManifest(Config{}, "default", map[string]string{
+			"sidecar-inject": "true",
+			"sidecar-host":   "aps-workspaces.us-west-2.amazonaws.com",
+		}, []byte(manifest))
+		assert.Nil(t, err, "Should succeed")
+		assert.Contains(t, string(output), signingProxyWebhookContainerName, "Should inject with the namespace labels")
+	})
+
+	t.Run("TestMultipleDocuments", func(t *testing.T) {
+		output, err := InjectManifest(Config{}, "default", nil, []byte(injectServiceManifest+"---\n"+injectPodManifest))
+		assert.Nil(t, err, "Should succeed")
+
+		documents := strings.Split(string(output), "---\n")
+		assert.Len(t, documents, 2, "Should keep both documents")
+		assert.Equal(t, injectServiceManifest, documents[0], "Should pass other kinds through unchanged")
+		assert.Contains(t, documents[1], signingProxyWebhookContainerName, "Should inject the pod")
+	})
+
+	t.Run("TestInvalidHost", func(t *testing.T) {
+		manifest := strings.Replace(injectPodManifest, "aps-workspaces.us-west-2.amazonaws.com", "localhost", 1)
+
+		_, err := InjectManifest(Config{}, "default", nil, []byte(manifest))
+		assert.NotNil(t, err, "Should fail for a pod the webhook would deny")
+	})
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	irsaRoleArnAnnotation     = "eks.amazonaws.com/role-arn"
+	defaultServiceAccountName = "default"
+
+	// The projected token mirrors the volume the EKS pod identity webhook adds for IRSA, under
+	// a name of its own so that both can be present in a pod.
+	projectedTokenVolumeName        = "sidecar-aws-sigv4-proxy-token"
+	projectedTokenPath              = "/var/run/secrets/eks.amazonaws.com/serviceaccount/token"
+	projectedTokenAudience          = "sts.amazonaws.com"
+	projectedTokenExpirationSeconds = int64(86400)
+)
+
+// checkIRSA returns an error unless the pod's ServiceAccount is annotated with an IAM role for
+// IRSA, so that a sidecar without a role ARN is not injected without credentials. Errors
+// reading the ServiceAccount are internal errors, which do not blame the pod.
+func (whsvr *WebhookServer) checkIRSA(namespace string, serviceAccountName string) error {
+	if serviceAccountName == "" {
+		serviceAccountName = defaultServiceAccountName
+	}
+
+	if whsvr.serviceAccountLister == nil {
+		return internalError{fmt.Errorf("Cannot verify ServiceAccount %s/%s for IRSA, ServiceAccounts are not watched", namespace, serviceAccountName)}
+	}
+
+	serviceAccount, err := whsvr.serviceAccountLister.ServiceAccounts(namespace).Get(serviceAccountName)
+
+	if k8serrors.IsNotFound(err) {
+		return fmt.Errorf("ServiceAccount %s/%s not found, IRSA requires the pod's ServiceAccount to be annotated with %s", namespace, serviceAccountName, irsaRoleArnAnnotation)
+	} else if err != nil {
+		return internalError{fmt.Errorf("Error reading ServiceAccount %s/%s: %v", namespace, serviceAccountName, err)}
+	}
+
+	if strings.TrimSpace(serviceAccount.Annotations[irsaRoleArnAnnotation]) == "" {
+		return fmt.Errorf("ServiceAccount %s/%s is not annotated with %s, set it for IRSA or set the %s annotation", namespace, serviceAccountName, irsaRoleArnAnnotation, whsvr.annotationKey(signingProxyWebhookAnnotationRoleArnKey))
+	}
+
+	return nil
+}
+
+// projectedTokenVolume returns the volume projecting a ServiceAccount token for STS into the sidecar.
+func projectedTokenVolume() corev1.Volume {
+	expirationSeconds := projectedTokenExpirationSeconds
+
+	return corev1.Volume{
+		Name: projectedTokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						Audience:          projectedTokenAudience,
+						ExpirationSeconds: &expirationSeconds,
+						Path:              path.Base(projectedTokenPath),
+					},
+				}},
+			},
+		},
+	}
+}
+
+// projectedTokenVolumeMount returns the read-only mount of the projected token in the sidecar.
+func projectedTokenVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      projectedTokenVolumeName,
+		MountPath: path.Dir(projectedTokenPath),
+		ReadOnly:  true,
+	}
+}
+
+// projectedTokenEnv returns the environment variables making the AWS SDK assume roleArn with the
+// projected token, as the EKS pod identity webhook does.
+func projectedTokenEnv(roleArn string, roleSessionName string) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "AWS_ROLE_ARN", Value: roleArn},
+		{Name: "AWS_WEB_IDENTITY_TOKEN_FILE", Value: projectedTokenPath},
+	}
+
+	if roleSessionName != "" {
+		env = append(env, corev1.EnvVar{Name: "AWS_ROLE_SESSION_NAME", Value: roleSessionName})
+	}
+
+	return env
+}
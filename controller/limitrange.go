@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// getLimitRangeResources returns the container defaults of the namespace's LimitRanges, so
+// that a sidecar without resources is not rejected for falling below a LimitRange minimum.
+// The LimitRanger admission plugin defaults containers before webhooks run and only
+// validates the sidecar afterwards. It returns nil if no LimitRange sets container defaults.
+func (whsvr *WebhookServer) getLimitRangeResources(namespace string) (*corev1.ResourceRequirements, error) {
+	if whsvr.limitRangeLister == nil {
+		return nil, nil
+	}
+
+	limitRanges, err := whsvr.limitRangeLister.LimitRanges(namespace).List(labels.Everything())
+
+	if err != nil {
+		return nil, fmt.Errorf("Error listing LimitRanges in namespace %s: %v", namespace, err)
+	}
+
+	// Sort by name so that the first LimitRange setting a default wins on every request.
+	sort.Slice(limitRanges, func(i, j int) bool {
+		return limitRanges[i].Name < limitRanges[j].Name
+	})
+
+	requirements := &corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+
+			// As with LimitRanger, the default limit is also the default request when no
+			// default request is set.
+			for name, quantity := range item.Default {
+				setDefaultQuantity(requirements.Limits, name, quantity.DeepCopy())
+			}
+
+			for name, quantity := range item.DefaultRequest {
+				setDefaultQuantity(requirements.Requests, name, quantity.DeepCopy())
+			}
+
+			for name, quantity := range item.Default {
+				setDefaultQuantity(requirements.Requests, name, quantity.DeepCopy())
+			}
+		}
+	}
+
+	if len(requirements.Requests) == 0 {
+		requirements.Requests = nil
+	}
+
+	if len(requirements.Limits) == 0 {
+		requirements.Limits = nil
+	}
+
+	if requirements.Requests == nil && requirements.Limits == nil {
+		return nil, nil
+	}
+
+	return requirements, nil
+}
+
+// setDefaultQuantity sets the quantity of name in list unless it is already set.
+func setDefaultQuantity(list corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) {
+	if _, ok := list[name]; !ok {
+		list[name] = quantity
+	}
+}
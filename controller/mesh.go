@@ -0,0 +1,82 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// meshExclusionAnnotations maps the supported service meshes to the pod annotations listing
+// the ports their sidecar must not intercept.
+var meshExclusionAnnotations = map[string][]string{
+	"istio":   {"traffic.sidecar.istio.io/excludeInboundPorts", "traffic.sidecar.istio.io/excludeOutboundPorts"},
+	"linkerd": {"config.linkerd.io/skip-inbound-ports", "config.linkerd.io/skip-outbound-ports"},
+}
+
+// ValidateMeshExclusion checks that each mesh has known exclusion annotations.
+func ValidateMeshExclusion(meshes []string) error {
+	for _, mesh := range meshes {
+		if _, ok := meshExclusionAnnotations[mesh]; !ok {
+			return fmt.Errorf("Unknown service mesh %q, expected one of %s", mesh, strings.Join(meshNames(), ", "))
+		}
+	}
+
+	return nil
+}
+
+// getMeshExclusionAnnotations returns the annotations excluding port from interception by
+// the meshes, adding it to any ports the pod already excludes.
+func getMeshExclusionAnnotations(meshes []string, existing map[string]string, port int) map[string]string {
+	annotations := map[string]string{}
+
+	for _, mesh := range meshes {
+		for _, key := range meshExclusionAnnotations[mesh] {
+			annotations[key] = addPort(existing[key], port)
+		}
+	}
+
+	return annotations
+}
+
+// addPort appends port to a comma separated list of ports unless it is already listed.
+func addPort(ports string, port int) string {
+	var list []string
+
+	for _, p := range strings.Split(ports, ",") {
+		if p = strings.TrimSpace(p); p == strconv.Itoa(port) {
+			return ports
+		} else if p != "" {
+			list = append(list, p)
+		}
+	}
+
+	return strings.Join(append(list, strconv.Itoa(port)), ",")
+}
+
+func meshNames() []string {
+	names := make([]string, 0, len(meshExclusionAnnotations))
+
+	for name := range meshExclusionAnnotations {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
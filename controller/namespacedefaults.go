@@ -0,0 +1,120 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// splitNamespaceDefaultsConfigMap splits a <namespace>/<name> ConfigMap reference.
+func splitNamespaceDefaultsConfigMap(reference string) (string, string, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(reference)
+
+	if err != nil || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("Invalid namespace defaults ConfigMap %q, expected <namespace>/<name>", reference)
+	}
+
+	return namespace, name, nil
+}
+
+// newNamespaceDefaultsLister registers an informer watching only the namespace defaults
+// ConfigMap with factory, and returns a lister reading it from the informer cache.
+func newNamespaceDefaultsLister(factory informers.SharedInformerFactory, reference string) (corelisters.ConfigMapNamespaceLister, error) {
+	namespace, name, err := splitNamespaceDefaultsConfigMap(reference)
+
+	if err != nil {
+		return nil, err
+	}
+
+	informer := factory.InformerFor(&corev1.ConfigMap{}, func(k8sClient kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredConfigMapInformer(k8sClient, namespace, resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			func(options *metav1.ListOptions) {
+				options.FieldSelector = "metadata.name=" + name
+			})
+	})
+
+	return corelisters.NewConfigMapLister(informer.GetIndexer()).ConfigMaps(namespace), nil
+}
+
+// applyNamespaceDefaults returns a copy of podMetadata with the defaults configured for namespace
+// added to its annotations. Annotations set on the pod take precedence over the defaults. A
+// ConfigMap that cannot be read or parsed is an internalError, since the pod is not at fault.
+//
+// The namespace defaults ConfigMap maps namespace names to YAML objects of annotation keys, without
+// the annotation prefix, to values:
+//
+//	team-a: |
+//	  region: us-west-2
+//	  role-arn: arn:aws:iam::123456789012:role/team-a
+func (whsvr *WebhookServer) applyNamespaceDefaults(namespace string, podMetadata *metav1.ObjectMeta) (*metav1.ObjectMeta, error) {
+	if whsvr.namespaceDefaultsLister == nil {
+		return podMetadata, nil
+	}
+
+	_, name, err := splitNamespaceDefaultsConfigMap(whsvr.config.NamespaceDefaultsConfigMap)
+
+	if err != nil {
+		return nil, internalError{err}
+	}
+
+	configMap, err := whsvr.namespaceDefaultsLister.Get(name)
+
+	if k8serrors.IsNotFound(err) {
+		return podMetadata, nil
+	} else if err != nil {
+		return nil, internalError{fmt.Errorf("Error reading namespace defaults ConfigMap: %v", err)}
+	}
+
+	value, ok := configMap.Data[namespace]
+
+	if !ok {
+		return podMetadata, nil
+	}
+
+	var defaults map[string]string
+
+	if err := yaml.UnmarshalStrict([]byte(value), &defaults); err != nil {
+		return nil, internalError{fmt.Errorf("Invalid defaults for namespace %s in ConfigMap %s: %v", namespace, whsvr.config.NamespaceDefaultsConfigMap, err)}
+	}
+
+	merged := podMetadata.DeepCopy()
+
+	if merged.Annotations == nil {
+		merged.Annotations = map[string]string{}
+	}
+
+	for key, value := range defaults {
+		annotation := whsvr.annotationKey(signingProxyWebhookAnnotationPrefix + "/" + key)
+
+		if _, ok := merged.Annotations[annotation]; !ok {
+			merged.Annotations[annotation] = value
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+)
+
+// nativeSidecarsMinVersion is the first Kubernetes version enabling native sidecars, init
+// containers with restartPolicy Always, by default.
+var nativeSidecarsMinVersion = version.MustParseGeneric("1.29.0")
+
+// CheckNativeSidecarSupport returns an error unless the API server is recent enough to accept
+// native sidecar containers.
+func CheckNativeSidecarSupport(client discovery.ServerVersionInterface) error {
+	info, err := client.ServerVersion()
+
+	if err != nil {
+		return fmt.Errorf("Error getting Kubernetes version: %v", err)
+	}
+
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+
+	if err != nil {
+		return fmt.Errorf("Error parsing Kubernetes version %q: %v", info.GitVersion, err)
+	}
+
+	if !serverVersion.AtLeast(nativeSidecarsMinVersion) {
+		return fmt.Errorf("Native sidecars require Kubernetes %s or newer, the cluster runs %s", nativeSidecarsMinVersion, info.GitVersion)
+	}
+
+	return nil
+}
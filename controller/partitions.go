@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// awsPartition describes the region names and endpoint domains of an AWS partition.
+type awsPartition struct {
+	name         string
+	regionPrefix string   // Prefix of the partition's region names, empty for the commercial partition
+	dnsSuffixes  []string // Domains of the partition's endpoints, the one of regional endpoints first
+}
+
+// awsPartitions lists the partitions whose endpoint hosts are recognized, the commercial
+// partition, whose regions have no common prefix, last.
+var awsPartitions = []awsPartition{
+	{name: "aws-cn", regionPrefix: "cn-", dnsSuffixes: []string{"amazonaws.com.cn"}},
+	{name: "aws-us-gov", regionPrefix: "us-gov-", dnsSuffixes: []string{"amazonaws.com", "amazonaws-us-gov.com"}},
+	{name: "aws", dnsSuffixes: []string{"amazonaws.com"}},
+}
+
+// partitionOfRegion returns the partition region belongs to.
+func partitionOfRegion(region string) awsPartition {
+	for _, partition := range awsPartitions {
+		if partition.regionPrefix != "" && strings.HasPrefix(region, partition.regionPrefix) {
+			return partition
+		}
+	}
+
+	return awsPartitions[len(awsPartitions)-1]
+}
+
+// hasDNSSuffix reports whether the partition serves endpoints under dnsSuffix.
+func (partition awsPartition) hasDNSSuffix(dnsSuffix string) bool {
+	for _, suffix := range partition.dnsSuffixes {
+		if suffix == dnsSuffix {
+			return true
+		}
+	}
+
+	return false
+}
+
+// awsDNSSuffix returns the endpoint domain of any partition that hostname ends with, or an
+// empty string if it is not an AWS endpoint.
+func awsDNSSuffix(hostname string) string {
+	for _, partition := range awsPartitions {
+		for _, suffix := range partition.dnsSuffixes {
+			if strings.HasSuffix(hostname, "."+suffix) {
+				return suffix
+			}
+		}
+	}
+
+	return ""
+}
+
+// regionOfAWSHost returns the region of an AWS endpoint host, the last label before the
+// endpoint domain that is a region name, e.g. cn-north-1 in s3.dualstack.cn-north-1.amazonaws.com.cn.
+// It returns false for other hosts or hosts without a region label, and an error if the
+// region's partition does not serve endpoints under the host's domain.
+func regionOfAWSHost(hostname string) (string, bool, error) {
+	hostname = strings.ToLower(hostname)
+	dnsSuffix := awsDNSSuffix(hostname)
+
+	if dnsSuffix == "" {
+		return "", false, nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(hostname, "."+dnsSuffix), ".")
+
+	for i := len(labels) - 1; i >= 0; i-- {
+		if !regionPattern.MatchString(labels[i]) {
+			continue
+		}
+
+		if partition := partitionOfRegion(labels[i]); !partition.hasDNSSuffix(dnsSuffix) {
+			return "", false, fmt.Errorf("Region %q of host %q belongs to the %s partition, whose endpoints are not under %s", labels[i], hostname, partition.name, dnsSuffix)
+		}
+
+		return labels[i], true, nil
+	}
+
+	return "", false, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// proxyPreset holds the sidecar parameters of a well-known upstream that the generic host
+// parsing cannot derive.
+type proxyPreset struct {
+	signingName string // Service name used in the SigV4 credential scope
+}
+
+// presets maps the values accepted by the preset annotation to their parameters.
+var presets = map[string]proxyPreset{
+	// Remote-write hosts start with aps-workspaces, but requests are signed for aps.
+	"aps-remote-write": {signingName: "aps"},
+}
+
+// applyPreset fills in the name and region left unset by the annotations and labels from
+// the named preset. The region is the first label of host that looks like an AWS region,
+// so that VPC endpoint hosts such as vpce-1234.aps-workspaces.us-west-2.vpce.amazonaws.com
+// resolve as well.
+func applyPreset(presetName string, host string, name string, region string) (string, string, error) {
+	preset, ok := presets[strings.ToLower(strings.TrimSpace(presetName))]
+
+	if !ok {
+		return "", "", fmt.Errorf("Unknown preset %q, expected one of %s", presetName, strings.Join(presetNames(), ", "))
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = preset.signingName
+	}
+
+	if strings.TrimSpace(region) == "" {
+		for _, label := range strings.Split(host, ".") {
+			if regionPattern.MatchString(label) {
+				region = label
+				break
+			}
+		}
+	}
+
+	return name, region, nil
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProxyProfile is a named set of proxy transport settings, selected with the profile annotation.
+// Unset fields add no flag.
+type ProxyProfile struct {
+	IdleConnectionTimeout    *metav1.Duration `json:"idleConnectionTimeout,omitempty"`    // Passed as --idle-connection-timeout
+	TransportIdleConnTimeout *metav1.Duration `json:"transportIdleConnTimeout,omitempty"` // Passed as --transport-idle-conn-timeout
+	TransportMaxIdleConns    *int             `json:"transportMaxIdleConns,omitempty"`    // Passed as --transport-max-idle-conns
+}
+
+// builtinProfiles are the profiles available without configuration. Profiles of the same name
+// in Config.Profiles replace them.
+var builtinProfiles = map[string]ProxyProfile{
+	// Keep upstream connections open longer, so that infrequent requests do not wait for a
+	// new TLS handshake.
+	"low-latency": {
+		TransportIdleConnTimeout: &metav1.Duration{Duration: 5 * time.Minute},
+		TransportMaxIdleConns:    intPtr(100),
+	},
+	// Pool many upstream connections for high request rates, and keep connections to the proxy
+	// open longer than the 60s idle timeout of AWS load balancers in front of it.
+	"high-throughput": {
+		IdleConnectionTimeout:    &metav1.Duration{Duration: 2 * time.Minute},
+		TransportIdleConnTimeout: &metav1.Duration{Duration: 90 * time.Second},
+		TransportMaxIdleConns:    intPtr(1000),
+	},
+}
+
+func intPtr(value int) *int {
+	return &value
+}
+
+// validate rejects negative timeouts and connection counts.
+func (profile ProxyProfile) validate() error {
+	for _, timeout := range []*metav1.Duration{profile.IdleConnectionTimeout, profile.TransportIdleConnTimeout} {
+		if timeout != nil && timeout.Duration < 0 {
+			return fmt.Errorf("Invalid timeout %s: must not be negative", timeout.Duration)
+		}
+	}
+
+	if profile.TransportMaxIdleConns != nil && *profile.TransportMaxIdleConns < 0 {
+		return fmt.Errorf("Invalid maximum idle connections %d: must not be negative", *profile.TransportMaxIdleConns)
+	}
+
+	return nil
+}
+
+// annotationValues returns the profile's settings as the values of the transport annotations
+// they default.
+func (profile ProxyProfile) annotationValues() map[string]string {
+	values := map[string]string{}
+
+	if profile.IdleConnectionTimeout != nil {
+		values[signingProxyWebhookAnnotationIdleTimeoutKey] = profile.IdleConnectionTimeout.Duration.String()
+	}
+
+	if profile.TransportIdleConnTimeout != nil {
+		values[signingProxyWebhookAnnotationIdleConnTimeoutKey] = profile.TransportIdleConnTimeout.Duration.String()
+	}
+
+	if profile.TransportMaxIdleConns != nil {
+		values[signingProxyWebhookAnnotationMaxIdleConnsKey] = strconv.Itoa(*profile.TransportMaxIdleConns)
+	}
+
+	return values
+}
+
+// getProfile returns the profile named by the profile annotation, with no settings if the
+// annotation is unset.
+func (whsvr *WebhookServer) getProfile(podMetadata *metav1.ObjectMeta) (ProxyProfile, error) {
+	name := strings.ToLower(strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationProfileKey)))
+
+	if name == "" {
+		return ProxyProfile{}, nil
+	}
+
+	if profile, ok := whsvr.config.Profiles[name]; ok {
+		return profile, nil
+	}
+
+	if profile, ok := builtinProfiles[name]; ok {
+		return profile, nil
+	}
+
+	return ProxyProfile{}, fmt.Errorf("Unknown profile %q in annotation %s, expected one of %s", name, whsvr.annotationKey(signingProxyWebhookAnnotationProfileKey), strings.Join(whsvr.profileNames(), ", "))
+}
+
+func (whsvr *WebhookServer) profileNames() []string {
+	var names []string
+
+	for name := range builtinProfiles {
+		if _, ok := whsvr.config.Profiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	for name := range whsvr.config.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// quotaResource is a ResourceQuota resource a container counts towards.
+type quotaResource struct {
+	name     corev1.ResourceName // Resource in the quota
+	resource corev1.ResourceName // Container resource counted towards it
+	limit    bool                // Whether the container's limit is counted instead of its request
+}
+
+var quotaResources = []quotaResource{
+	{name: corev1.ResourceCPU, resource: corev1.ResourceCPU},
+	{name: corev1.ResourceRequestsCPU, resource: corev1.ResourceCPU},
+	{name: corev1.ResourceLimitsCPU, resource: corev1.ResourceCPU, limit: true},
+	{name: corev1.ResourceMemory, resource: corev1.ResourceMemory},
+	{name: corev1.ResourceRequestsMemory, resource: corev1.ResourceMemory},
+	{name: corev1.ResourceLimitsMemory, resource: corev1.ResourceMemory, limit: true},
+}
+
+// amount returns what the resource requirements count towards the quota resource. As with
+// the API server's defaulting, a request that is not set defaults to the limit.
+func (q quotaResource) amount(requirements corev1.ResourceRequirements) resource.Quantity {
+	if !q.limit {
+		if request, ok := requirements.Requests[q.resource]; ok {
+			return request.DeepCopy()
+		}
+	}
+
+	return requirements.Limits[q.resource].DeepCopy()
+}
+
+// checkResourceQuota returns an error if the sidecar's resources push the pod over a ResourceQuota of
+// namespace that the pod's own containers fit into, so that the pod is denied with a message
+// naming the sidecar rather than by the API server's quota check. Quotas with scopes are not
+// checked, since they may not apply to the pod.
+func (whsvr *WebhookServer) checkResourceQuota(namespace string, pod *corev1.Pod, sidecar corev1.ResourceRequirements) error {
+	if whsvr.resourceQuotaLister == nil {
+		return nil
+	}
+
+	resourceQuotas, err := whsvr.resourceQuotaLister.ResourceQuotas(namespace).List(labels.Everything())
+
+	if err != nil {
+		return internalError{fmt.Errorf("Error listing ResourceQuotas in namespace %s: %v", namespace, err)}
+	}
+
+	// Sort by name so that the same quota is reported on every request.
+	sort.Slice(resourceQuotas, func(i, j int) bool {
+		return resourceQuotas[i].Name < resourceQuotas[j].Name
+	})
+
+	for _, resourceQuota := range resourceQuotas {
+		if len(resourceQuota.Spec.Scopes) > 0 || resourceQuota.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		for _, quotaResource := range quotaResources {
+			hard, ok := resourceQuota.Status.Hard[quotaResource.name]
+
+			if !ok {
+				continue
+			}
+
+			sidecarAmount := quotaResource.amount(sidecar)
+
+			if sidecarAmount.IsZero() {
+				continue
+			}
+
+			var podAmount resource.Quantity
+
+			for _, container := range pod.Spec.Containers {
+				amount := quotaResource.amount(container.Resources)
+				podAmount.Add(amount)
+			}
+
+			available := hard.DeepCopy()
+			available.Sub(resourceQuota.Status.Used[quotaResource.name])
+
+			total := podAmount.DeepCopy()
+			total.Add(sidecarAmount)
+
+			if podAmount.Cmp(available) <= 0 && total.Cmp(available) > 0 {
+				return fmt.Errorf("Sidecar %s of %s exceeds ResourceQuota %s in namespace %s: the pod's containers use %s of the %s left", quotaResource.name, sidecarAmount.String(), resourceQuota.Name, namespace, podAmount.String(), available.String())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,493 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+)
+
+// sidecarChecksum returns the hex encoded SHA-256 of the sidecar container's spec, written to the
+// config-checksum annotation to detect sidecars injected with an outdated config.
+func sidecarChecksum(container corev1.Container) (string, error) {
+	data, err := json.Marshal(container)
+
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling sidecar container: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sidecarContainerPath returns the JSON Patch path of the pod's sidecar container, the container
+// itself, and whether the pod has one.
+func sidecarContainerPath(pod *corev1.Pod) (string, corev1.Container, bool) {
+	for i, container := range pod.Spec.Containers {
+		if container.Name == signingProxyWebhookContainerName {
+			return fmt.Sprintf("/spec/containers/%d", i), container, true
+		}
+	}
+
+	for i, container := range pod.Spec.InitContainers {
+		if container.Name == signingProxyWebhookContainerName {
+			return fmt.Sprintf("/spec/initContainers/%d", i), container, true
+		}
+	}
+
+	return "", corev1.Container{}, false
+}
+
+// managedArgs lists the proxy flags set by the controller. Other flags of an injected sidecar
+// were added by users, and are kept when its args are merged on reinjection.
+var managedArgs = map[string]bool{
+	"--ca-bundle":                   true,
+	"--custom-headers":              true,
+	"--host":                        true,
+	"--idle-connection-timeout":     true,
+	"--log-failed-requests":         true,
+	"--log-signing-process":         true,
+	"--name":                        true,
+	"--port":                        true,
+	"--region":                      true,
+	"--role-arn":                    true,
+	"--role-external-id":            true,
+	"--role-session-name":           true,
+	"--sign-host":                   true,
+	"--transport-idle-conn-timeout": true,
+	"--transport-max-idle-conns":    true,
+	"--unsigned-payload":            true,
+	"--upstream-path-prefix":        true,
+	"--upstream-url-scheme":         true,
+	"--verbose":                     true,
+}
+
+// splitArgs splits args into flags, each followed by its values up to the next flag, such as
+// ["--region", "us-west-2"] or ["--verbose"]. Values before the first flag form their own group.
+func splitArgs(args []string) [][]string {
+	var groups [][]string
+
+	for _, arg := range args {
+		if len(groups) == 0 || strings.HasPrefix(arg, "-") {
+			groups = append(groups, nil)
+		}
+
+		groups[len(groups)-1] = append(groups[len(groups)-1], arg)
+	}
+
+	return groups
+}
+
+// argFlag returns the flag name of an argument group, without an =value suffix, or an empty
+// string if the group does not start with a flag.
+func argFlag(group []string) string {
+	if !strings.HasPrefix(group[0], "-") {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(group[0], "=")
+
+	return name
+}
+
+// mergeArgs returns the args of a reinjected sidecar, its newly built args followed by the flags
+// of the existing sidecar that neither the controller manages nor the new args set.
+func mergeArgs(existing, managed []string) []string {
+	owned := map[string]bool{}
+
+	for _, group := range splitArgs(managed) {
+		owned[argFlag(group)] = true
+	}
+
+	merged := append([]string{}, managed...)
+
+	for _, group := range splitArgs(existing) {
+		if name := argFlag(group); name == "" || (!managedArgs[name] && !owned[name]) {
+			merged = append(merged, group...)
+		}
+	}
+
+	return merged
+}
+
+// withoutInjection returns a copy of pod without the sidecar container, the transparent init
+// container, the volumes added for the sidecar and the shared volume mounts of the app
+// containers, so that injecting it again does not add them twice.
+func withoutInjection(pod *corev1.Pod) *corev1.Pod {
+	stripped := pod.DeepCopy()
+	stripped.Spec.Containers = nil
+	stripped.Spec.InitContainers = nil
+	stripped.Spec.Volumes = nil
+
+	for _, container := range pod.Spec.Containers {
+		if container.Name != signingProxyWebhookContainerName {
+			stripped.Spec.Containers = append(stripped.Spec.Containers, withoutSharedVolumeMount(container))
+		}
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name != signingProxyWebhookContainerName && container.Name != signingProxyWebhookInitContainerName {
+			stripped.Spec.InitContainers = append(stripped.Spec.InitContainers, withoutSharedVolumeMount(container))
+		}
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		switch volume.Name {
+		case signingProxyWebhookCABundleVolumeName, signingProxyWebhookSharedVolumeName, projectedTokenVolumeName:
+		default:
+			stripped.Spec.Volumes = append(stripped.Spec.Volumes, volume)
+		}
+	}
+
+	return stripped
+}
+
+// withoutSharedVolumeMount returns container without its mount of the shared volume.
+func withoutSharedVolumeMount(container corev1.Container) corev1.Container {
+	volumeMounts := container.VolumeMounts
+	container.VolumeMounts = nil
+
+	for _, volumeMount := range volumeMounts {
+		if volumeMount.Name != signingProxyWebhookSharedVolumeName {
+			container.VolumeMounts = append(container.VolumeMounts, volumeMount)
+		}
+	}
+
+	return container
+}
+
+// applyPodPatch returns a copy of pod with patchOperations applied.
+func applyPodPatch(pod *corev1.Pod, patchOperations []PatchOperation) (*corev1.Pod, error) {
+	raw, err := json.Marshal(pod)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling pod: %v", err)
+	}
+
+	patchBytes, err := json.Marshal(patchOperations)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling patch: %v", err)
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding patch: %v", err)
+	}
+
+	patched, err := patch.Apply(raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error patching pod: %v", err)
+	}
+
+	var patchedPod corev1.Pod
+
+	if err := json.Unmarshal(patched, &patchedPod); err != nil {
+		return nil, fmt.Errorf("Error decoding patched pod: %v", err)
+	}
+
+	return &patchedPod, nil
+}
+
+// reconcileContainers returns the operations replacing the containers of current that differ from
+// the container of the same name in desired, and the containers of desired missing from current.
+// The sidecar container is left out of both.
+func reconcileContainers(current, desired []corev1.Container, basePath string) ([]PatchOperation, []corev1.Container) {
+	var patch []PatchOperation
+	var missing []corev1.Container
+
+	for _, container := range desired {
+		if container.Name == signingProxyWebhookContainerName {
+			continue
+		}
+
+		found := false
+
+		for i := range current {
+			if current[i].Name != container.Name {
+				continue
+			}
+
+			found = true
+
+			if !apiequality.Semantic.DeepEqual(current[i], container) {
+				patch = append(patch, PatchOperation{
+					Op:    "replace",
+					Path:  fmt.Sprintf("%s/%d", basePath, i),
+					Value: container,
+				})
+			}
+		}
+
+		if !found {
+			missing = append(missing, container)
+		}
+	}
+
+	return patch, missing
+}
+
+// reconcileVolumes returns the operations replacing the volumes of current that differ from the
+// volume of the same name in desired, and the volumes of desired missing from current.
+func reconcileVolumes(current, desired []corev1.Volume, basePath string) ([]PatchOperation, []corev1.Volume) {
+	var patch []PatchOperation
+	var missing []corev1.Volume
+
+	for _, volume := range desired {
+		found := false
+
+		for i := range current {
+			if current[i].Name != volume.Name {
+				continue
+			}
+
+			found = true
+
+			if !apiequality.Semantic.DeepEqual(current[i], volume) {
+				patch = append(patch, PatchOperation{
+					Op:    "replace",
+					Path:  fmt.Sprintf("%s/%d", basePath, i),
+					Value: volume,
+				})
+			}
+		}
+
+		if !found {
+			missing = append(missing, volume)
+		}
+	}
+
+	return patch, missing
+}
+
+// reconcilePodSpecFields returns the operations setting the fields of the pod spec other than its
+// containers and volumes, such as dnsConfig or affinity, that differ between current and desired.
+func reconcilePodSpecFields(current, desired corev1.PodSpec, basePath string) ([]PatchOperation, error) {
+	currentFields, err := specFields(current)
+
+	if err != nil {
+		return nil, err
+	}
+
+	desiredFields, err := specFields(desired)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+
+	for key := range desiredFields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var patch []PatchOperation
+
+	for _, key := range keys {
+		switch key {
+		case "containers", "initContainers", "ephemeralContainers", "volumes":
+			continue
+		}
+
+		if !reflect.DeepEqual(currentFields[key], desiredFields[key]) {
+			patch = append(patch, PatchOperation{
+				Op:    "add",
+				Path:  basePath + "/" + escapeJSONPointer(key),
+				Value: desiredFields[key],
+			})
+		}
+	}
+
+	return patch, nil
+}
+
+// specFields returns the JSON fields of spec.
+func specFields(spec corev1.PodSpec) (map[string]interface{}, error) {
+	raw, err := json.Marshal(spec)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling pod spec: %v", err)
+	}
+
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("Error decoding pod spec: %v", err)
+	}
+
+	return fields, nil
+}
+
+// changedValues returns the entries of desired that are missing from or differ in current.
+func changedValues(current, desired map[string]string) map[string]string {
+	changed := map[string]string{}
+
+	for key, value := range desired {
+		if existing, ok := current[key]; !ok || existing != value {
+			changed[key] = value
+		}
+	}
+
+	return changed
+}
+
+// buildReinjectPatch computes the patch replacing the sidecar of an injected pod template with
+// one built from the current config. It returns nil if the config-checksum annotation matches
+// the checksum of the current sidecar, or if the pod is no longer to be injected.
+//
+// Besides the sidecar, the patch brings the rest of the template in line with a fresh
+// injection, adding or replacing the volumes, init containers, app container mounts, pod spec
+// fields, labels and annotations that the new sidecar depends on, so that its volume mounts
+// do not refer to volumes the template lacks. Fields of an earlier injection that the new one
+// no longer sets are kept.
+func (whsvr *WebhookServer) buildReinjectPatch(ctx context.Context, pod *corev1.Pod, namespace string, nsLabels map[string]string) (*sidecarPatch, error) {
+	path, existing, ok := sidecarContainerPath(pod)
+
+	if !ok {
+		return nil, nil
+	}
+
+	stripped := withoutInjection(pod)
+	injection, err := whsvr.buildPatch(ctx, stripped, namespace, nsLabels, false, true)
+
+	if err != nil || injection == nil {
+		return nil, err
+	}
+
+	checksumKey := whsvr.annotationKey(signingProxyWebhookAnnotationChecksumKey)
+
+	if pod.Annotations[checksumKey] == injection.checksum {
+		return nil, nil
+	}
+
+	desired, err := applyPodPatch(stripped, injection.operations)
+
+	if err != nil {
+		return nil, internalError{err}
+	}
+
+	// Moving the sidecar between the containers and the init containers, after --native-sidecars
+	// was toggled, would need its other fields changed too. Such pods keep their sidecar.
+	desiredPath, _, _ := sidecarContainerPath(desired)
+
+	if strings.HasPrefix(path, "/spec/initContainers/") != strings.HasPrefix(desiredPath, "/spec/initContainers/") {
+		log.Printf("Not reinjecting sidecar into pod %s: the sidecar would move between containers and init containers, recreate the workload to inject it again", podName(pod))
+		return nil, nil
+	}
+
+	// The checksum stays the one of the sidecar built from the config, so that args added by
+	// users do not mark the sidecar as outdated.
+	container := injection.container
+
+	if whsvr.config.ReinjectMergeArgs {
+		container.Args = mergeArgs(existing.Args, container.Args)
+	}
+
+	patchOperations := []PatchOperation{{
+		Op:    "replace",
+		Path:  path,
+		Value: container,
+	}}
+
+	// Replacements use the indexes of the current template, so they come before any insertion.
+	containerPatch, _ := reconcileContainers(pod.Spec.Containers, desired.Spec.Containers, "/spec/containers")
+	patchOperations = append(patchOperations, containerPatch...)
+
+	initContainerPatch, missingInitContainers := reconcileContainers(pod.Spec.InitContainers, desired.Spec.InitContainers, "/spec/initContainers")
+	patchOperations = append(patchOperations, initContainerPatch...)
+
+	volumePatch, missingVolumes := reconcileVolumes(pod.Spec.Volumes, desired.Spec.Volumes, "/spec/volumes")
+	patchOperations = append(patchOperations, volumePatch...)
+	patchOperations = append(patchOperations, addToList(pod.Spec.Volumes, missingVolumes, "/spec/volumes")...)
+
+	if strings.HasPrefix(path, "/spec/initContainers/") {
+		// A fresh injection starts the transparent init container before a native sidecar.
+		index := 0
+
+		for i := range pod.Spec.InitContainers {
+			if pod.Spec.InitContainers[i].Name == signingProxyWebhookContainerName {
+				index = i
+			}
+		}
+
+		for i, initContainer := range missingInitContainers {
+			patchOperations = append(patchOperations, PatchOperation{
+				Op:    "add",
+				Path:  fmt.Sprintf("/spec/initContainers/%d", index+i),
+				Value: initContainer,
+			})
+		}
+	} else {
+		patchOperations = append(patchOperations, addToList(pod.Spec.InitContainers, missingInitContainers, "/spec/initContainers")...)
+	}
+
+	specPatch, err := reconcilePodSpecFields(pod.Spec, desired.Spec, "/spec")
+
+	if err != nil {
+		return nil, internalError{err}
+	}
+
+	patchOperations = append(patchOperations, specPatch...)
+
+	if labels := changedValues(pod.Labels, desired.Labels); len(labels) > 0 {
+		if pod.Labels == nil {
+			patchOperations = append(patchOperations, PatchOperation{
+				Op:    "add",
+				Path:  "/metadata/labels",
+				Value: map[string]string{},
+			})
+		}
+
+		patchOperations = append(patchOperations, updateMetadata(pod.Labels, labels, "/metadata/labels")...)
+	}
+
+	annotations := changedValues(pod.Annotations, desired.Annotations)
+	annotations[checksumKey] = injection.checksum
+
+	if pod.Annotations == nil {
+		patchOperations = append(patchOperations, PatchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations",
+			Value: map[string]string{},
+		})
+	}
+
+	patchOperations = append(patchOperations, updateMetadata(pod.Annotations, annotations, "/metadata/annotations")...)
+
+	return &sidecarPatch{
+		operations: patchOperations,
+		image:      injection.image,
+		warnings:   injection.warnings,
+		container:  container,
+		checksum:   injection.checksum,
+	}, nil
+}
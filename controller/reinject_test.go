@@ -0,0 +1,387 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSidecarChecksum(t *testing.T) {
+	container := corev1.Container{Name: signingProxyWebhookContainerName, Image: "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.0", Args: []string{"--port", ":8005"}}
+
+	checksum, err := sidecarChecksum(container)
+	assert.Nil(t, err, "Should compute the checksum")
+	assert.Len(t, checksum, 64, "Should hex encode a SHA-256")
+
+	same, err := sidecarChecksum(*container.DeepCopy())
+	assert.Nil(t, err, "Should compute the checksum")
+	assert.Equal(t, checksum, same, "Should be stable for the same container")
+
+	changedImage := *container.DeepCopy()
+	changedImage.Image = "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.1"
+	imageChecksum, _ := sidecarChecksum(changedImage)
+	assert.NotEqual(t, checksum, imageChecksum, "Should change with the image")
+
+	changedArgs := *container.DeepCopy()
+	changedArgs.Args = append(changedArgs.Args, "--verbose")
+	argsChecksum, _ := sidecarChecksum(changedArgs)
+	assert.NotEqual(t, checksum, argsChecksum, "Should change with the args")
+}
+
+func TestWebhookServer_mutateReinject(t *testing.T) {
+	const (
+		oldImage = "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.0"
+		newImage = "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.1"
+	)
+
+	annotations := map[string]string{
+		signingProxyWebhookAnnotationInjectKey: "true",
+		signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+	}
+
+	newServer := func(image string, reinject bool) *WebhookServer {
+		return &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			config:          Config{Image: image, ReinjectOnChange: reinject},
+		}
+	}
+
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "collector", Namespace: "testNamespace"},
+		Spec:       appsv1.DeploymentSpec{Template: newPodTemplate(annotations)},
+	}
+
+	// inject runs the admission review through whsvr and returns the workload with the patch applied.
+	inject := func(t *testing.T, whsvr *WebhookServer, operation v1beta1.Operation, raw []byte) (*v1beta1.AdmissionResponse, []byte) {
+		review := newWorkloadAdmissionReview(t, "deployments", deployment)
+		review.Request.Operation = operation
+		review.Request.Object.Raw = raw
+
+		response, err := whsvr.mutate(context.Background(), review)
+		assert.Nil(t, err, "Should succeed")
+		assert.True(t, response.Allowed, "Should allow the workload")
+
+		if len(response.Patch) == 0 {
+			return response, raw
+		}
+
+		patch, err := jsonpatch.DecodePatch(response.Patch)
+		assert.Nil(t, err, "Should decode the patch")
+
+		patched, err := patch.Apply(raw)
+		assert.Nil(t, err, "Should apply the patch")
+
+		return response, patched
+	}
+
+	original := newWorkloadAdmissionReview(t, "deployments", deployment).Request.Object.Raw
+	_, injected := inject(t, newServer(oldImage, true), v1beta1.Create, original)
+
+	pod, err := decodeWorkloadPod(injected)
+	assert.Nil(t, err, "Should decode the injected workload")
+	assert.Len(t, pod.Spec.Containers, 2, "Should inject the sidecar")
+	assert.NotEmpty(t, pod.Annotations[signingProxyWebhookAnnotationChecksumKey], "Should write the config checksum")
+
+	checksum, err := sidecarChecksum(pod.Spec.Containers[1])
+	assert.Nil(t, err, "Should compute the checksum")
+	assert.Equal(t, checksum, pod.Annotations[signingProxyWebhookAnnotationChecksumKey], "Should write the checksum of the injected sidecar")
+
+	t.Run("TestUnchanged", func(t *testing.T) {
+		response, _ := inject(t, newServer(oldImage, true), v1beta1.Update, injected)
+		assert.Empty(t, response.Patch, "Should not patch a sidecar with a matching checksum")
+	})
+
+	t.Run("TestChanged", func(t *testing.T) {
+		response, reinjected := inject(t, newServer(newImage, true), v1beta1.Update, injected)
+
+		patch := decodePatch(t, response)
+		assert.Equal(t, "replace", patch[0].Op, "Should replace the sidecar")
+		assert.Equal(t, "/spec/template/spec/containers/1", patch[0].Path, "Should replace the sidecar in place")
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Len(t, pod.Spec.Containers, 2, "Should not add another sidecar")
+		assert.Equal(t, newImage, pod.Spec.Containers[1].Image, "Should update the sidecar's image")
+		assert.NotEqual(t, checksum, pod.Annotations[signingProxyWebhookAnnotationChecksumKey], "Should update the config checksum")
+
+		newChecksum, _ := sidecarChecksum(pod.Spec.Containers[1])
+		assert.Equal(t, newChecksum, pod.Annotations[signingProxyWebhookAnnotationChecksumKey], "Should write the checksum of the new sidecar")
+	})
+
+	// customize returns the injected workload with extra sidecar args and a region annotation.
+	customize := func(t *testing.T, args ...string) []byte {
+		var workload appsv1.Deployment
+		assert.Nil(t, json.Unmarshal(injected, &workload), "Should decode the injected workload")
+
+		workload.Spec.Template.Annotations[signingProxyWebhookAnnotationRegionKey] = "eu-west-1"
+		workload.Spec.Template.Spec.Containers[1].Args = append(workload.Spec.Template.Spec.Containers[1].Args, args...)
+
+		raw, err := json.Marshal(workload)
+		assert.Nil(t, err, "Should encode the customized workload")
+
+		return raw
+	}
+
+	t.Run("TestMergeArgs", func(t *testing.T) {
+		whsvr := newServer(oldImage, true)
+		whsvr.config.ReinjectMergeArgs = true
+
+		customized := customize(t, "--strip", "Authorization", "--custom-flag=value", "--role-arn", "arn:aws:iam::123456789012:role/stale")
+		_, reinjected := inject(t, whsvr, v1beta1.Update, customized)
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+
+		args := pod.Spec.Containers[1].Args
+		assert.Equal(t, "eu-west-1", argValue(args, "--region"), "Should update the managed region")
+		assert.Equal(t, "Authorization", argValue(args, "--strip"), "Should keep the custom flag")
+		assert.Contains(t, args, "--custom-flag=value", "Should keep the custom flag")
+		assert.NotContains(t, args, "--role-arn", "Should drop managed flags the config no longer sets")
+
+		regions := 0
+
+		for _, arg := range args {
+			if arg == "--region" {
+				regions++
+			}
+		}
+
+		assert.Equal(t, 1, regions, "Should not duplicate managed flags")
+
+		managed, _ := decodeWorkloadPod(customized)
+		assert.NotEqual(t, managed.Annotations[signingProxyWebhookAnnotationChecksumKey], pod.Annotations[signingProxyWebhookAnnotationChecksumKey], "Should update the config checksum")
+
+		response, _ := inject(t, whsvr, v1beta1.Update, reinjected)
+		assert.Empty(t, response.Patch, "Should not reinject because of the custom flags")
+	})
+
+	t.Run("TestReplaceArgs", func(t *testing.T) {
+		_, reinjected := inject(t, newServer(oldImage, true), v1beta1.Update, customize(t, "--strip", "Authorization"))
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Equal(t, "eu-west-1", argValue(pod.Spec.Containers[1].Args, "--region"), "Should update the managed region")
+		assert.NotContains(t, pod.Spec.Containers[1].Args, "--strip", "Should replace the args without --reinject-merge-args")
+	})
+
+	// annotate returns the injected workload with extra template annotations.
+	annotate := func(t *testing.T, extra map[string]string) []byte {
+		var workload appsv1.Deployment
+		assert.Nil(t, json.Unmarshal(injected, &workload), "Should decode the injected workload")
+
+		for key, value := range extra {
+			workload.Spec.Template.Annotations[key] = value
+		}
+
+		raw, err := json.Marshal(workload)
+		assert.Nil(t, err, "Should encode the annotated workload")
+
+		return raw
+	}
+
+	t.Run("TestNewVolume", func(t *testing.T) {
+		_, reinjected := inject(t, newServer(oldImage, true), v1beta1.Update, annotate(t, map[string]string{
+			signingProxyWebhookAnnotationCABundleConfigMapKey: "corporate-ca",
+		}))
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Len(t, pod.Spec.Containers, 2, "Should not add another sidecar")
+		assert.Contains(t, pod.Spec.Containers[1].Args, "--ca-bundle", "Should reinject the sidecar with the CA bundle")
+		assert.Len(t, pod.Spec.Volumes, 1, "Should add the CA bundle volume")
+		assertVolumesMounted(t, pod)
+
+		response, _ := inject(t, newServer(oldImage, true), v1beta1.Update, reinjected)
+		assert.Empty(t, response.Patch, "Should not reinject again")
+	})
+
+	t.Run("TestSharedVolume", func(t *testing.T) {
+		shared := annotate(t, map[string]string{
+			signingProxyWebhookAnnotationSharedVolumeKey: "app",
+		})
+		_, sharedInjected := inject(t, newServer(oldImage, true), v1beta1.Update, shared)
+		_, reinjected := inject(t, newServer(newImage, true), v1beta1.Update, sharedInjected)
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Equal(t, newImage, pod.Spec.Containers[1].Image, "Should reinject the sidecar with the new image")
+		assert.Equal(t, []corev1.VolumeMount{{Name: signingProxyWebhookSharedVolumeName, MountPath: signingProxyWebhookSharedVolumeDefaultPath}}, pod.Spec.Containers[0].VolumeMounts, "Should mount the shared volume in the app container once")
+		assert.Len(t, pod.Spec.Volumes, 1, "Should not add the shared volume again")
+		assertVolumesMounted(t, pod)
+
+		response, _ := inject(t, newServer(newImage, true), v1beta1.Update, reinjected)
+		assert.Empty(t, response.Patch, "Should not reinject again")
+	})
+
+	t.Run("TestNewInitContainer", func(t *testing.T) {
+		_, reinjected := inject(t, newServer(oldImage, true), v1beta1.Update, annotate(t, map[string]string{
+			signingProxyWebhookAnnotationTransparentKey: "true",
+		}))
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Len(t, pod.Spec.InitContainers, 1, "Should add the transparent init container")
+		assert.NotNil(t, pod.Spec.Containers[1].SecurityContext, "Should reinject the sidecar for transparent mode")
+		assertVolumesMounted(t, pod)
+	})
+
+	t.Run("TestNativeSidecarInitContainer", func(t *testing.T) {
+		whsvr := newServer(oldImage, true)
+		whsvr.config.NativeSidecars = true
+
+		_, native := inject(t, whsvr, v1beta1.Create, original)
+
+		var workload appsv1.Deployment
+		assert.Nil(t, json.Unmarshal(native, &workload), "Should decode the injected workload")
+		workload.Spec.Template.Annotations[signingProxyWebhookAnnotationTransparentKey] = "true"
+		annotated, err := json.Marshal(workload)
+		assert.Nil(t, err, "Should encode the annotated workload")
+
+		_, reinjected := inject(t, whsvr, v1beta1.Update, annotated)
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Len(t, pod.Spec.InitContainers, 2, "Should add the transparent init container")
+		assert.Equal(t, signingProxyWebhookContainerName, pod.Spec.InitContainers[1].Name, "Should start the transparent init container before the sidecar")
+		assert.NotNil(t, pod.Spec.InitContainers[1].SecurityContext, "Should reinject the sidecar for transparent mode")
+	})
+
+	t.Run("TestNewPodSpecField", func(t *testing.T) {
+		whsvr := newServer(newImage, true)
+		whsvr.config.DNSConfig = &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.2"}}
+
+		_, reinjected := inject(t, whsvr, v1beta1.Update, injected)
+
+		pod, err := decodeWorkloadPod(reinjected)
+		assert.Nil(t, err, "Should decode the reinjected workload")
+		assert.Equal(t, newImage, pod.Spec.Containers[1].Image, "Should update the sidecar's image")
+		assert.Equal(t, whsvr.config.DNSConfig, pod.Spec.DNSConfig, "Should add the DNS config")
+	})
+
+	t.Run("TestDisabled", func(t *testing.T) {
+		response, _ := inject(t, newServer(newImage, false), v1beta1.Update, injected)
+		assert.Empty(t, response.Patch, "Should not reinject without --reinject-on-change")
+	})
+
+	t.Run("TestPod", func(t *testing.T) {
+		whsvr := newServer(newImage, true)
+		whsvr.config.InjectOnUpdate = true
+
+		podAnnotations := map[string]string{signingProxyWebhookAnnotationChecksumKey: checksum}
+
+		for key, value := range annotations {
+			podAnnotations[key] = value
+		}
+
+		injectedPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: signingProxyWebhookContainerName, Image: oldImage}}},
+		}
+
+		review := newAdmissionReview(t, injectedPod)
+		review.Request.Operation = v1beta1.Update
+
+		response, err := whsvr.mutate(context.Background(), review)
+		assert.Nil(t, err, "Should succeed")
+		assert.Empty(t, response.Patch, "Should not reinject pods, whose containers are immutable")
+	})
+}
+
+func TestMergeArgs(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		existing     []string
+		managed      []string
+		expected     []string
+		errorMessage string
+	}{
+		{
+			name:         "TestNoExtraArgs",
+			existing:     []string{"--name", "aps", "--region", "us-west-2"},
+			managed:      []string{"--name", "aps", "--region", "eu-west-1"},
+			expected:     []string{"--name", "aps", "--region", "eu-west-1"},
+			errorMessage: "Should use the managed args",
+		},
+		{
+			name:         "TestExtraArgs",
+			existing:     []string{"--name", "aps", "--region", "us-west-2", "--strip", "Authorization", "--no-cache"},
+			managed:      []string{"--name", "aps", "--region", "eu-west-1"},
+			expected:     []string{"--name", "aps", "--region", "eu-west-1", "--strip", "Authorization", "--no-cache"},
+			errorMessage: "Should keep the extra flags and their values",
+		},
+		{
+			name:         "TestEqualsValue",
+			existing:     []string{"--region=us-west-2", "--strip=Authorization"},
+			managed:      []string{"--region", "eu-west-1"},
+			expected:     []string{"--region", "eu-west-1", "--strip=Authorization"},
+			errorMessage: "Should match flags with an = value",
+		},
+		{
+			name:         "TestStaleManagedArgs",
+			existing:     []string{"--name", "aps", "--unsigned-payload", "--role-arn", "arn:aws:iam::123456789012:role/proxy"},
+			managed:      []string{"--name", "aps"},
+			expected:     []string{"--name", "aps"},
+			errorMessage: "Should drop managed flags the new args do not set",
+		},
+		{
+			name:         "TestTemplateArgs",
+			existing:     []string{"--name", "aps", "--extra", "1"},
+			managed:      []string{"--name", "aps", "--extra", "2"},
+			expected:     []string{"--name", "aps", "--extra", "2"},
+			errorMessage: "Should treat flags set by the new args as managed",
+		},
+		{
+			name:         "TestLeadingValues",
+			existing:     []string{"serve", "--region", "us-west-2"},
+			managed:      []string{"--region", "eu-west-1"},
+			expected:     []string{"--region", "eu-west-1", "serve"},
+			errorMessage: "Should keep values before the first flag",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, mergeArgs(tc.existing, tc.managed), tc.errorMessage)
+		})
+	}
+}
+
+// assertVolumesMounted checks that every volume mounted by a container of pod is defined, as the
+// API server does when validating the pod template.
+func assertVolumesMounted(t *testing.T, pod corev1.Pod) {
+	volumes := map[string]bool{}
+
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		for _, volumeMount := range container.VolumeMounts {
+			assert.True(t, volumes[volumeMount.Name], "Container %s should mount a defined volume, not %s", container.Name, volumeMount.Name)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// resolve returns the first of values that is not blank, trimmed of surrounding whitespace,
+// or the zero value if all are blank. Settings pass their sources in order of precedence:
+//
+//	pod annotation > namespace label > controller config default > hardcoded default
+//
+// so that every setting is defaulted the same way.
+func resolve[T ~string](values ...T) T {
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(string(value)); trimmed != "" {
+			return T(trimmed)
+		}
+	}
+
+	var zero T
+	return zero
+}
+
+// annotation returns the value of the annotation key, honoring a custom annotation prefix,
+// or an empty string if the pod does not set it.
+func (whsvr *WebhookServer) annotation(podMetadata *metav1.ObjectMeta, key string) string {
+	return podMetadata.GetAnnotations()[whsvr.annotationKey(key)]
+}
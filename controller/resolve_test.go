@@ -0,0 +1,163 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolve(t *testing.T) {
+	assert.Equal(t, "annotation", resolve("annotation", "label", "default"), "Should prefer the first value")
+	assert.Equal(t, "label", resolve("", " ", "label", "default"), "Should skip blank values")
+	assert.Equal(t, "default", resolve(" default "), "Should trim the resolved value")
+	assert.Equal(t, "", resolve("", ""), "Should return the zero value if all values are blank")
+	assert.Equal(t, corev1.PullAlways, resolve(corev1.PullPolicy(""), corev1.PullAlways), "Should resolve string types")
+}
+
+func TestWebhookServer_resolvePrecedence(t *testing.T) {
+	var testCases = []struct {
+		name               string
+		annotations        map[string]string
+		nsLabels           map[string]string
+		config             Config
+		expectedPullPolicy corev1.PullPolicy
+		expectedRoleArn    string
+		expectedRegion     string
+		errorMessage       string
+	}{
+		{
+			name:               "TestHardcodedDefaults",
+			expectedPullPolicy: corev1.PullIfNotPresent,
+			expectedRegion:     "us-west-2",
+			errorMessage:       "Should use the hardcoded defaults without other sources",
+		},
+		{
+			name: "TestConfigDefaults",
+			config: Config{
+				ImagePullPolicy:   corev1.PullAlways,
+				NamespaceRoleArns: map[string]string{"testNamespace": "arn:aws:iam::123456789012:role/config"},
+			},
+			expectedPullPolicy: corev1.PullAlways,
+			expectedRoleArn:    "arn:aws:iam::123456789012:role/config",
+			expectedRegion:     "us-west-2",
+			errorMessage:       "Should prefer the config defaults over the hardcoded defaults",
+		},
+		{
+			name: "TestNamespaceLabels",
+			nsLabels: map[string]string{
+				signingProxyWebhookLabelImagePullPolicyKey: "Never",
+				signingProxyWebhookLabelRoleArnKey:         "arn:aws:iam::123456789012:role/label",
+				signingProxyWebhookLabelRegionKey:          "eu-west-1",
+			},
+			config: Config{
+				ImagePullPolicy:   corev1.PullAlways,
+				NamespaceRoleArns: map[string]string{"testNamespace": "arn:aws:iam::123456789012:role/config"},
+			},
+			expectedPullPolicy: corev1.PullNever,
+			expectedRoleArn:    "arn:aws:iam::123456789012:role/label",
+			expectedRegion:     "eu-west-1",
+			errorMessage:       "Should prefer the namespace labels over the config defaults",
+		},
+		{
+			name: "TestPodAnnotations",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationImagePullPolicyKey: "Always",
+				signingProxyWebhookAnnotationRoleArnKey:         "arn:aws:iam::123456789012:role/annotation",
+				signingProxyWebhookAnnotationRegionKey:          "eu-central-1",
+			},
+			nsLabels: map[string]string{
+				signingProxyWebhookLabelImagePullPolicyKey: "Never",
+				signingProxyWebhookLabelRoleArnKey:         "arn:aws:iam::123456789012:role/label",
+				signingProxyWebhookLabelRegionKey:          "eu-west-1",
+			},
+			config:             Config{ImagePullPolicy: corev1.PullIfNotPresent},
+			expectedPullPolicy: corev1.PullAlways,
+			expectedRoleArn:    "arn:aws:iam::123456789012:role/annotation",
+			expectedRegion:     "eu-central-1",
+			errorMessage:       "Should prefer the pod annotations over all other sources",
+		},
+		{
+			name: "TestBlankAnnotation",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationImagePullPolicyKey: " ",
+			},
+			nsLabels:           map[string]string{signingProxyWebhookLabelImagePullPolicyKey: "Never"},
+			expectedPullPolicy: corev1.PullNever,
+			expectedRegion:     "us-west-2",
+			errorMessage:       "Should skip blank annotations",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(tc.nsLabels),
+				config:          tc.config,
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+
+			assert.Equal(t, tc.expectedPullPolicy, container.ImagePullPolicy, tc.errorMessage)
+			assert.Equal(t, tc.expectedRoleArn, argValue(container.Args, "--role-arn"), tc.errorMessage)
+			assert.Equal(t, tc.expectedRegion, argValue(container.Args, "--region"), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateInvalidImagePullPolicy(t *testing.T) {
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey:          "true",
+			signingProxyWebhookAnnotationHostKey:            "aps-workspaces.us-west-2.amazonaws.com",
+			signingProxyWebhookAnnotationImagePullPolicyKey: "Sometimes",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should not return an error")
+	assert.False(t, response.Allowed, "Should deny an invalid pull policy")
+	assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationImagePullPolicyKey, "Should name the offending annotation")
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// restartedAtAnnotation is the pod template annotation patched by kubectl rollout restart.
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// restartResponse is the body returned by RestartHandler.
+type restartResponse struct {
+	Namespace string   `json:"namespace"`
+	Restarted []string `json:"restarted"`
+	Error     string   `json:"error,omitempty"` // Set if not all Deployments could be restarted
+}
+
+// RestartHandler triggers a rolling restart of the Deployments owning injected pods in the
+// namespace given by the namespace query parameter, so that they are re-injected with the
+// current sidecar configuration. It must only be served behind mutual TLS. If restarting fails
+// part way, the response is a 500 listing the Deployments already restarted.
+func (whsvr *WebhookServer) RestartHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	namespace := request.URL.Query().Get("namespace")
+
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		http.Error(writer, fmt.Sprintf("Invalid namespace %q: %s", namespace, strings.Join(errs, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	restarted, err := whsvr.RestartInjectedDeployments(request.Context(), namespace)
+
+	if restarted == nil {
+		restarted = []string{}
+	}
+
+	result := restartResponse{Namespace: namespace, Restarted: restarted}
+	status := http.StatusOK
+
+	if err != nil {
+		log.Printf("Error restarting Deployments in namespace %s after restarting %v: %v", namespace, restarted, err)
+		result.Error = err.Error()
+		status = http.StatusInternalServerError
+	}
+
+	response, err := json.Marshal(result)
+
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if _, err := writer.Write(response); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// RestartInjectedDeployments patches the restart annotation into the pod template of every
+// Deployment in namespace that owns an injected pod, and returns the names of the restarted
+// Deployments. Pods not owned by a Deployment through a ReplicaSet are left alone. On error, the
+// Deployments restarted before it are returned with the error.
+func (whsvr *WebhookServer) RestartInjectedDeployments(ctx context.Context, namespace string) ([]string, error) {
+	if whsvr.client == nil {
+		return nil, fmt.Errorf("Kubernetes client must not be nil")
+	}
+
+	pods, err := whsvr.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+
+	if err != nil {
+		return nil, fmt.Errorf("Error listing pods: %v", err)
+	}
+
+	deployments := map[string]bool{}
+
+	for i := range pods.Items {
+		if !whsvr.isInjected(&pods.Items[i]) {
+			continue
+		}
+
+		name, err := whsvr.owningDeployment(ctx, &pods.Items[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if name != "" {
+			deployments[name] = true
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{restartedAtAnnotation: time.Now().Format(time.RFC3339)},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for name := range deployments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	restarted := []string{}
+
+	for _, name := range names {
+		if _, err := whsvr.client.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return restarted, fmt.Errorf("Error restarting Deployment %s/%s: %v", namespace, name, err)
+		}
+
+		log.Printf("Restarted Deployment %s/%s to re-inject the sidecar", namespace, name)
+		restarted = append(restarted, name)
+	}
+
+	return restarted, nil
+}
+
+// owningDeployment returns the name of the Deployment controlling the pod's ReplicaSet, or an
+// empty string if the pod is not owned by a Deployment.
+func (whsvr *WebhookServer) owningDeployment(ctx context.Context, pod *corev1.Pod) (string, error) {
+	owner := metav1.GetControllerOf(pod)
+
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return "", nil
+	}
+
+	replicaSet, err := whsvr.client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+
+	if err != nil {
+		return "", fmt.Errorf("Error getting ReplicaSet %s/%s: %v", pod.Namespace, owner.Name, err)
+	}
+
+	owner = metav1.GetControllerOf(replicaSet)
+
+	if owner == nil || owner.Kind != "Deployment" {
+		return "", nil
+	}
+
+	return owner.Name, nil
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newOwnedObjects returns a Deployment, its ReplicaSet and a pod of the ReplicaSet.
+func newOwnedObjects(name string, podAnnotations map[string]string) []runtime.Object {
+	isController := true
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "sidecar", Name: name}}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "sidecar",
+		Name:            name + "-5d4f8",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: name, Controller: &isController}},
+	}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "sidecar",
+		Name:            name + "-5d4f8-x2bq9",
+		Annotations:     podAnnotations,
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: replicaSet.Name, Controller: &isController}},
+	}}
+
+	return []runtime.Object{deployment, replicaSet, pod}
+}
+
+func newRestartClient() *fake.Clientset {
+	var objects []runtime.Object
+	objects = append(objects, newOwnedObjects("injected", map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"})...)
+	objects = append(objects, newOwnedObjects("plain", nil)...)
+	objects = append(objects, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "sidecar",
+		Name:        "standalone",
+		Annotations: map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"},
+	}})
+
+	return fake.NewSimpleClientset(objects...)
+}
+
+func TestWebhookServer_RestartInjectedDeployments(t *testing.T) {
+	client := newRestartClient()
+	whsvr := &WebhookServer{client: client}
+
+	restarted, err := whsvr.RestartInjectedDeployments(context.Background(), "sidecar")
+	assert.Nil(t, err, "Should restart the Deployments")
+	assert.Equal(t, []string{"injected"}, restarted, "Should only restart Deployments owning injected pods")
+
+	injected, err := client.AppsV1().Deployments("sidecar").Get(context.Background(), "injected", metav1.GetOptions{})
+	assert.Nil(t, err, "Should get the Deployment")
+	assert.NotEmpty(t, injected.Spec.Template.Annotations[restartedAtAnnotation], "Should patch the restart annotation")
+
+	plain, err := client.AppsV1().Deployments("sidecar").Get(context.Background(), "plain", metav1.GetOptions{})
+	assert.Nil(t, err, "Should get the Deployment")
+	assert.Empty(t, plain.Spec.Template.Annotations[restartedAtAnnotation], "Should not restart Deployments without injected pods")
+
+	var patches int
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			patches++
+			assert.Equal(t, "deployments", action.GetResource().Resource, "Should only patch Deployments")
+		}
+	}
+	assert.Equal(t, 1, patches, "Should issue a single restart patch")
+}
+
+func TestWebhookServer_RestartHandler(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		method       string
+		target       string
+		code         int
+		restarted    []string
+		errorMessage string
+	}{
+		{
+			name:         "TestRestart",
+			method:       http.MethodPost,
+			target:       "/restart?namespace=sidecar",
+			code:         http.StatusOK,
+			restarted:    []string{"injected"},
+			errorMessage: "Should restart the Deployments of the namespace",
+		},
+		{
+			name:         "TestMethodNotAllowed",
+			method:       http.MethodGet,
+			target:       "/restart?namespace=sidecar",
+			code:         http.StatusMethodNotAllowed,
+			errorMessage: "Should only accept POST",
+		},
+		{
+			name:         "TestMissingNamespace",
+			method:       http.MethodPost,
+			target:       "/restart",
+			code:         http.StatusBadRequest,
+			errorMessage: "Should require a namespace",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{client: newRestartClient()}
+
+			recorder := httptest.NewRecorder()
+			whsvr.RestartHandler(recorder, httptest.NewRequest(tc.method, tc.target, nil))
+
+			assert.Equal(t, tc.code, recorder.Code, tc.errorMessage)
+
+			if tc.code == http.StatusOK {
+				var response restartResponse
+				assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response), "Should unmarshal the response")
+				assert.Equal(t, "sidecar", response.Namespace, tc.errorMessage)
+				assert.Equal(t, tc.restarted, response.Restarted, tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_RestartHandlerPartialFailure(t *testing.T) {
+	var objects []runtime.Object
+	objects = append(objects, newOwnedObjects("alpha", map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"})...)
+	objects = append(objects, newOwnedObjects("beta", map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"})...)
+
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.PatchAction).GetName() == "beta" {
+			return true, nil, errors.New("connection refused")
+		}
+
+		return false, nil, nil
+	})
+
+	whsvr := &WebhookServer{client: client}
+
+	recorder := httptest.NewRecorder()
+	whsvr.RestartHandler(recorder, httptest.NewRequest(http.MethodPost, "/restart?namespace=sidecar", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Should report the failure")
+
+	var response restartResponse
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response), "Should unmarshal the response")
+	assert.Equal(t, []string{"alpha"}, response.Restarted, "Should list the Deployments restarted before the failure")
+	assert.Contains(t, response.Error, "beta", "Should name the Deployment that failed")
+}
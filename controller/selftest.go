@@ -0,0 +1,141 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	corev1Types "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	selfTestNamespace = "aws-sigv4-proxy-self-test"
+	selfTestHost      = "aps-workspaces.us-west-2.amazonaws.com"
+)
+
+// staticNamespaceClient answers Get with a fixed namespace without calling the API server.
+type staticNamespaceClient struct {
+	corev1Types.NamespaceInterface
+	namespace *corev1.Namespace
+}
+
+func (client staticNamespaceClient) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.Namespace, error) {
+	return client.namespace, nil
+}
+
+// SelfTest runs a canned AdmissionReview for a pod requesting injection through mutate and
+// returns an error unless the sidecar is injected, so that misconfigured selectors or
+// defaults are caught at startup rather than on the first real admission.
+func (whsvr *WebhookServer) SelfTest(ctx context.Context) error {
+	selfTest := *whsvr
+	selfTest.namespaceClient = staticNamespaceClient{namespace: &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: selfTestNamespace},
+	}}
+	selfTest.recorder = nil
+	selfTest.config.DryRun = false
+	// The canned host and ServiceAccount are not expected to pass the checks of a real deployment.
+	selfTest.config.AllowedHosts = nil
+	selfTest.config.RequireIRSA = false
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "self-test",
+			Namespace: selfTestNamespace,
+			Labels:    selectorLabels(whsvr.config.ObjectSelector),
+			Annotations: map[string]string{
+				whsvr.annotationKey(signingProxyWebhookAnnotationInjectKey): "true",
+				whsvr.annotationKey(signingProxyWebhookAnnotationHostKey):   selfTestHost,
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "self-test"}}},
+	}
+
+	raw, err := json.Marshal(pod)
+
+	if err != nil {
+		return fmt.Errorf("Error marshaling self-test pod: %v", err)
+	}
+
+	response, err := selfTest.mutate(ctx, &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "self-test",
+			Namespace: selfTestNamespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !response.Allowed {
+		return fmt.Errorf("Self-test pod was denied: %s", response.Result.Message)
+	}
+
+	var patch []PatchOperation
+
+	if len(response.Patch) == 0 {
+		return fmt.Errorf("Self-test pod was not injected, check the namespace and object selectors")
+	}
+
+	if err := json.Unmarshal(response.Patch, &patch); err != nil {
+		return fmt.Errorf("Self-test produced an invalid patch: %v", err)
+	}
+
+	sidecarPath := "/spec/containers"
+
+	if whsvr.config.NativeSidecars {
+		sidecarPath = "/spec/initContainers"
+	}
+
+	for _, operation := range patch {
+		if operation.Path == sidecarPath+"/-" || operation.Path == sidecarPath {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Self-test patch does not add the sidecar container")
+}
+
+// selectorLabels returns labels satisfying the equality, set and existence requirements of
+// selector, so that the self-test pod is selected by it.
+func selectorLabels(selector labels.Selector) map[string]string {
+	if selector == nil {
+		return nil
+	}
+
+	podLabels := map[string]string{}
+	requirements, _ := selector.Requirements()
+
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			podLabels[requirement.Key()] = requirement.Values().List()[0]
+		case selection.Exists:
+			podLabels[requirement.Key()] = "true"
+		}
+	}
+
+	return podLabels
+}
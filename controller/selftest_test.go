@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestWebhookServer_SelfTest(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		config       func(config *Config)
+		valid        bool
+		errorMessage string
+	}{
+		{
+			name:         "TestDefaultConfig",
+			config:       func(config *Config) {},
+			valid:        true,
+			errorMessage: "Should pass with the default config",
+		},
+		{
+			name: "TestObjectSelector",
+			config: func(config *Config) {
+				config.ObjectSelector = labels.SelectorFromSet(labels.Set{"app": "sleep"})
+			},
+			valid:        true,
+			errorMessage: "Should label the self-test pod to match the object selector",
+		},
+		{
+			name: "TestDryRun",
+			config: func(config *Config) {
+				config.DryRun = true
+			},
+			valid:        true,
+			errorMessage: "Should check the patch even in dry-run mode",
+		},
+		{
+			name: "TestNativeSidecars",
+			config: func(config *Config) {
+				config.NativeSidecars = true
+			},
+			valid:        true,
+			errorMessage: "Should find the sidecar among the init containers",
+		},
+		{
+			name: "TestBadNamespaceSelector",
+			config: func(config *Config) {
+				config.NamespaceSelector = &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "sidecar-inject", Operator: "Bogus"}},
+				}
+			},
+			valid:        false,
+			errorMessage: "Should fail when the namespace selector cannot be evaluated",
+		},
+		{
+			name: "TestBadArgsTemplate",
+			config: func(config *Config) {
+				config.ArgsTemplate = "--role-arn {{.Missing}}"
+			},
+			valid:        false,
+			errorMessage: "Should fail when the sidecar arguments cannot be rendered",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := DefaultConfig()
+			testCase.config(&config)
+
+			whsvr := &WebhookServer{config: config}
+			err := whsvr.SelfTest(context.TODO())
+
+			if testCase.valid {
+				assert.Nil(t, err, testCase.errorMessage)
+			} else {
+				assert.NotNil(t, err, testCase.errorMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// awsService describes how to reach an AWS service in a region.
+type awsService struct {
+	endpointPrefix string // First label of the regional endpoint host
+	signingName    string // Service name used in the SigV4 credential scope
+}
+
+// serviceCatalog maps the service names accepted by the service annotation to their endpoints.
+var serviceCatalog = map[string]awsService{
+	"aps":            {endpointPrefix: "aps", signingName: "aps"},
+	"aps-workspaces": {endpointPrefix: "aps-workspaces", signingName: "aps"},
+	"dynamodb":       {endpointPrefix: "dynamodb", signingName: "dynamodb"},
+	"es":             {endpointPrefix: "es", signingName: "es"},
+	"lambda":         {endpointPrefix: "lambda", signingName: "lambda"},
+	"logs":           {endpointPrefix: "logs", signingName: "logs"},
+	"monitoring":     {endpointPrefix: "monitoring", signingName: "monitoring"},
+	"s3":             {endpointPrefix: "s3", signingName: "s3"},
+	"sns":            {endpointPrefix: "sns", signingName: "sns"},
+	"sqs":            {endpointPrefix: "sqs", signingName: "sqs"},
+	"sts":            {endpointPrefix: "sts", signingName: "sts"},
+	"xray":           {endpointPrefix: "xray", signingName: "xray"},
+}
+
+// getUpstreamFromService returns the regional endpoint host and signing name of service.
+func getUpstreamFromService(service string, region string) (string, string, error) {
+	entry, ok := serviceCatalog[strings.ToLower(strings.TrimSpace(service))]
+
+	if !ok {
+		return "", "", fmt.Errorf("Unknown AWS service %q, expected one of %s", service, strings.Join(serviceNames(), ", "))
+	}
+
+	region = strings.TrimSpace(region)
+
+	if region == "" {
+		return "", "", fmt.Errorf("AWS service %q requires a region", service)
+	}
+
+	domain := partitionOfRegion(region).dnsSuffixes[0]
+
+	return fmt.Sprintf("%s.%s.%s", entry.endpointPrefix, region, domain), entry.signingName, nil
+}
+
+func serviceNames() []string {
+	names := make([]string, 0, len(serviceCatalog))
+
+	for name := range serviceCatalog {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/api/admission/v1beta1"
+)
+
+const (
+	tracingAttributeNamespace = "k8s.namespace.name"
+	tracingAttributeDecision  = "admission.decision"
+	tracingAttributeHost      = "aws.sigv4_proxy.host"
+)
+
+// tracer returns the tracer of the globally registered provider, which is a no-op unless
+// tracing is enabled.
+func tracer() trace.Tracer {
+	return otel.Tracer(signingProxyWebhookEventComponent)
+}
+
+// admissionDecision summarizes the outcome of mutate as injected, skipped, denied or error.
+func admissionDecision(response *v1beta1.AdmissionResponse, err error) string {
+	switch {
+	case err != nil || response == nil:
+		return "error"
+	case !response.Allowed:
+		return "denied"
+	case len(response.Patch) > 0:
+		return "injected"
+	default:
+		return "skipped"
+	}
+}
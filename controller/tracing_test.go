@@ -0,0 +1,151 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestTracerProvider registers a tracer provider recording spans in memory for the
+// duration of the test.
+func newTestTracerProvider(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return exporter
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]string {
+	attributes := map[attribute.Key]string{}
+
+	for _, kv := range span.Attributes {
+		attributes[kv.Key] = kv.Value.Emit()
+	}
+
+	return attributes
+}
+
+func TestWebhookServer_HandlerTracing(t *testing.T) {
+	exporter := newTestTracerProvider(t)
+
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(newAdmissionReviewBody(t, "admission.k8s.io/v1", pod)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	whsvr.Handler(httptest.NewRecorder(), request)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	assert.Len(t, spans, 3, "Should record the Handler, mutate and describeNamespace spans")
+
+	handler, mutate, describe := spans["webhook.Handler"], spans["webhook.mutate"], spans["webhook.describeNamespace"]
+
+	assert.Equal(t, traceID, handler.SpanContext.TraceID().String(), "Should continue the trace of the incoming request")
+	assert.Equal(t, handler.SpanContext.SpanID(), mutate.Parent.SpanID(), "Should nest mutate under Handler")
+	assert.Equal(t, mutate.SpanContext.SpanID(), describe.Parent.SpanID(), "Should nest describeNamespace under mutate")
+
+	assert.Equal(t, map[attribute.Key]string{
+		tracingAttributeNamespace: "testNamespace",
+		tracingAttributeDecision:  "injected",
+		tracingAttributeHost:      "aps-workspaces.us-west-2.amazonaws.com",
+	}, spanAttributes(mutate), "Should record the namespace, decision and host on the mutate span")
+	assert.Equal(t, "testNamespace", spanAttributes(describe)[tracingAttributeNamespace], "Should record the namespace on the describeNamespace span")
+}
+
+func TestWebhookServer_mutateTracingDecision(t *testing.T) {
+	exporter := newTestTracerProvider(t)
+
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	var testCases = []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{name: "TestSkipped", annotations: map[string]string{}, expected: "skipped"},
+		{name: "TestDenied", annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "localhost",
+		}, expected: "denied"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+
+			exporter.Reset()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			_, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+
+			var mutate *tracetest.SpanStub
+			spans := exporter.GetSpans()
+
+			for i := range spans {
+				if spans[i].Name == "webhook.mutate" {
+					mutate = &spans[i]
+				}
+			}
+
+			if assert.NotNil(t, mutate, "Should record the mutate span") {
+				assert.Equal(t, tc.expected, spanAttributes(*mutate)[tracingAttributeDecision], "Should record the decision")
+			}
+		})
+	}
+}
@@ -16,115 +16,385 @@
 package controller
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	corev1Types "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
-	signingProxyWebhookAnnotationSchemeKey          = "sidecar.aws.signing-proxy/upstream-url-scheme"
-	signingProxyWebhookAnnotationHostKey            = "sidecar.aws.signing-proxy/host"
-	signingProxyWebhookAnnotationInjectKey          = "sidecar.aws.signing-proxy/inject"
-	signingProxyWebhookAnnotationNameKey            = "sidecar.aws.signing-proxy/name"
-	signingProxyWebhookAnnotationRegionKey          = "sidecar.aws.signing-proxy/region"
-	signingProxyWebhookAnnotationRoleArnKey         = "sidecar.aws.signing-proxy/role-arn"
-	signingProxyWebhookAnnotationStatusKey          = "sidecar.aws.signing-proxy/status"
-	signingProxyWebhookAnnotationUnsignedPayloadKey = "sidecar.aws.signing-proxy/unsigned-payload"
-	signingProxyWebhookLabelSchemeKey               = "sidecar-upstream-url-scheme"
-	signingProxyWebhookLabelHostKey                 = "sidecar-host"
-	signingProxyWebhookLabelNameKey                 = "sidecar-name"
-	signingProxyWebhookLabelRegionKey               = "sidecar-region"
-	signingProxyWebhookLabelRoleArnKey              = "sidecar-role-arn"
-	signingProxyWebhookLabelUnsignedPayloadKey      = "sidecar-unsigned-payload"
+	signingProxyWebhookAnnotationPrefix               = "sidecar.aws.signing-proxy"
+	signingProxyWebhookAnnotationCABundleConfigMapKey = signingProxyWebhookAnnotationPrefix + "/ca-bundle-configmap"
+	signingProxyWebhookAnnotationCABundlePathKey      = signingProxyWebhookAnnotationPrefix + "/ca-bundle-path"
+	signingProxyWebhookAnnotationCABundleKeyKey       = signingProxyWebhookAnnotationPrefix + "/ca-bundle-key"
+	signingProxyWebhookAnnotationSchemeKey            = signingProxyWebhookAnnotationPrefix + "/upstream-url-scheme"
+	signingProxyWebhookAnnotationChecksumKey          = signingProxyWebhookAnnotationPrefix + "/config-checksum"
+	signingProxyWebhookAnnotationCommandKey           = signingProxyWebhookAnnotationPrefix + "/command"
+	signingProxyWebhookAnnotationCPULimitKey          = signingProxyWebhookAnnotationPrefix + "/cpu-limit"
+	signingProxyWebhookAnnotationCPURequestKey        = signingProxyWebhookAnnotationPrefix + "/cpu-request"
+	signingProxyWebhookAnnotationEnvFromSecretKey     = signingProxyWebhookAnnotationPrefix + "/env-from-secret"
+	signingProxyWebhookAnnotationExposePortKey        = signingProxyWebhookAnnotationPrefix + "/expose-port"
+	signingProxyWebhookAnnotationHostKey              = signingProxyWebhookAnnotationPrefix + "/host"
+	signingProxyWebhookAnnotationHostHeaderKey        = signingProxyWebhookAnnotationPrefix + "/host-header"
+	signingProxyWebhookAnnotationHTTPProxyKey         = signingProxyWebhookAnnotationPrefix + "/http-proxy"
+	signingProxyWebhookAnnotationHTTPSProxyKey        = signingProxyWebhookAnnotationPrefix + "/https-proxy"
+	signingProxyWebhookAnnotationIdleTimeoutKey       = signingProxyWebhookAnnotationPrefix + "/idle-connection-timeout"
+	signingProxyWebhookAnnotationImagePullPolicyKey   = signingProxyWebhookAnnotationPrefix + "/image-pull-policy"
+	signingProxyWebhookAnnotationImagePullSecretKey   = signingProxyWebhookAnnotationPrefix + "/image-pull-secret"
+	signingProxyWebhookAnnotationInjectKey            = signingProxyWebhookAnnotationPrefix + "/inject"
+	signingProxyWebhookAnnotationInjectEphemeralKey   = signingProxyWebhookAnnotationPrefix + "/inject-ephemeral"
+	signingProxyWebhookAnnotationInjectedByKey        = signingProxyWebhookAnnotationPrefix + "/injected-by"
+	signingProxyWebhookAnnotationPreStopKey           = signingProxyWebhookAnnotationPrefix + "/lifecycle-prestop"
+	signingProxyWebhookAnnotationPreStopCommandKey    = signingProxyWebhookAnnotationPrefix + "/lifecycle-prestop-command"
+	signingProxyWebhookAnnotationMemoryLimitKey       = signingProxyWebhookAnnotationPrefix + "/memory-limit"
+	signingProxyWebhookAnnotationMemoryRequestKey     = signingProxyWebhookAnnotationPrefix + "/memory-request"
+	signingProxyWebhookAnnotationNameKey              = signingProxyWebhookAnnotationPrefix + "/name"
+	signingProxyWebhookAnnotationNoProxyKey           = signingProxyWebhookAnnotationPrefix + "/no-proxy"
+	signingProxyWebhookAnnotationNodeAffinityKey      = signingProxyWebhookAnnotationPrefix + "/node-affinity"
+	signingProxyWebhookAnnotationNoStatusKey          = signingProxyWebhookAnnotationPrefix + "/no-status-annotation"
+	signingProxyWebhookAnnotationPortNameKey          = signingProxyWebhookAnnotationPrefix + "/port-name"
+	signingProxyWebhookAnnotationProbesKey            = signingProxyWebhookAnnotationPrefix + "/probes"
+	signingProxyWebhookAnnotationProfileKey           = signingProxyWebhookAnnotationPrefix + "/profile"
+	signingProxyWebhookAnnotationProxyLogLevelKey     = signingProxyWebhookAnnotationPrefix + "/proxy-log-level"
+	signingProxyWebhookAnnotationRegionKey            = signingProxyWebhookAnnotationPrefix + "/region"
+	signingProxyWebhookAnnotationResolvedConfigKey    = signingProxyWebhookAnnotationPrefix + "/resolved-config"
+	signingProxyWebhookAnnotationRoleArnKey           = signingProxyWebhookAnnotationPrefix + "/role-arn"
+	signingProxyWebhookAnnotationRoleExternalIdKey    = signingProxyWebhookAnnotationPrefix + "/role-external-id"
+	signingProxyWebhookAnnotationRoleSessionNameKey   = signingProxyWebhookAnnotationPrefix + "/role-session-name"
+	signingProxyWebhookAnnotationServiceKey           = signingProxyWebhookAnnotationPrefix + "/service"
+	signingProxyWebhookAnnotationPresetKey            = signingProxyWebhookAnnotationPrefix + "/preset"
+	signingProxyWebhookAnnotationSharedVolumeKey      = signingProxyWebhookAnnotationPrefix + "/shared-volume-container"
+	signingProxyWebhookAnnotationSharedVolumePathKey  = signingProxyWebhookAnnotationPrefix + "/shared-volume-path"
+	signingProxyWebhookAnnotationSignHeaderKey        = signingProxyWebhookAnnotationPrefix + "/sign-header"
+	signingProxyWebhookAnnotationStartupThresholdKey  = signingProxyWebhookAnnotationPrefix + "/startup-probe-failure-threshold"
+	signingProxyWebhookAnnotationStatusKey            = signingProxyWebhookAnnotationPrefix + "/status"
+	signingProxyWebhookAnnotationIdleConnTimeoutKey   = signingProxyWebhookAnnotationPrefix + "/transport-idle-conn-timeout"
+	signingProxyWebhookAnnotationMaxIdleConnsKey      = signingProxyWebhookAnnotationPrefix + "/transport-max-idle-conns"
+	signingProxyWebhookAnnotationTransparentKey       = signingProxyWebhookAnnotationPrefix + "/transparent"
+	signingProxyWebhookAnnotationTransparentPortsKey  = signingProxyWebhookAnnotationPrefix + "/transparent-ports"
+	signingProxyWebhookAnnotationUnixSocketKey        = signingProxyWebhookAnnotationPrefix + "/unix-socket"
+	signingProxyWebhookAnnotationPathPrefixKey        = signingProxyWebhookAnnotationPrefix + "/upstream-path-prefix"
+	signingProxyWebhookAnnotationUnsignedPayloadKey   = signingProxyWebhookAnnotationPrefix + "/unsigned-payload"
+	signingProxyWebhookLabelSchemeKey                 = "sidecar-upstream-url-scheme"
+	signingProxyWebhookLabelFailOpenKey               = "sidecar-fail-open"
+	signingProxyWebhookLabelHostKey                   = "sidecar-host"
+	signingProxyWebhookLabelImagePullPolicyKey        = "sidecar-image-pull-policy"
+	signingProxyWebhookLabelImagePullSecretKey        = "sidecar-image-pull-secret"
+	signingProxyWebhookLabelNameKey                   = "sidecar-name"
+	signingProxyWebhookLabelRegionKey                 = "sidecar-region"
+	signingProxyWebhookLabelRoleArnKey                = "sidecar-role-arn"
+	signingProxyWebhookLabelRoleExternalIdKey         = "sidecar-role-external-id"
+	signingProxyWebhookLabelRoleSessionNameKey        = "sidecar-role-session-name"
+	signingProxyWebhookLabelServiceKey                = "sidecar-service"
+	signingProxyWebhookLabelPresetKey                 = "sidecar-preset"
+	signingProxyWebhookLabelUnsignedPayloadKey        = "sidecar-unsigned-payload"
+	signingProxyWebhookContainerName                  = "sidecar-aws-sigv4-proxy"
+	signingProxyWebhookEphemeralContainerName         = "sidecar-aws-sigv4-proxy-ephemeral"
+	signingProxyWebhookInitContainerName              = "sidecar-aws-sigv4-proxy-init"
+	signingProxyWebhookEphemeralSubResource           = "ephemeralcontainers"
+	signingProxyWebhookProxyPort                      = 8005
+	signingProxyWebhookEphemeralProxyPort             = 8006
+	signingProxyWebhookEgressLabelKey                 = "sigv4-proxy-egress"
+	signingProxyWebhookEgressLabelValue               = "allowed"
+	signingProxyWebhookEventComponent                 = "aws-sigv4-proxy-admission-controller"
+	signingProxyWebhookEventReasonInjected            = "SidecarInjected"
+	signingProxyWebhookEventReasonSkipped             = "SidecarSkipped"
+	signingProxyWebhookCABundleVolumeName             = "sidecar-aws-sigv4-proxy-ca-bundle"
+	signingProxyWebhookCABundleDefaultPath            = "/etc/aws-sigv4-proxy/ca-bundle/ca-bundle.crt"
+	signingProxyWebhookSharedVolumeName               = "sidecar-aws-sigv4-proxy-shared"
+	signingProxyWebhookSharedVolumeDefaultPath        = "/var/run/aws-sigv4-proxy"
+	signingProxyWebhookPortDefaultName                = "sigv4-proxy"
+	signingProxyWebhookStartupProbeDefaultThreshold   = 30
+	signingProxyWebhookTransparentDefaultPorts        = "80"
+	signingProxyWebhookTransparentProxyUID            = 1337
 )
 
 var (
 	namespaceSelector = []metav1.LabelSelector{{
 		MatchLabels: map[string]string{"sidecar-inject": "true"},
 	}}
+
+	// deprecatedLabels maps the namespace labels configuring the upstream, which are to be
+	// removed in favor of annotations, to the annotation replacing each.
+	deprecatedLabels = []struct{ label, annotation string }{
+		{signingProxyWebhookLabelHostKey, signingProxyWebhookAnnotationHostKey},
+		{signingProxyWebhookLabelNameKey, signingProxyWebhookAnnotationNameKey},
+		{signingProxyWebhookLabelRegionKey, signingProxyWebhookAnnotationRegionKey},
+	}
+
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+	imageDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+	// roleArnPattern matches the IAM role ARNs redacted from patches logged at the info level.
+	roleArnPattern = regexp.MustCompile(`arn:[a-z-]+:iam::[0-9]*:role/[^"\\\s]*`)
+
+	// proxyLogLevelArgs are the proxy flags enabling each level of the proxy-log-level
+	// annotation, info being the proxy's default logging.
+	proxyLogLevelArgs = map[string][]string{
+		"info":  nil,
+		"debug": {"--log-failed-requests", "--log-signing-process"},
+		"trace": {"--verbose"},
+	}
+
+	// preStopDefaultCommand keeps the sidecar alive briefly after the pod starts terminating,
+	// so in-flight requests from the application can still be signed.
+	preStopDefaultCommand = []string{"sleep", "5"}
 )
 
+// errMissingAdmissionRequest is returned by mutate for an AdmissionReview without a request,
+// such as one sent by a probe.
+var errMissingAdmissionRequest = errors.New("AdmissionReview has no request")
+
 type WebhookServer struct {
-	server          *http.Server
-	namespaceClient KubernetesNamespaceClient
+	server           *http.Server
+	client           kubernetes.Interface // Client used to restart Deployments, nil if not needed
+	namespaceClient  KubernetesNamespaceClient
+	recorder         record.EventRecorder
+	config           Config
+	inflight         chan struct{}   // Semaphore bounding concurrent requests, nil if unbounded
+	chaos            *chaosInjector  // Deliberate request failures and delays, nil unless configured
+	namespaceBreaker *circuitBreaker // Breaker failing namespace lookups fast while the API server is unavailable, nil unless configured
+
+	informerFactory         informers.SharedInformerFactory      // Informers started by Start
+	namespaceDefaultsLister corelisters.ConfigMapNamespaceLister // Lister of the namespace defaults ConfigMap, nil if not configured
+	serviceAccountLister    corelisters.ServiceAccountLister     // Lister of ServiceAccounts checked for IRSA, nil unless RequireIRSA is set
+	limitRangeLister        corelisters.LimitRangeLister         // Lister of LimitRanges defaulting sidecar resources, nil unless LimitRangeDefaults is set
+	resourceQuotaLister     corelisters.ResourceQuotaLister      // Lister of ResourceQuotas the sidecar is checked against, nil unless CheckResourceQuota is set
+	imageVerifier           *imageVerifier                       // Registry check of the sidecar image, nil unless VerifyImage is set
 }
 
 type KubernetesNamespaceClient interface {
 	corev1Types.NamespaceInterface
 }
 
+// resolvedConfig is the JSON written to the resolved-config annotation, showing the parameters
+// the sidecar was injected with.
+type resolvedConfig struct {
+	Host              string `json:"host"`
+	Name              string `json:"name"`
+	Region            string `json:"region"`
+	UpstreamURLScheme string `json:"upstreamUrlScheme"`
+	RoleArn           string `json:"roleArn,omitempty"`
+	Image             string `json:"image"`
+}
+
 type PatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
 	Value interface{} `json:"value,omitempty"`
 }
 
-func NewWebhookServer(server *http.Server, k8sClient *kubernetes.Clientset) *WebhookServer {
-	return &WebhookServer{
-		server:          server,
-		namespaceClient: k8sClient.CoreV1().Namespaces(),
+// NewWebhookServer creates a webhook server using k8sClient to describe namespaces, record
+// events and watch the namespace defaults ConfigMap, ServiceAccounts, LimitRanges and ResourceQuotas. It returns an error if
+// k8sClient is nil.
+func NewWebhookServer(server *http.Server, k8sClient kubernetes.Interface, config Config) (*WebhookServer, error) {
+	if k8sClient == nil || (reflect.ValueOf(k8sClient).Kind() == reflect.Ptr && reflect.ValueOf(k8sClient).IsNil()) {
+		return nil, errors.New("Kubernetes client must not be nil")
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1Types.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+
+	whsvr := &WebhookServer{
+		server:           server,
+		client:           k8sClient,
+		namespaceClient:  k8sClient.CoreV1().Namespaces(),
+		recorder:         broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: signingProxyWebhookEventComponent}),
+		config:           config,
+		inflight:         newSemaphore(config.MaxConcurrentRequests),
+		namespaceBreaker: newCircuitBreaker(config.NamespaceBreakerThreshold, config.NamespaceBreakerCooldown.Duration),
+		informerFactory:  informers.NewSharedInformerFactory(k8sClient, 0),
+	}
+
+	chaos, err := newChaosInjector(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	whsvr.chaos = chaos
+
+	if config.NamespaceDefaultsConfigMap != "" {
+		lister, err := newNamespaceDefaultsLister(whsvr.informerFactory, config.NamespaceDefaultsConfigMap)
+
+		if err != nil {
+			return nil, err
+		}
+
+		whsvr.namespaceDefaultsLister = lister
+	}
+
+	if config.RequireIRSA {
+		whsvr.serviceAccountLister = whsvr.informerFactory.Core().V1().ServiceAccounts().Lister()
+	}
+
+	if config.VerifyImage != "" {
+		whsvr.imageVerifier = newImageVerifier(newHTTPRegistryClient(), config.VerifyImageCacheTTL.Duration)
+	}
+
+	if config.LimitRangeDefaults {
+		whsvr.limitRangeLister = whsvr.informerFactory.Core().V1().LimitRanges().Lister()
+	}
+
+	if config.CheckResourceQuota {
+		whsvr.resourceQuotaLister = whsvr.informerFactory.Core().V1().ResourceQuotas().Lister()
+	}
+
+	return whsvr, nil
+}
+
+// Start starts the informers of the webhook server and waits for their caches to sync. It is a
+// no-op unless a namespace defaults ConfigMap, the IRSA check, LimitRange defaults or the ResourceQuota
+// check are configured.
+func (whsvr *WebhookServer) Start(stopCh <-chan struct{}) error {
+	if whsvr.informerFactory == nil {
+		return nil
+	}
+
+	whsvr.informerFactory.Start(stopCh)
+
+	for informerType, synced := range whsvr.informerFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("Error syncing informer cache for %v", informerType)
+		}
+	}
+
+	return nil
+}
+
+// newSemaphore returns a semaphore admitting limit holders, or nil if limit is not positive.
+func newSemaphore(limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
 	}
+
+	return make(chan struct{}, limit)
 }
 
 func (whsvr *WebhookServer) Handler(writer http.ResponseWriter, request *http.Request) {
+	if whsvr.inflight != nil {
+		select {
+		case whsvr.inflight <- struct{}{}:
+			defer func() { <-whsvr.inflight }()
+		default:
+			log.Printf("Rejecting request: %d concurrent requests already in flight", cap(whsvr.inflight))
+			writer.Header().Set("Retry-After", "1")
+			http.Error(writer, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	ctx, span := tracer().Start(ctx, "webhook.Handler", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	// The API server passes its webhook timeout as the timeout query parameter, which bounds
+	// the calls made for the request, such as registry lookups.
+	if timeout, err := time.ParseDuration(request.URL.Query().Get("timeout")); err == nil && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if whsvr.chaos != nil && whsvr.chaos.inject(ctx) {
+		log.Printf("Failing request: chaos error injected")
+		http.Error(writer, "Internal Server Error: chaos error injected", http.StatusInternalServerError)
+		return
+	}
+
 	if request.Body == nil {
 		fmt.Errorf("Error: empty request body")
 		http.Error(writer, "Empty request body", http.StatusBadRequest)
 		return
 	}
 
-	if request.Header.Get("Content-Type") != "application/json" {
+	// Media type parameters such as charset=utf-8 are accepted, since the body is JSON either way.
+	if mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
 		fmt.Errorf("Invalid Content-Type %s, expected application/json", request.Header.Get("Content-Type"))
 		http.Error(writer, "Invalid Content-Type, expected application/json", http.StatusUnsupportedMediaType)
 		return
 	}
 
+	if whsvr.config.MaxRequestBytes > 0 {
+		request.Body = http.MaxBytesReader(writer, request.Body, whsvr.config.MaxRequestBytes)
+	}
+
 	body, err := ioutil.ReadAll(request.Body)
 
 	if err != nil {
-		fmt.Errorf("Error reading body: %v", err)
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			log.Printf("Error reading body: request body exceeds %d bytes", maxBytesError.Limit)
+			http.Error(writer, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		log.Printf("Error reading body: %v", err)
 		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	admissionReview := v1beta1.AdmissionReview{}
-
-	err = json.Unmarshal(body, &admissionReview)
+	admissionReview, gvk, err := decodeAdmissionReview(body)
 
 	if err != nil {
-		fmt.Errorf("Error unmarshaling body: %v", err)
+		log.Printf("Error decoding body: %v", err)
 		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	var admissionResponse *v1beta1.AdmissionResponse
-
-	admissionResponse, err = whsvr.mutate(request.Context(), &admissionReview)
+	admissionResponse, err := whsvr.mutate(ctx, admissionReview)
 
-	if err != nil {
-		fmt.Errorf("Error mutating AdmissionReview: %v", err)
-		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+	if errors.Is(err, errMissingAdmissionRequest) {
+		log.Printf("Error mutating AdmissionReview: %v", err)
+		http.Error(writer, "Bad Request: AdmissionReview has no request", http.StatusBadRequest)
 		return
+	} else if err != nil {
+		// The response carries the status of the error, such as an InternalError or a
+		// BadRequest for a malformed pod, which the API server reports to the client.
+		fmt.Errorf("Error mutating AdmissionReview: %v", err)
 	}
 
-	if admissionResponse != nil {
-		admissionReview.Response = admissionResponse
-	}
-
-	response, err := json.Marshal(admissionReview)
+	response, err := encodeAdmissionReview(admissionResponse, gvk)
 
 	if err != nil {
 		fmt.Errorf("Error encoding response: %v", err)
@@ -132,253 +402,1995 @@ func (whsvr *WebhookServer) Handler(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+
 	if _, err := writer.Write(response); err != nil {
 		fmt.Errorf("Error writing response: %v", err)
-		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
-		return
 	}
 }
 
+// mutate traces the admission decision for the AdmissionReview made by mutatePod.
 func (whsvr *WebhookServer) mutate(ctx context.Context, admissionReview *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+	if admissionReview.Request == nil {
+		status := k8serrors.NewBadRequest(errMissingAdmissionRequest.Error()).ErrStatus
+		return &v1beta1.AdmissionResponse{Result: &status}, errMissingAdmissionRequest
+	}
+
+	ctx, span := tracer().Start(ctx, "webhook.mutate", trace.WithAttributes(
+		attribute.String(tracingAttributeNamespace, admissionReview.Request.Namespace),
+	))
+	defer span.End()
+
+	admissionResponse, err := whsvr.mutatePod(ctx, admissionReview)
+
+	if err != nil {
+		admissionResponse.UID = admissionReview.Request.UID
+		admissionResponse.Allowed = false
+	}
+
+	span.SetAttributes(attribute.String(tracingAttributeDecision, admissionDecision(admissionResponse, err)))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return admissionResponse, err
+}
+
+func (whsvr *WebhookServer) mutatePod(ctx context.Context, admissionReview *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
 	admissionRequest := admissionReview.Request
 
+	// DELETE and CONNECT requests, and probes sending no object, have nothing to inject into.
+	if admissionRequest.Operation == v1beta1.Delete || admissionRequest.Operation == v1beta1.Connect || isEmptyObject(admissionRequest.Object.Raw) {
+		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+	}
+
 	var pod corev1.Pod
 
-	if err := json.Unmarshal(admissionRequest.Object.Raw, &pod); err != nil {
-		return &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}}, fmt.Errorf("Error unmarshaling AdmissionRequest into Pod: %v", err)
+	// Workloads are injected through their pod template, so that the sidecar shows in their spec.
+	templatePath, workload := workloadTemplatePath(admissionRequest.Resource)
+
+	if workload {
+		var err error
+
+		if pod, err = decodeWorkloadPod(admissionRequest.Object.Raw); err != nil {
+			status := k8serrors.NewBadRequest(err.Error()).ErrStatus
+			return &v1beta1.AdmissionResponse{Result: &status}, fmt.Errorf("Error unmarshaling AdmissionRequest into %s: %v", admissionRequest.Resource.Resource, err)
+		}
+	} else if err := json.Unmarshal(admissionRequest.Object.Raw, &pod); err != nil {
+		status := k8serrors.NewBadRequest(err.Error()).ErrStatus
+		return &v1beta1.AdmissionResponse{Result: &status}, fmt.Errorf("Error unmarshaling AdmissionRequest into Pod: %v", err)
+	}
+
+	if whsvr.isNamespaceExcluded(admissionRequest.Namespace) {
+		whsvr.recordEvent(admissionRequest.Namespace, signingProxyWebhookEventReasonSkipped, "Skipped sidecar injection for pod %s, namespace is excluded", podName(&pod))
+		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+	}
+
+	// Ephemeral containers are added through the pods/ephemeralcontainers subresource of pods
+	// that may already run the sidecar, so only an existing ephemeral sidecar is skipped. Since
+	// they are added to running pods, only pods that opt in get an ephemeral sidecar.
+	ephemeral := !workload && admissionRequest.SubResource == signingProxyWebhookEphemeralSubResource
+
+	if ephemeral {
+		if injectEphemeral, _ := strconv.ParseBool(whsvr.annotation(&pod.ObjectMeta, signingProxyWebhookAnnotationInjectEphemeralKey)); !injectEphemeral {
+			return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+		}
+	}
+
+	// Pods are injected when created. Updates of the pod itself would otherwise re-run the injection,
+	// while ephemeral containers and workload templates are only ever added to on UPDATE.
+	if admissionRequest.Operation == v1beta1.Update && !ephemeral && !workload && !whsvr.config.InjectOnUpdate {
+		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+	}
+
+	// Workload templates are updated in place, so their sidecar can be replaced when its config
+	// changed. Pods are not, since the API server only allows changing the image of a container.
+	reinject := workload && admissionRequest.Operation == v1beta1.Update && whsvr.config.ReinjectOnChange && hasSidecarContainer(&pod)
+
+	if ephemeral {
+		if hasEphemeralSidecarContainer(&pod) {
+			whsvr.recordEvent(admissionRequest.Namespace, signingProxyWebhookEventReasonSkipped, "Skipped ephemeral sidecar injection for pod %s, ephemeral sidecar already injected", podName(&pod))
+			return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+		}
+	} else if whsvr.isInjected(&pod) && !reinject {
+		whsvr.recordEvent(admissionRequest.Namespace, signingProxyWebhookEventReasonSkipped, "Skipped sidecar injection for pod %s, sidecar already injected", podName(&pod))
+		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
 	}
 
 	nsLabels, err := whsvr.describeNamespace(ctx, admissionRequest.Namespace)
 
 	if err != nil {
-		return &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}}, fmt.Errorf("Error describing namespace: %v", err)
+		if whsvr.config.FailOpen {
+			log.Printf("Allowing pod without sidecar injection, failing open: %v", err)
+			return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+		}
+
+		return internalErrorResponse(err), fmt.Errorf("Error describing namespace: %v", err)
 	}
 
-	if !whsvr.shouldMutate(nsLabels, &pod.ObjectMeta) {
-		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+	// deny rejects the pod unless its namespace is labeled to allow pods unmodified when the
+	// sidecar cannot be injected.
+	deny := func(err error) (*v1beta1.AdmissionResponse, error) {
+		if failOpen, _ := strconv.ParseBool(nsLabels[signingProxyWebhookLabelFailOpenKey]); failOpen {
+			log.Printf("Allowing AdmissionRequest %s without sidecar injection, namespace %s fails open: %v", admissionRequest.UID, admissionRequest.Namespace, err)
+			whsvr.recordEvent(admissionRequest.Namespace, signingProxyWebhookEventReasonSkipped, "Skipped sidecar injection for pod %s, failing open: %v", podName(&pod), err)
+
+			return &v1beta1.AdmissionResponse{
+				Allowed:  true,
+				UID:      admissionRequest.UID,
+				Warnings: []string{fmt.Sprintf("Sidecar was not injected: %v", err)},
+			}, nil
+		}
+
+		return denyAdmission(admissionRequest.UID, err), nil
 	}
 
-	var patchOperations []PatchOperation
+	var injection *sidecarPatch
 
-	host, name, region, unsignedPayload, scheme := whsvr.getUpstreamEndpointParameters(nsLabels, &pod.ObjectMeta)
+	if reinject {
+		injection, err = whsvr.buildReinjectPatch(ctx, &pod, admissionRequest.Namespace, nsLabels)
+	} else {
+		injection, err = whsvr.buildPatch(ctx, &pod, admissionRequest.Namespace, nsLabels, ephemeral, workload)
+	}
 
-	sidecarArgs := []string{"--name", name, "--region", region, "--host", host, "--port", ":8005", "--upstream-url-scheme", scheme}
-	s, _ := strconv.ParseBool(unsignedPayload)
+	var internal internalError
 
-	if s {
-		sidecarArgs = []string{"--name", name, "--region", region, "--host", host, "--port", ":8005", "--unsigned-payload", "--upstream-url-scheme", scheme}
+	if errors.As(err, &internal) {
+		return internalErrorResponse(internal.err), internal.err
+	} else if err != nil {
+		return deny(err)
 	}
 
-	roleArn := whsvr.getRoleArn(nsLabels, &pod.ObjectMeta)
+	if injection == nil {
+		whsvr.recordEvent(admissionRequest.Namespace, signingProxyWebhookEventReasonSkipped, "Skipped sidecar injection for pod %s", podName(&pod))
+		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID}, nil
+	}
 
-	if roleArn != "" {
-		sidecarArgs = append(sidecarArgs, "--role-arn", roleArn)
+	if workload {
+		injection.operations = prefixPatch(injection.operations, templatePath)
 	}
 
-	image := whsvr.getProxyImage()
+	return whsvr.patchResponse(admissionRequest, &pod, injection.operations, injection.image, injection.warnings)
+}
 
-	sidecarContainer := []corev1.Container{{
-		Name:            "sidecar-aws-sigv4-proxy",
-		Image:           image,
-		ImagePullPolicy: corev1.PullIfNotPresent,
-		Ports: []corev1.ContainerPort{{
-			ContainerPort: 8005,
-		}},
-		Args: sidecarArgs,
-	}}
+// isEmptyObject returns whether raw holds no object, such as the object of a DELETE request.
+func isEmptyObject(raw []byte) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
+
+// sidecarPatch is the result of buildPatch for a pod that gets the sidecar.
+type sidecarPatch struct {
+	operations []PatchOperation
+	image      string           // Sidecar image, reported in the injection event
+	warnings   []string         // Warnings returned to the client with the patch
+	container  corev1.Container // Sidecar container added by the patch
+	checksum   string           // Checksum of the sidecar container
+}
 
-	patchOperations = append(patchOperations, addContainers(pod.Spec.Containers, sidecarContainer, "/spec/containers")...)
+// internalError marks buildPatch errors caused by the controller rather than the pod, which
+// are answered with a 500 InternalError instead of denying the pod.
+type internalError struct {
+	err error
+}
 
-	annotations := map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"}
+func (e internalError) Error() string {
+	return e.err.Error()
+}
 
-	patchOperations = append(patchOperations, updateAnnotations(pod.Annotations, annotations)...)
+// BuildPatch returns the JSON Patch operations injecting the sidecar into pod, given the labels
+// of its namespace, independently of any AdmissionReview. It returns no operations if the
+// pod is not to be injected or already is, and an error explaining why the pod must be
+// rejected otherwise.
+func (whsvr *WebhookServer) BuildPatch(pod corev1.Pod, nsLabels map[string]string) ([]PatchOperation, error) {
+	if whsvr.isNamespaceExcluded(pod.Namespace) || whsvr.isInjected(&pod) {
+		return nil, nil
+	}
 
-	patchBytes, err := json.Marshal(patchOperations)
+	injection, err := whsvr.buildPatch(context.Background(), &pod, pod.Namespace, nsLabels, false, false)
+
+	if err != nil || injection == nil {
+		return nil, err
+	}
+
+	return injection.operations, nil
+}
+
+// buildPatch computes the sidecar patch of pod in namespace, adding an ephemeral container
+// instead of a regular sidecar if ephemeral is set. Pods that are the template of a workload
+// are not checked against ResourceQuotas. It returns nil if the pod is not to be injected.
+func (whsvr *WebhookServer) buildPatch(ctx context.Context, pod *corev1.Pod, namespace string, nsLabels map[string]string, ephemeral, workload bool) (*sidecarPatch, error) {
+	podMetadata, err := whsvr.applyNamespaceDefaults(namespace, &pod.ObjectMeta)
 
 	if err != nil {
-		return &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}}, fmt.Errorf("Error unmarshaling AdmissionRequest into Pod: %v", err)
+		return nil, err
 	}
 
-	log.Printf("Admission Response: %v", string(patchBytes))
+	if whsvr.config.StrictInject && whsvr.injectWithoutUpstream(nsLabels, podMetadata) {
+		return nil, fmt.Errorf("Pod sets %s=true but no %s or %s annotation, and namespace %s has no %s or %s label",
+			whsvr.annotationKey(signingProxyWebhookAnnotationInjectKey), whsvr.annotationKey(signingProxyWebhookAnnotationHostKey),
+			whsvr.annotationKey(signingProxyWebhookAnnotationServiceKey), namespace,
+			signingProxyWebhookLabelHostKey, signingProxyWebhookLabelServiceKey)
+	}
 
-	return &v1beta1.AdmissionResponse{
-		Allowed: true,
-		UID:     admissionRequest.UID,
-		Patch:   patchBytes,
-		PatchType: func() *v1beta1.PatchType {
-			pt := v1beta1.PatchTypeJSONPatch
-			return &pt
-		}(),
-	}, nil
-}
+	if !whsvr.shouldMutate(nsLabels, podMetadata) {
+		return nil, nil
+	}
 
-func (whsvr *WebhookServer) describeNamespace(ctx context.Context, namespace string) (map[string]string, error) {
-	ns, err := whsvr.namespaceClient.Get(ctx, namespace, metav1.GetOptions{})
+	if err := whsvr.validateAnnotationLengths(podMetadata); err != nil {
+		return nil, err
+	}
+
+	var patchOperations []PatchOperation
+
+	host, name, region, unsignedPayload, scheme, err := whsvr.getUpstreamEndpointParameters(nsLabels, podMetadata)
 
 	if err != nil {
-		return nil, fmt.Errorf("Error describing namespace: %v", err)
+		return nil, err
 	}
 
-	log.Printf("Namespace labels: %s", ns.Labels)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(tracingAttributeHost, host))
 
-	return ns.Labels, nil
-}
+	if !whsvr.isHostAllowed(host) {
+		return nil, fmt.Errorf("Host %q is not in the list of allowed upstream hosts", host)
+	}
 
-func (whsvr *WebhookServer) shouldMutate(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) bool {
-	annotations := podMetadata.GetAnnotations()
+	hostHeader, err := whsvr.getHostHeader(podMetadata)
 
-	if annotations == nil {
-		annotations = map[string]string{}
+	if err != nil {
+		return nil, err
 	}
 
-	if annotations[signingProxyWebhookAnnotationStatusKey] == "injected" {
-		return false
+	signHeaders, err := whsvr.getSignHeaders(podMetadata)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if annotations[signingProxyWebhookAnnotationHostKey] == "" && nsLabels[signingProxyWebhookLabelHostKey] == "" {
-		return false
+	pathPrefix, err := whsvr.getUpstreamPathPrefix(podMetadata)
+
+	if err != nil {
+		return nil, err
 	}
 
-	var annotationInject bool
-	var annotationReject bool
+	sharedContainerIndex, sharedVolumePath, err := whsvr.getSharedVolume(podMetadata, pod.Spec.Containers)
 
-	switch strings.ToLower(annotations[signingProxyWebhookAnnotationInjectKey]) {
-	case "y", "yes", "true", "on":
-		annotationInject = true
-	case "n", "no", "false", "off":
-		annotationReject = true
+	if err != nil {
+		return nil, err
 	}
 
-	var labelInject bool
+	socket, err := whsvr.getUnixSocket(podMetadata, sharedContainerIndex >= 0, sharedVolumePath)
 
-	for _, nsSelector := range namespaceSelector {
-		selector, err := metav1.LabelSelectorAsSelector(&nsSelector)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			fmt.Errorf("Invalid selector for NamespaceSelector")
-			return false
-		} else if !selector.Empty() && selector.Matches(labels.Set(nsLabels)) {
-			labelInject = true
-		} else if !annotationInject {
-			return false
-		}
+	argsValues := sidecarArgsValues{
+		Host:              host,
+		Name:              name,
+		Region:            region,
+		UpstreamURLScheme: scheme,
+		PathPrefix:        pathPrefix,
+		SignHost:          hostHeader,
+		CustomHeaders:     signHeaders,
+		RoleArn:           whsvr.getRoleArn(namespace, nsLabels, podMetadata),
+		Port:              signingProxyWebhookProxyPort,
+		Socket:            socket,
 	}
 
-	if labelInject {
-		return !annotationReject
+	if ephemeral {
+		argsValues.Port = signingProxyWebhookEphemeralProxyPort
 	}
+	argsValues.UnsignedPayload, _ = strconv.ParseBool(unsignedPayload)
 
-	return annotationInject
-}
+	if argsValues.RoleArn != "" {
+		argsValues.RoleExternalId, argsValues.RoleSessionName = whsvr.getRoleAssumeParameters(nsLabels, podMetadata)
+	}
+
+	if argsValues.RoleArn == "" && whsvr.config.RequireIRSA {
+		if err := whsvr.checkIRSA(namespace, pod.Spec.ServiceAccountName); err != nil {
+			return nil, err
+		}
+	}
+
+	// With a projected token the AWS SDK of the proxy assumes the role from AWS_ROLE_ARN itself,
+	// so the proxy is not also asked to assume it with --role-arn.
+	roleArn, roleSessionName := argsValues.RoleArn, argsValues.RoleSessionName
+	projectedToken := whsvr.config.ProjectedToken && roleArn != ""
 
-func (whsvr *WebhookServer) getUpstreamEndpointParameters(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) (string, string, string, string, string) {
-	annotations := podMetadata.GetAnnotations()
+	if projectedToken {
+		if argsValues.RoleExternalId != "" {
+			return nil, fmt.Errorf("A role external ID cannot be used with projected service account tokens, which assume the role %s with web identity", roleArn)
+		}
 
-	if annotations == nil {
-		annotations = map[string]string{}
+		argsValues.RoleArn, argsValues.RoleSessionName = "", ""
 	}
 
-	host := annotations[signingProxyWebhookAnnotationHostKey]
+	sidecarArgs := argsValues.defaultArgs(whsvr.config.UnixSocketFlag)
 
-	var labelInject bool
+	if whsvr.config.ArgsTemplate != "" {
+		sidecarArgs, err = renderArgsTemplate(whsvr.config.ArgsTemplate, argsValues)
 
-	if strings.TrimSpace(host) == "" {
-		labelInject = true
-		host = nsLabels[signingProxyWebhookLabelHostKey]
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if labelInject {
-		return extractParameters(host, nsLabels[signingProxyWebhookLabelNameKey], nsLabels[signingProxyWebhookLabelRegionKey], nsLabels[signingProxyWebhookLabelUnsignedPayloadKey], nsLabels[signingProxyWebhookLabelSchemeKey])
+	logLevelArgs, err := whsvr.getProxyLogLevelArgs(podMetadata)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return extractParameters(host, annotations[signingProxyWebhookAnnotationNameKey], annotations[signingProxyWebhookAnnotationRegionKey], annotations[signingProxyWebhookAnnotationUnsignedPayloadKey], annotations[signingProxyWebhookAnnotationSchemeKey])
-}
+	sidecarArgs = append(sidecarArgs, logLevelArgs...)
 
-func extractParameters(host string, name string, region string, unsignedPayload string, upstreamUrlScheme string) (string, string, string, string, string) {
+	transportArgs, err := whsvr.getTransportArgs(podMetadata)
 
-	if strings.TrimSpace(name) == "" {
-		name = host[:strings.IndexByte(host, '.')]
+	if err != nil {
+		return nil, err
 	}
 
-	hostModified := host[strings.IndexByte(host, '.')+1:]
-
-	if strings.TrimSpace(region) == "" {
-		region = hostModified[:strings.IndexByte(hostModified, '.')]
+	sidecarArgs = append(sidecarArgs, transportArgs...)
+
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+
+	caBundleConfigMap, caBundleKey, caBundlePath := whsvr.getCABundle(podMetadata)
+
+	if caBundleConfigMap != "" {
+		sidecarArgs = append(sidecarArgs, "--ca-bundle", caBundlePath)
+		volumes = append(volumes, corev1.Volume{
+			Name: signingProxyWebhookCABundleVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: caBundleConfigMap},
+					Items: []corev1.KeyToPath{{
+						Key:  caBundleKey,
+						Path: path.Base(caBundlePath),
+					}},
+				},
+			},
+		})
+		// Only the bundle file is mounted, so that the rest of its directory in the image, such
+		// as the system CA certificates in /etc/ssl/certs, stays visible.
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      signingProxyWebhookCABundleVolumeName,
+			MountPath: caBundlePath,
+			SubPath:   path.Base(caBundlePath),
+			ReadOnly:  true,
+		})
 	}
 
-	upstreamUrlScheme = strings.ToLower(upstreamUrlScheme)
+	if projectedToken {
+		volumes = append(volumes, projectedTokenVolume())
+		volumeMounts = append(volumeMounts, projectedTokenVolumeMount())
+	}
 
-	if upstreamUrlScheme == "" || (upstreamUrlScheme != "http" && upstreamUrlScheme != "https") {
-		upstreamUrlScheme = "https"
+	if sharedContainerIndex >= 0 {
+		volumes = append(volumes, corev1.Volume{
+			Name:         signingProxyWebhookSharedVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      signingProxyWebhookSharedVolumeName,
+			MountPath: sharedVolumePath,
+		})
 	}
 
-	return host, name, region, unsignedPayload, upstreamUrlScheme
-}
+	image := whsvr.getProxyImage()
 
-func (whsvr *WebhookServer) getRoleArn(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) string {
-	annotations := podMetadata.GetAnnotations()
+	warnings := whsvr.getDeprecationWarnings(nsLabels, podMetadata)
 
-	if annotations == nil {
-		annotations = map[string]string{}
+	if whsvr.imageVerifier != nil {
+		if err := whsvr.imageVerifier.verify(ctx, image); err != nil {
+			if whsvr.config.VerifyImage != VerifyImageWarn {
+				return nil, err
+			}
+
+			log.Printf("Injecting sidecar into pod %s despite failed image verification: %v", podName(pod), err)
+			warnings = append(warnings, err.Error())
+		}
 	}
 
-	roleArn := annotations[signingProxyWebhookAnnotationRoleArnKey]
+	portName, err := whsvr.getPortName(podMetadata)
 
-	if strings.TrimSpace(roleArn) == "" {
-		roleArn = nsLabels[signingProxyWebhookLabelRoleArnKey]
+	if err != nil {
+		return nil, err
 	}
 
-	return roleArn
-}
+	pullPolicy, err := whsvr.getImagePullPolicy(nsLabels, podMetadata)
 
-func (whsvr *WebhookServer) getProxyImage() string {
-	image := os.Getenv("AWS-SIGV4-PROXY-IMAGE")
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarContainer := []corev1.Container{{
+		Name:            signingProxyWebhookContainerName,
+		Image:           image,
+		ImagePullPolicy: pullPolicy,
+		Ports: []corev1.ContainerPort{{
+			Name:          portName,
+			ContainerPort: 8005,
+		}},
+		Args:         sidecarArgs,
+		VolumeMounts: volumeMounts,
+	}}
 
-	if image == "" {
-		image = "public.ecr.aws/aws-observability/aws-sigv4-proxy:latest"
+	if socket != "" || !whsvr.exposePort(podMetadata) {
+		// The proxy does not listen on a TCP port when serving a unix socket, and pods may
+		// leave the port undeclared to keep Services from selecting it.
+		sidecarContainer[0].Ports = nil
 	}
 
-	return image
-}
+	resources, err := whsvr.getResourceRequirements(podMetadata)
 
-func addContainers(target, containers []corev1.Container, basePath string) (patch []PatchOperation) {
-	first := len(target) == 0
+	if err != nil {
+		return nil, err
+	}
 
-	var value interface{}
+	if resources == nil {
+		if resources, err = whsvr.getLimitRangeResources(namespace); err != nil {
+			return nil, internalError{err}
+		}
+	}
 
-	for _, container := range containers {
-		value = container
-		path := basePath
+	if resources != nil {
+		sidecarContainer[0].Resources = *resources
 
-		if first {
-			first = false
-			value = []corev1.Container{container}
-		} else {
-			path += "/-"
+		// A workload template uses no quota itself. Its pods are checked by the API server
+		// when they are created.
+		if !workload {
+			if err := whsvr.checkResourceQuota(namespace, pod, *resources); err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		patch = append(patch, PatchOperation{
-			Op:    "add",
-			Path:  path,
-			Value: value,
+	envFromSecret, err := whsvr.getEnvFromSecret(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if envFromSecret != "" {
+		sidecarContainer[0].EnvFrom = append(sidecarContainer[0].EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: envFromSecret},
+			},
 		})
 	}
 
-	return patch
-}
+	proxyEnv, err := whsvr.getProxyEnv(podMetadata)
 
-func updateAnnotations(target map[string]string, annotations map[string]string) (patch []PatchOperation) {
-	for key, value := range annotations {
-		op := "replace"
-		if target == nil || target[key] == "" {
-			op = "add"
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarContainer[0].Env = append(sidecarContainer[0].Env, proxyEnv...)
+
+	if projectedToken {
+		sidecarContainer[0].Env = append(sidecarContainer[0].Env, projectedTokenEnv(roleArn, roleSessionName)...)
+	}
+
+	sidecarContainer[0].Env = append(sidecarContainer[0].Env, whsvr.getAnnotationEnv(podMetadata)...)
+
+	if whsvr.config.SetGOMAXPROCS {
+		if gomaxprocs, ok := getGOMAXPROCS(sidecarContainer[0].Resources); ok {
+			sidecarContainer[0].Env = append(sidecarContainer[0].Env, corev1.EnvVar{Name: "GOMAXPROCS", Value: strconv.Itoa(gomaxprocs)})
 		}
-		patch = append(patch, PatchOperation{
-			Op:    op,
-			Path:  "/metadata/annotations/" + strings.ReplaceAll(key, "/", "~1"),
-			Value: value,
-		})
 	}
 
-	return patch
+	startupProbe, err := whsvr.getStartupProbe(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if startupProbe != nil && socket != "" {
+		return nil, fmt.Errorf("Annotation %s cannot be combined with %s", whsvr.annotationKey(signingProxyWebhookAnnotationProbesKey), whsvr.annotationKey(signingProxyWebhookAnnotationUnixSocketKey))
+	}
+
+	sidecarContainer[0].StartupProbe = startupProbe
+
+	command, err := whsvr.getCommand(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarContainer[0].Command = command
+
+	preStop, err := whsvr.getPreStopHook(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if preStop != nil {
+		sidecarContainer[0].Lifecycle = &corev1.Lifecycle{PreStop: preStop}
+	}
+
+	nodeAffinity, err := whsvr.getNodeAffinity(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transparent, transparentPorts, err := whsvr.getTransparentParameters(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transparent && socket != "" {
+		return nil, fmt.Errorf("Annotation %s cannot be combined with %s", whsvr.annotationKey(signingProxyWebhookAnnotationTransparentKey), whsvr.annotationKey(signingProxyWebhookAnnotationUnixSocketKey))
+	}
+
+	if ephemeral {
+		if transparent {
+			return nil, fmt.Errorf("Annotation %s is not supported for ephemeral containers", whsvr.annotationKey(signingProxyWebhookAnnotationTransparentKey))
+		}
+
+		if socket != "" {
+			return nil, fmt.Errorf("Annotation %s is not supported for ephemeral containers", whsvr.annotationKey(signingProxyWebhookAnnotationUnixSocketKey))
+		}
+
+		patchOperations, err = addEphemeralSidecarContainer(pod, sidecarContainer[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &sidecarPatch{operations: patchOperations, image: image, warnings: warnings}, nil
+	}
+
+	var initContainers []corev1.Container
+
+	if transparent {
+		proxyUID := int64(signingProxyWebhookTransparentProxyUID)
+		sidecarContainer[0].SecurityContext = &corev1.SecurityContext{RunAsUser: &proxyUID}
+
+		initImage := whsvr.getProxyInitImage()
+
+		if err := validateImage(initImage, whsvr.config.RequireDigest); err != nil {
+			return nil, err
+		}
+
+		initContainers = append(initContainers, newTransparentInitContainer(initImage, transparentPorts))
+	}
+
+	sharedContainerPath := sharedContainerIndex
+
+	if whsvr.config.NativeSidecars {
+		// Native sidecars are init containers that keep running, started after the transparent
+		// init container and before the pod's own init containers, so that those can already
+		// reach the proxy. The restartPolicy field is only set in this mode, since clusters
+		// without native sidecar support reject it.
+		restartPolicy := corev1.ContainerRestartPolicyAlways
+		sidecarContainer[0].RestartPolicy = &restartPolicy
+		initContainers = append(initContainers, sidecarContainer...)
+	} else if whsvr.config.SidecarPosition == SidecarPositionFirst {
+		patchOperations = append(patchOperations, prependContainers(pod.Spec.Containers, sidecarContainer, "/spec/containers")...)
+
+		// The pod's containers move down by the inserted sidecar for the operations that follow.
+		sharedContainerPath += len(sidecarContainer)
+	} else {
+		patchOperations = append(patchOperations, addToList(pod.Spec.Containers, sidecarContainer, "/spec/containers")...)
+	}
+
+	checksum, err := sidecarChecksum(sidecarContainer[0])
+
+	if err != nil {
+		return nil, internalError{err}
+	}
+
+	if whsvr.config.NativeSidecars {
+		patchOperations = append(patchOperations, prependContainers(pod.Spec.InitContainers, initContainers, "/spec/initContainers")...)
+	} else {
+		patchOperations = append(patchOperations, addToList(pod.Spec.InitContainers, initContainers, "/spec/initContainers")...)
+	}
+
+	patchOperations = append(patchOperations, addToList(pod.Spec.Volumes, volumes, "/spec/volumes")...)
+
+	if sharedContainerIndex >= 0 {
+		sharedVolumeMounts := []corev1.VolumeMount{{
+			Name:      signingProxyWebhookSharedVolumeName,
+			MountPath: sharedVolumePath,
+		}}
+		basePath := fmt.Sprintf("/spec/containers/%d/volumeMounts", sharedContainerPath)
+		patchOperations = append(patchOperations, addToList(pod.Spec.Containers[sharedContainerIndex].VolumeMounts, sharedVolumeMounts, basePath)...)
+	}
+
+	if nodeAffinity != nil {
+		patchOperations = append(patchOperations, addNodeAffinity(pod.Spec.Affinity, nodeAffinity, "/spec/affinity"))
+	}
+
+	if whsvr.config.DNSConfig != nil {
+		patchOperations = append(patchOperations, addDNSConfig(pod.Spec.DNSConfig, whsvr.config.DNSConfig, "/spec/dnsConfig"))
+	}
+
+	imagePullSecret := whsvr.getImagePullSecret(nsLabels, podMetadata)
+
+	if imagePullSecret != "" {
+		imagePullSecrets := []corev1.LocalObjectReference{{Name: imagePullSecret}}
+		patchOperations = append(patchOperations, addImagePullSecrets(pod.Spec.ImagePullSecrets, imagePullSecrets, "/spec/imagePullSecrets")...)
+	}
+
+	if whsvr.config.AddEgressLabel {
+		if pod.Labels == nil {
+			patchOperations = append(patchOperations, PatchOperation{
+				Op:    "add",
+				Path:  "/metadata/labels",
+				Value: map[string]string{},
+			})
+		}
+
+		patchOperations = append(patchOperations, updateMetadata(pod.Labels, map[string]string{signingProxyWebhookEgressLabelKey: signingProxyWebhookEgressLabelValue}, "/metadata/labels")...)
+	}
+
+	annotations := map[string]string{}
+
+	if noStatus, _ := strconv.ParseBool(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationNoStatusKey)); !noStatus {
+		annotations[whsvr.statusAnnotation()] = "injected"
+
+		// The controller version is recorded for audits of which release injected the sidecar.
+		if whsvr.config.ControllerVersion != "" {
+			annotations[whsvr.annotationKey(signingProxyWebhookAnnotationInjectedByKey)] = whsvr.config.ControllerVersion
+		}
+	}
+
+	if whsvr.config.ReinjectOnChange {
+		annotations[whsvr.annotationKey(signingProxyWebhookAnnotationChecksumKey)] = checksum
+	}
+
+	if socket == "" {
+		for key, value := range getMeshExclusionAnnotations(whsvr.config.MeshExclusion, pod.Annotations, argsValues.Port) {
+			annotations[key] = value
+		}
+	}
+
+	if whsvr.config.AnnotateResolvedConfig {
+		resolved, err := json.Marshal(resolvedConfig{
+			Host:              host,
+			Name:              name,
+			Region:            region,
+			UpstreamURLScheme: scheme,
+			RoleArn:           roleArn,
+			Image:             image,
+		})
+
+		if err != nil {
+			return nil, internalError{fmt.Errorf("Error marshaling resolved config: %v", err)}
+		}
+
+		annotations[whsvr.annotationKey(signingProxyWebhookAnnotationResolvedConfigKey)] = string(resolved)
+	}
+
+	if len(annotations) > 0 {
+		if pod.Annotations == nil {
+			patchOperations = append(patchOperations, PatchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations",
+				Value: map[string]string{},
+			})
+		}
+
+		patchOperations = append(patchOperations, updateMetadata(pod.Annotations, annotations, "/metadata/annotations")...)
+	}
+
+	return &sidecarPatch{operations: patchOperations, image: image, warnings: warnings, container: sidecarContainer[0], checksum: checksum}, nil
+}
+
+// patchResponse builds the response applying patchOperations to the pod, or allowing it
+// unmodified in dry-run mode, with warnings returned to the client either way.
+func (whsvr *WebhookServer) patchResponse(admissionRequest *v1beta1.AdmissionRequest, pod *corev1.Pod, patchOperations []PatchOperation, image string, warnings []string) (*v1beta1.AdmissionResponse, error) {
+	patchBytes, err := json.Marshal(patchOperations)
+
+	if err != nil {
+		return internalErrorResponse(err), fmt.Errorf("Error marshaling patch: %v", err)
+	}
+
+	if whsvr.config.DryRun {
+		log.Printf("Dry run, skipping Admission Response: would inject sidecar %s into pod %s/%s with patch %s", image, admissionRequest.Namespace, podName(pod), redactRoleArns(patchBytes))
+		whsvr.logPatch(patchBytes)
+		return &v1beta1.AdmissionResponse{Allowed: true, UID: admissionRequest.UID, Warnings: warnings}, nil
+	}
+
+	log.Printf("Admission Response: injecting sidecar %s into pod %s/%s with %d patch operations", image, admissionRequest.Namespace, podName(pod), len(patchOperations))
+	whsvr.logPatch(patchBytes)
+
+	whsvr.recordEvent(admissionRequest.Namespace, signingProxyWebhookEventReasonInjected, "Injected sidecar %s into pod %s", image, podName(pod))
+
+	return &v1beta1.AdmissionResponse{
+		Allowed:  true,
+		UID:      admissionRequest.UID,
+		Patch:    patchBytes,
+		Warnings: warnings,
+		// JSONPatch is the only patch type the API server accepts from mutating webhooks, in
+		// both admission.k8s.io/v1beta1 and v1, so no merge patch alternative is offered.
+		PatchType: func() *v1beta1.PatchType {
+			pt := v1beta1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}, nil
+}
+
+// redactRoleArns returns the patch with the role ARNs it carries replaced, so that dry runs can
+// show the patch in info logs.
+func redactRoleArns(patchBytes []byte) string {
+	return roleArnPattern.ReplaceAllString(string(patchBytes), "<redacted role ARN>")
+}
+
+// logPatch logs the patch base64 encoded at the debug log level only, since the patch carries
+// the role ARN and other settings of the sidecar that should not be in info logs.
+func (whsvr *WebhookServer) logPatch(patchBytes []byte) {
+	if whsvr.config.LogLevel != LogLevelDebug {
+		return
+	}
+
+	log.Printf("Admission Response patch (base64): %s", base64.StdEncoding.EncodeToString(patchBytes))
+}
+
+// denyAdmission builds a response rejecting the AdmissionRequest with the error as the reason.
+// Denials are caused by the pod's configuration, so they are reported as a 400 BadRequest.
+func denyAdmission(uid types.UID, err error) *v1beta1.AdmissionResponse {
+	log.Printf("Denying AdmissionRequest %s: %v", uid, err)
+
+	status := k8serrors.NewBadRequest(err.Error()).ErrStatus
+
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		UID:     uid,
+		Result:  &status,
+	}
+}
+
+// internalErrorResponse builds a response for an error that is not caused by the pod, such as
+// a failed API call, reported as a 500 InternalError.
+func internalErrorResponse(err error) *v1beta1.AdmissionResponse {
+	status := k8serrors.NewInternalError(err).ErrStatus
+
+	return &v1beta1.AdmissionResponse{Result: &status}
+}
+
+// recordEvent records a Normal event against the namespace, since the pod
+// being admitted may not exist yet.
+func (whsvr *WebhookServer) recordEvent(namespace string, reason string, messageFmt string, args ...interface{}) {
+	if whsvr.recorder == nil {
+		return
+	}
+
+	ref := &corev1.ObjectReference{
+		Kind:       "Namespace",
+		APIVersion: "v1",
+		Name:       namespace,
+	}
+
+	whsvr.recorder.Eventf(ref, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func (whsvr *WebhookServer) isNamespaceExcluded(namespace string) bool {
+	for _, excluded := range whsvr.config.ExcludedNamespaces {
+		if excluded == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasEphemeralSidecarContainer reports whether the sidecar was already added to the pod as an
+// ephemeral container.
+func hasEphemeralSidecarContainer(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.EphemeralContainers {
+		if container.Name == signingProxyWebhookEphemeralContainerName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addEphemeralSidecarContainer returns the patch adding sidecar as an ephemeral container. The
+// ephemeralcontainers subresource only updates the ephemeral containers, so the fields that
+// ephemeral containers do not support are dropped and the volumes the sidecar mounts must
+// already be defined in the pod.
+func addEphemeralSidecarContainer(pod *corev1.Pod, sidecar corev1.Container) ([]PatchOperation, error) {
+	for _, volumeMount := range sidecar.VolumeMounts {
+		if !hasVolume(pod.Spec.Volumes, volumeMount.Name) {
+			return nil, fmt.Errorf("Volume %s mounted by the sidecar is not defined in the pod, ephemeral containers cannot add volumes", volumeMount.Name)
+		}
+	}
+
+	sidecar.Name = signingProxyWebhookEphemeralContainerName
+	sidecar.Ports = nil
+	sidecar.Resources = corev1.ResourceRequirements{}
+	sidecar.StartupProbe = nil
+	sidecar.Lifecycle = nil
+
+	ephemeralContainers := []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon(sidecar)}}
+
+	return addToList(pod.Spec.EphemeralContainers, ephemeralContainers, "/spec/ephemeralContainers"), nil
+}
+
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isInjected reports whether the pod was already injected, either marked with the status
+// annotation or running the sidecar container. Checking the container keeps injection idempotent
+// when the status annotation is opted out of or stripped by GitOps tooling.
+func (whsvr *WebhookServer) isInjected(pod *corev1.Pod) bool {
+	return pod.Annotations[whsvr.statusAnnotation()] == "injected" || hasSidecarContainer(pod)
+}
+
+// hasSidecarContainer reports whether the pod already runs the sidecar as a regular or native
+// sidecar container, for example when it is re-admitted after the status annotation was stripped.
+func hasSidecarContainer(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == signingProxyWebhookContainerName {
+			return true
+		}
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == signingProxyWebhookContainerName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+
+	return pod.GenerateName
+}
+
+func (whsvr *WebhookServer) describeNamespace(ctx context.Context, namespace string) (map[string]string, error) {
+	ctx, span := tracer().Start(ctx, "webhook.describeNamespace", trace.WithAttributes(
+		attribute.String(tracingAttributeNamespace, namespace),
+	))
+	defer span.End()
+
+	// Give up before the API server's webhook timeout, so the controller still answers with a
+	// response the API server can use, for example to fail open.
+	if timeout := whsvr.config.NamespaceGetTimeout.Duration; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if whsvr.namespaceBreaker != nil {
+		if err := whsvr.namespaceBreaker.allow(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, fmt.Errorf("Error describing namespace: %v", err)
+		}
+	}
+
+	var ns *corev1.Namespace
+
+	err := retry.OnError(whsvr.namespaceRetryBackoff(), isRetriableNamespaceError, func() error {
+		var err error
+		ns, err = whsvr.namespaceClient.Get(ctx, namespace, metav1.GetOptions{})
+		return err
+	})
+
+	if whsvr.namespaceBreaker != nil {
+		whsvr.namespaceBreaker.record(err)
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("Error describing namespace: %v", err)
+	}
+
+	log.Printf("Namespace labels: %s", ns.Labels)
+
+	return ns.Labels, nil
+}
+
+// namespaceRetryBackoff doubles Config.NamespaceRetryBaseDelay after each failed attempt
+// at describing the namespace, up to Config.NamespaceRetryAttempts attempts.
+func (whsvr *WebhookServer) namespaceRetryBackoff() wait.Backoff {
+	steps := whsvr.config.NamespaceRetryAttempts
+
+	if steps < 1 {
+		steps = 1
+	}
+
+	return wait.Backoff{
+		Steps:    steps,
+		Duration: whsvr.config.NamespaceRetryBaseDelay.Duration,
+		Factor:   2,
+		Jitter:   0.1,
+	}
+}
+
+// isRetriableNamespaceError reports whether describing the namespace may succeed on a
+// later attempt. Missing namespaces and RBAC errors are not retried.
+func isRetriableNamespaceError(err error) bool {
+	return !k8serrors.IsNotFound(err) && !k8serrors.IsForbidden(err) && !k8serrors.IsUnauthorized(err) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func (whsvr *WebhookServer) shouldMutate(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) bool {
+	if !whsvr.hasUpstream(nsLabels, podMetadata) {
+		return false
+	}
+
+	if whsvr.config.ObjectSelector != nil && !whsvr.config.ObjectSelector.Matches(labels.Set(podMetadata.GetLabels())) {
+		return false
+	}
+
+	annotationInject, annotationReject := parseInjectAnnotation(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationInjectKey))
+
+	var labelInject bool
+
+	for _, nsSelector := range whsvr.namespaceSelectors() {
+		selector, err := metav1.LabelSelectorAsSelector(&nsSelector)
+
+		if err != nil {
+			fmt.Errorf("Invalid selector for NamespaceSelector")
+			return false
+		} else if !selector.Empty() && selector.Matches(labels.Set(nsLabels)) {
+			labelInject = true
+		} else if !annotationInject {
+			return false
+		}
+	}
+
+	if labelInject {
+		return !annotationReject
+	}
+
+	return annotationInject
+}
+
+// hasUpstream reports whether the pod annotations or namespace labels set a host or service.
+func (whsvr *WebhookServer) hasUpstream(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) bool {
+	return whsvr.annotation(podMetadata, signingProxyWebhookAnnotationHostKey) != "" || nsLabels[signingProxyWebhookLabelHostKey] != "" ||
+		whsvr.annotation(podMetadata, signingProxyWebhookAnnotationServiceKey) != "" || nsLabels[signingProxyWebhookLabelServiceKey] != ""
+}
+
+// injectWithoutUpstream reports whether the pod explicitly requests injection although
+// neither the pod nor its namespace sets a host or service.
+func (whsvr *WebhookServer) injectWithoutUpstream(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) bool {
+	inject, _ := parseInjectAnnotation(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationInjectKey))
+
+	return inject && !whsvr.hasUpstream(nsLabels, podMetadata)
+}
+
+// parseInjectAnnotation reports whether the inject annotation value enables or disables
+// injection. Both are false for an unset or unrecognized value.
+func parseInjectAnnotation(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "y", "yes", "true", "on":
+		return true, false
+	case "n", "no", "false", "off":
+		return false, true
+	}
+
+	return false, false
+}
+
+// namespaceSelectors returns the configured namespace selector, or the default
+// sidecar-inject=true selector if none is configured.
+func (whsvr *WebhookServer) namespaceSelectors() []metav1.LabelSelector {
+	if whsvr.config.NamespaceSelector != nil {
+		return []metav1.LabelSelector{*whsvr.config.NamespaceSelector}
+	}
+
+	return namespaceSelector
+}
+
+// statusAnnotation returns the annotation key marking pods as injected. Pods running the
+// sidecar container are skipped whatever their marker, see isInjected.
+func (whsvr *WebhookServer) statusAnnotation() string {
+	if whsvr.config.StatusAnnotation != "" {
+		return whsvr.config.StatusAnnotation
+	}
+
+	return whsvr.annotationKey(signingProxyWebhookAnnotationStatusKey)
+}
+
+// annotationKey returns the annotation key for key under the configured annotation prefix,
+// so organizations can replace the default sidecar.aws.signing-proxy prefix.
+func (whsvr *WebhookServer) annotationKey(key string) string {
+	if whsvr.config.AnnotationPrefix == "" {
+		return key
+	}
+
+	return whsvr.config.AnnotationPrefix + strings.TrimPrefix(key, signingProxyWebhookAnnotationPrefix)
+}
+
+// validateAnnotationLengths rejects settings annotations longer than Config.MaxAnnotationLength,
+// such as a full URL pasted into the host annotation, before they cause less obvious errors.
+// Annotations written by the controller itself are not checked.
+func (whsvr *WebhookServer) validateAnnotationLengths(podMetadata *metav1.ObjectMeta) error {
+	if whsvr.config.MaxAnnotationLength <= 0 {
+		return nil
+	}
+
+	prefix := whsvr.annotationKey(signingProxyWebhookAnnotationPrefix) + "/"
+	written := map[string]bool{
+		whsvr.statusAnnotation(): true,
+		whsvr.annotationKey(signingProxyWebhookAnnotationChecksumKey):       true,
+		whsvr.annotationKey(signingProxyWebhookAnnotationInjectedByKey):     true,
+		whsvr.annotationKey(signingProxyWebhookAnnotationResolvedConfigKey): true,
+	}
+
+	var keys []string
+
+	for key := range podMetadata.GetAnnotations() {
+		if strings.HasPrefix(key, prefix) && !written[key] {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if length := len(podMetadata.Annotations[key]); length > whsvr.config.MaxAnnotationLength {
+			return fmt.Errorf("Annotation %s is %d characters long, more than the maximum of %d", key, length, whsvr.config.MaxAnnotationLength)
+		}
+	}
+
+	return nil
+}
+
+func (whsvr *WebhookServer) getUpstreamEndpointParameters(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) (string, string, string, string, string, error) {
+	// Each parameter is resolved independently: the pod annotation takes precedence over the
+	// namespace label, and extractParameters derives whatever is still unset from the host.
+	parameter := func(annotationKey string, labelKey string) string {
+		return resolve(whsvr.annotation(podMetadata, annotationKey), nsLabels[labelKey])
+	}
+
+	host := parameter(signingProxyWebhookAnnotationHostKey, signingProxyWebhookLabelHostKey)
+	name := parameter(signingProxyWebhookAnnotationNameKey, signingProxyWebhookLabelNameKey)
+	region := parameter(signingProxyWebhookAnnotationRegionKey, signingProxyWebhookLabelRegionKey)
+
+	// A service is only used to build the host when no host is set, and then needs a region.
+	if service := parameter(signingProxyWebhookAnnotationServiceKey, signingProxyWebhookLabelServiceKey); strings.TrimSpace(host) == "" && strings.TrimSpace(service) != "" {
+		region = resolve(region, whsvr.config.DefaultRegion)
+
+		var serviceName string
+		var err error
+
+		if host, serviceName, err = getUpstreamFromService(service, region); err != nil {
+			return "", "", "", "", "", err
+		}
+
+		if strings.TrimSpace(name) == "" {
+			name = serviceName
+		}
+	}
+
+	if preset := parameter(signingProxyWebhookAnnotationPresetKey, signingProxyWebhookLabelPresetKey); strings.TrimSpace(preset) != "" {
+		var err error
+
+		if name, region, err = applyPreset(preset, host, name, region); err != nil {
+			return "", "", "", "", "", err
+		}
+	}
+
+	host, name, region, unsignedPayload, upstreamUrlScheme, err := extractParameters(
+		host,
+		name,
+		region,
+		parameter(signingProxyWebhookAnnotationUnsignedPayloadKey, signingProxyWebhookLabelUnsignedPayloadKey),
+		parameter(signingProxyWebhookAnnotationSchemeKey, signingProxyWebhookLabelSchemeKey),
+		whsvr.config.DefaultRegion,
+	)
+
+	if err != nil {
+		return "", "", "", "", "", err
+	}
+
+	if err := whsvr.validateRegion(region); err != nil {
+		return "", "", "", "", "", err
+	}
+
+	return host, name, region, unsignedPayload, upstreamUrlScheme, nil
+}
+
+// getDeprecationWarnings returns a warning for each deprecated namespace label the pod's
+// sidecar is configured by, shown to users by kubectl. Labels overridden by the pod's
+// annotations are not used, so they are not warned about.
+func (whsvr *WebhookServer) getDeprecationWarnings(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) []string {
+	var warnings []string
+
+	for _, deprecated := range deprecatedLabels {
+		if strings.TrimSpace(nsLabels[deprecated.label]) == "" || strings.TrimSpace(whsvr.annotation(podMetadata, deprecated.annotation)) != "" {
+			continue
+		}
+
+		warnings = append(warnings, fmt.Sprintf("Label-based configuration is deprecated: namespace label %s will be removed, set the %s annotation instead", deprecated.label, whsvr.annotationKey(deprecated.annotation)))
+	}
+
+	return warnings
+}
+
+// validateRegion rejects malformed regions and, unless unknown regions are allowed,
+// well-formed regions that are not in the bundled list of AWS regions. Regions are only
+// validated with --validate-regions, since hosts outside AWS need not name a region.
+func (whsvr *WebhookServer) validateRegion(region string) error {
+	if !whsvr.config.ValidateRegions {
+		return nil
+	}
+
+	if !regionPattern.MatchString(region) {
+		return fmt.Errorf("Invalid region %q: expected an AWS region such as us-west-2", region)
+	}
+
+	if !whsvr.config.AllowUnknownRegions && !knownRegions[region] {
+		return fmt.Errorf("Unknown region %q: use --allow-unknown-regions to allow regions that are not in the bundled list", region)
+	}
+
+	return nil
+}
+
+func extractParameters(host string, name string, region string, unsignedPayload string, upstreamUrlScheme string, defaultRegion string) (string, string, string, string, string, error) {
+	// The name and region are derived from the hostname, while an explicit port is kept
+	// in the host passed to the proxy.
+	hostname, port, err := splitHostPort(strings.TrimSpace(host))
+
+	if err != nil {
+		return "", "", "", "", "", err
+	}
+
+	hostname = strings.TrimSuffix(hostname, ".")
+
+	if err := validateHost(hostname); err != nil {
+		return "", "", "", "", "", err
+	}
+
+	host = hostname
+
+	if port != "" {
+		host = net.JoinHostPort(hostname, port)
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = hostname[:strings.IndexByte(hostname, '.')]
+	}
+
+	hostModified := hostname[strings.IndexByte(hostname, '.')+1:]
+
+	if strings.TrimSpace(region) == "" {
+		// AWS endpoints are parsed by their partition's domain, as the region is not always
+		// the second label, e.g. in dual-stack endpoints.
+		awsRegion, ok, err := regionOfAWSHost(hostname)
+
+		if err != nil {
+			return "", "", "", "", "", err
+		}
+
+		region = hostModified[:strings.IndexByte(hostModified, '.')]
+
+		if ok {
+			region = awsRegion
+		}
+
+		if !regionPattern.MatchString(region) && strings.TrimSpace(defaultRegion) != "" {
+			region = strings.TrimSpace(defaultRegion)
+		}
+	}
+
+	upstreamUrlScheme, err = parseUpstreamScheme(upstreamUrlScheme)
+
+	if err != nil {
+		return "", "", "", "", "", err
+	}
+
+	return host, name, region, unsignedPayload, upstreamUrlScheme, nil
+}
+
+// splitHostPort splits an optional :port suffix off host, such as myservice.example.com:8443.
+// The port is empty if host has none.
+func splitHostPort(host string) (string, string, error) {
+	if !strings.Contains(host, ":") {
+		return host, "", nil
+	}
+
+	hostname, port, err := net.SplitHostPort(host)
+
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid host %q: %v", host, err)
+	}
+
+	portNum, err := strconv.Atoi(port)
+
+	if err != nil || validation.IsValidPortNum(portNum) != nil {
+		return "", "", fmt.Errorf("Invalid port %q in host %q: expected a number between 1 and 65535", port, host)
+	}
+
+	return hostname, port, nil
+}
+
+// parseUpstreamScheme validates the scheme the proxy uses to reach the upstream host,
+// defaulting to https when unset.
+func parseUpstreamScheme(scheme string) (string, error) {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+
+	switch scheme {
+	case "":
+		return "https", nil
+	case "http", "https":
+		return scheme, nil
+	default:
+		return "", fmt.Errorf("Invalid upstream URL scheme %q: expected http or https", scheme)
+	}
+}
+
+// isHostAllowed reports whether the host matches one of the allowed host patterns.
+func (whsvr *WebhookServer) isHostAllowed(host string) bool {
+	if len(whsvr.config.AllowedHosts) == 0 {
+		return true
+	}
+
+	// Patterns match the hostname regardless of the port.
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	host = strings.ToLower(host)
+
+	for _, pattern := range whsvr.config.AllowedHosts {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateHostPatterns checks that each allowed host pattern is a well-formed glob.
+func ValidateHostPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid host pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// validateHost ensures the host is a valid DNS name with at least three labels,
+// since the signing name and region are derived from the first two.
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("Invalid host: host must not be empty")
+	}
+
+	if errs := validation.IsDNS1123Subdomain(strings.ToLower(host)); len(errs) > 0 {
+		return fmt.Errorf("Invalid host %q: %s", host, strings.Join(errs, ", "))
+	}
+
+	if strings.Count(host, ".") < 2 {
+		return fmt.Errorf("Invalid host %q: expected a host of the form <name>.<region>.<domain>", host)
+	}
+
+	return nil
+}
+
+// getRoleArn returns the role ARN from the pod annotation, the namespace label or the configured
+// default role of the namespace, in that order of precedence.
+func (whsvr *WebhookServer) getRoleArn(namespace string, nsLabels map[string]string, podMetadata *metav1.ObjectMeta) string {
+	return resolve(
+		whsvr.annotation(podMetadata, signingProxyWebhookAnnotationRoleArnKey),
+		nsLabels[signingProxyWebhookLabelRoleArnKey],
+		whsvr.config.NamespaceRoleArns[namespace],
+	)
+}
+
+// getRoleAssumeParameters returns the external ID and session name used when assuming
+// the role. The session name defaults to the pod's generateName so that assumed role
+// sessions can be traced back to the owning workload.
+func (whsvr *WebhookServer) getRoleAssumeParameters(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) (string, string) {
+	externalId := resolve(
+		whsvr.annotation(podMetadata, signingProxyWebhookAnnotationRoleExternalIdKey),
+		nsLabels[signingProxyWebhookLabelRoleExternalIdKey],
+	)
+
+	sessionName := resolve(
+		whsvr.annotation(podMetadata, signingProxyWebhookAnnotationRoleSessionNameKey),
+		nsLabels[signingProxyWebhookLabelRoleSessionNameKey],
+		defaultRoleSessionName(podMetadata),
+	)
+
+	return externalId, sessionName
+}
+
+// defaultRoleSessionName derives a role session name from the pod's generateName,
+// falling back to its name, truncated to the 64 characters STS allows.
+func defaultRoleSessionName(podMetadata *metav1.ObjectMeta) string {
+	sessionName := strings.TrimSuffix(podMetadata.GenerateName, "-")
+
+	if sessionName == "" {
+		sessionName = podMetadata.Name
+	}
+
+	if len(sessionName) > 64 {
+		sessionName = sessionName[:64]
+	}
+
+	return sessionName
+}
+
+// getHostHeader returns the Host header the proxy signs for when it differs from the
+// host it connects to, e.g. when dialing a VPC endpoint but signing for the public
+// service name.
+func (whsvr *WebhookServer) getHostHeader(podMetadata *metav1.ObjectMeta) (string, error) {
+	hostHeader := strings.TrimSuffix(resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationHostHeaderKey)), ".")
+
+	if hostHeader == "" {
+		return "", nil
+	}
+
+	if errs := validation.IsDNS1123Subdomain(strings.ToLower(hostHeader)); len(errs) > 0 {
+		return "", fmt.Errorf("Invalid host %q in annotation %s: %s", hostHeader, whsvr.annotationKey(signingProxyWebhookAnnotationHostHeaderKey), strings.Join(errs, ", "))
+	}
+
+	return hostHeader, nil
+}
+
+// getUpstreamPathPrefix returns the path prefix the proxy prepends to the path of each
+// upstream request, e.g. the stage of an API Gateway, or an empty string if unset.
+func (whsvr *WebhookServer) getUpstreamPathPrefix(podMetadata *metav1.ObjectMeta) (string, error) {
+	pathPrefix := strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationPathPrefixKey))
+
+	if pathPrefix == "" {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(pathPrefix, "/") || strings.ContainsAny(pathPrefix, "?# ") {
+		return "", fmt.Errorf("Invalid upstream path prefix %q in annotation %s: must be a path starting with / such as /prod", pathPrefix, whsvr.annotationKey(signingProxyWebhookAnnotationPathPrefixKey))
+	}
+
+	return strings.TrimSuffix(pathPrefix, "/"), nil
+}
+
+// getSignHeaders returns the comma separated key=value headers the proxy adds to each
+// request before signing it.
+func (whsvr *WebhookServer) getSignHeaders(podMetadata *metav1.ObjectMeta) (string, error) {
+	var headers []string
+
+	for _, header := range strings.Split(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationSignHeaderKey), ",") {
+		header = strings.TrimSpace(header)
+
+		if header == "" {
+			continue
+		}
+
+		if key := strings.SplitN(header, "=", 2)[0]; !strings.Contains(header, "=") || strings.TrimSpace(key) == "" {
+			return "", fmt.Errorf("Invalid header %q in annotation %s: expected key=value", header, whsvr.annotationKey(signingProxyWebhookAnnotationSignHeaderKey))
+		}
+
+		headers = append(headers, header)
+	}
+
+	return strings.Join(headers, ","), nil
+}
+
+func (whsvr *WebhookServer) getImagePullSecret(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) string {
+	return resolve(
+		whsvr.annotation(podMetadata, signingProxyWebhookAnnotationImagePullSecretKey),
+		nsLabels[signingProxyWebhookLabelImagePullSecretKey],
+	)
+}
+
+// getImagePullPolicy returns the sidecar's imagePullPolicy from the pod annotation, the
+// namespace label or the configured default, falling back to IfNotPresent.
+func (whsvr *WebhookServer) getImagePullPolicy(nsLabels map[string]string, podMetadata *metav1.ObjectMeta) (corev1.PullPolicy, error) {
+	pullPolicy := resolve(
+		corev1.PullPolicy(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationImagePullPolicyKey)),
+		corev1.PullPolicy(nsLabels[signingProxyWebhookLabelImagePullPolicyKey]),
+		whsvr.config.ImagePullPolicy,
+		corev1.PullIfNotPresent,
+	)
+
+	if err := validatePullPolicy(pullPolicy); err != nil {
+		return "", fmt.Errorf("%v in annotation %s or label %s", err, whsvr.annotationKey(signingProxyWebhookAnnotationImagePullPolicyKey), signingProxyWebhookLabelImagePullPolicyKey)
+	}
+
+	return pullPolicy, nil
+}
+
+// getCABundle returns the ConfigMap holding a custom CA bundle for the sidecar, the key of
+// the bundle in the ConfigMap and the path the bundle is mounted at, or an empty ConfigMap name
+// if none is configured. The key defaults to the file name of the path.
+func (whsvr *WebhookServer) getCABundle(podMetadata *metav1.ObjectMeta) (string, string, string) {
+	configMap := strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationCABundleConfigMapKey))
+	caBundlePath := strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationCABundlePathKey))
+
+	if caBundlePath == "" || !path.IsAbs(caBundlePath) {
+		caBundlePath = signingProxyWebhookCABundleDefaultPath
+	}
+
+	caBundlePath = path.Clean(caBundlePath)
+
+	return configMap, resolve(strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationCABundleKeyKey)), path.Base(caBundlePath)), caBundlePath
+}
+
+// getSharedVolume returns the index of the app container that shares an emptyDir volume
+// with the sidecar and the path it is mounted at in both containers. The index is -1 when
+// no volume is shared.
+func (whsvr *WebhookServer) getSharedVolume(podMetadata *metav1.ObjectMeta, containers []corev1.Container) (int, string, error) {
+	containerName := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationSharedVolumeKey))
+
+	if containerName == "" {
+		return -1, "", nil
+	}
+
+	mountPath := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationSharedVolumePathKey), signingProxyWebhookSharedVolumeDefaultPath)
+
+	if !path.IsAbs(mountPath) {
+		return -1, "", fmt.Errorf("Invalid path %q in annotation %s: must be absolute", mountPath, whsvr.annotationKey(signingProxyWebhookAnnotationSharedVolumePathKey))
+	}
+
+	for i, container := range containers {
+		if container.Name == containerName {
+			return i, path.Clean(mountPath), nil
+		}
+	}
+
+	return -1, "", fmt.Errorf("Container %q in annotation %s not found in pod", containerName, whsvr.annotationKey(signingProxyWebhookAnnotationSharedVolumeKey))
+}
+
+// exposePort returns whether the sidecar declares its container port, true unless the
+// expose-port annotation is false. The proxy listens on the port either way.
+func (whsvr *WebhookServer) exposePort(podMetadata *metav1.ObjectMeta) bool {
+	value := strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationExposePortKey))
+
+	if value == "" {
+		return true
+	}
+
+	expose, err := strconv.ParseBool(value)
+
+	return err != nil || expose
+}
+
+// getUnixSocket returns the path of the unix socket the proxy listens on instead of a TCP
+// port, a file in the volume shared with the app container, or an empty string if the
+// unix-socket annotation is not set. The stock proxy image only listens on TCP ports, so the
+// annotation is rejected unless the flag of an image listening on sockets is configured, or an
+// args template passes the socket itself.
+func (whsvr *WebhookServer) getUnixSocket(podMetadata *metav1.ObjectMeta, shared bool, sharedVolumePath string) (string, error) {
+	socketName := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationUnixSocketKey))
+
+	if socketName == "" {
+		return "", nil
+	}
+
+	if whsvr.config.UnixSocketFlag == "" && whsvr.config.ArgsTemplate == "" {
+		return "", fmt.Errorf("Annotation %s requires a proxy image listening on unix sockets, configured with --unix-socket-flag", whsvr.annotationKey(signingProxyWebhookAnnotationUnixSocketKey))
+	}
+
+	if !shared {
+		return "", fmt.Errorf("Annotation %s requires annotation %s", whsvr.annotationKey(signingProxyWebhookAnnotationUnixSocketKey), whsvr.annotationKey(signingProxyWebhookAnnotationSharedVolumeKey))
+	}
+
+	if strings.Contains(socketName, "/") || socketName == "." || socketName == ".." {
+		return "", fmt.Errorf("Invalid socket file name %q in annotation %s: must not contain a path", socketName, whsvr.annotationKey(signingProxyWebhookAnnotationUnixSocketKey))
+	}
+
+	return path.Join(sharedVolumePath, socketName), nil
+}
+
+// getResourceRequirements returns the sidecar's resource requests and limits, taken from
+// the pod's resource annotations and falling back to the controller defaults for any
+// annotation that is not set. It returns nil if neither provides any resources.
+func (whsvr *WebhookServer) getResourceRequirements(podMetadata *metav1.ObjectMeta) (*corev1.ResourceRequirements, error) {
+	requirements := whsvr.config.DefaultResources.DeepCopy()
+
+	if requirements.Requests == nil {
+		requirements.Requests = corev1.ResourceList{}
+	}
+
+	if requirements.Limits == nil {
+		requirements.Limits = corev1.ResourceList{}
+	}
+
+	quantities := []struct {
+		list       corev1.ResourceList
+		name       corev1.ResourceName
+		annotation string
+	}{
+		{requirements.Requests, corev1.ResourceCPU, signingProxyWebhookAnnotationCPURequestKey},
+		{requirements.Limits, corev1.ResourceCPU, signingProxyWebhookAnnotationCPULimitKey},
+		{requirements.Requests, corev1.ResourceMemory, signingProxyWebhookAnnotationMemoryRequestKey},
+		{requirements.Limits, corev1.ResourceMemory, signingProxyWebhookAnnotationMemoryLimitKey},
+	}
+
+	for _, q := range quantities {
+		value := resolve(whsvr.annotation(podMetadata, q.annotation))
+
+		if value == "" {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid quantity %q in annotation %s: %v", value, whsvr.annotationKey(q.annotation), err)
+		}
+
+		q.list[q.name] = quantity
+	}
+
+	// Annotations override the default resources one quantity at a time, so a request set by
+	// an annotation can exceed a default limit and the other way round.
+	for i := 0; i < len(quantities); i += 2 {
+		request, hasRequest := requirements.Requests[quantities[i].name]
+		limit, hasLimit := requirements.Limits[quantities[i].name]
+
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("Invalid sidecar resources: %s request %s exceeds limit %s, check annotations %s and %s and the default resources", quantities[i].name, request.String(), limit.String(), whsvr.annotationKey(quantities[i].annotation), whsvr.annotationKey(quantities[i+1].annotation))
+		}
+	}
+
+	if len(requirements.Requests) == 0 {
+		requirements.Requests = nil
+	}
+
+	if len(requirements.Limits) == 0 {
+		requirements.Limits = nil
+	}
+
+	if requirements.Requests == nil && requirements.Limits == nil {
+		return nil, nil
+	}
+
+	return requirements, nil
+}
+
+// getAnnotationEnv returns the sidecar environment variables mapped from the pod's annotations
+// by Config.AnnotationEnv, such as a team for cost allocation, sorted by name.
+func (whsvr *WebhookServer) getAnnotationEnv(podMetadata *metav1.ObjectMeta) []corev1.EnvVar {
+	var env []corev1.EnvVar
+
+	for annotation, name := range whsvr.config.AnnotationEnv {
+		if value, ok := podMetadata.GetAnnotations()[annotation]; ok {
+			env = append(env, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Name < env[j].Name
+	})
+
+	return env
+}
+
+// getGOMAXPROCS returns the GOMAXPROCS matching the sidecar's CPU limit, rounded down to
+// whole cores but at least 1, so the proxy does not schedule more threads than its CFS
+// quota allows. It returns false if the sidecar has no CPU limit.
+func getGOMAXPROCS(resources corev1.ResourceRequirements) (int, bool) {
+	limit, ok := resources.Limits[corev1.ResourceCPU]
+
+	if !ok || limit.IsZero() {
+		return 0, false
+	}
+
+	gomaxprocs := int(limit.MilliValue() / 1000)
+
+	if gomaxprocs < 1 {
+		gomaxprocs = 1
+	}
+
+	return gomaxprocs, true
+}
+
+// getPortName returns the name of the sidecar's container port, so that Services and
+// ServiceMonitors can reference it.
+func (whsvr *WebhookServer) getPortName(podMetadata *metav1.ObjectMeta) (string, error) {
+	portName := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationPortNameKey), signingProxyWebhookPortDefaultName)
+
+	if errs := validation.IsValidPortName(portName); len(errs) > 0 {
+		return "", fmt.Errorf("Invalid port name %q in annotation %s: %s", portName, whsvr.annotationKey(signingProxyWebhookAnnotationPortNameKey), strings.Join(errs, ", "))
+	}
+
+	return portName, nil
+}
+
+// getProxyLogLevelArgs returns the proxy flags for the log level of the proxy-log-level
+// annotation, none if it is unset or info.
+func (whsvr *WebhookServer) getProxyLogLevelArgs(podMetadata *metav1.ObjectMeta) ([]string, error) {
+	logLevel := strings.ToLower(resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationProxyLogLevelKey)))
+
+	if logLevel == "" {
+		return nil, nil
+	}
+
+	args, ok := proxyLogLevelArgs[logLevel]
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid log level %q in annotation %s: expected info, debug or trace", logLevel, whsvr.annotationKey(signingProxyWebhookAnnotationProxyLogLevelKey))
+	}
+
+	return args, nil
+}
+
+// getNodeAffinity returns the node label requirements of the node-affinity annotation, such
+// as a nodegroup with VPC endpoint access the proxy depends on, or nil if it is not set.
+func (whsvr *WebhookServer) getNodeAffinity(podMetadata *metav1.ObjectMeta) ([]corev1.NodeSelectorRequirement, error) {
+	value := strings.TrimSpace(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationNodeAffinityKey))
+
+	if value == "" {
+		return nil, nil
+	}
+
+	requirements, err := parseNodeAffinity(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid node affinity %q in annotation %s: %v", value, whsvr.annotationKey(signingProxyWebhookAnnotationNodeAffinityKey), err)
+	}
+
+	return requirements, nil
+}
+
+// getTransportArgs returns the proxy flags tuning its connections from the idle timeout and
+// transport annotations, defaulted by the pod's profile, none if they are unset.
+func (whsvr *WebhookServer) getTransportArgs(podMetadata *metav1.ObjectMeta) ([]string, error) {
+	profile, err := whsvr.getProfile(podMetadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := profile.annotationValues()
+
+	var args []string
+
+	// The idle timeout of the proxy's own connections is lowered below the idle timeout of a
+	// load balancer in front of it, so that the load balancer does not reuse closed connections.
+	if value := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationIdleTimeoutKey), defaults[signingProxyWebhookAnnotationIdleTimeoutKey]); value != "" {
+		timeout, err := time.ParseDuration(value)
+
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("Invalid idle connection timeout %q in annotation %s: must be a non-negative duration such as 60s", value, whsvr.annotationKey(signingProxyWebhookAnnotationIdleTimeoutKey))
+		}
+
+		args = append(args, "--idle-connection-timeout", timeout.String())
+	}
+
+	if value := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationIdleConnTimeoutKey), defaults[signingProxyWebhookAnnotationIdleConnTimeoutKey]); value != "" {
+		timeout, err := time.ParseDuration(value)
+
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("Invalid idle connection timeout %q in annotation %s: must be a non-negative duration such as 90s", value, whsvr.annotationKey(signingProxyWebhookAnnotationIdleConnTimeoutKey))
+		}
+
+		args = append(args, "--transport-idle-conn-timeout", timeout.String())
+	}
+
+	if value := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationMaxIdleConnsKey), defaults[signingProxyWebhookAnnotationMaxIdleConnsKey]); value != "" {
+		maxIdleConns, err := strconv.Atoi(value)
+
+		if err != nil || maxIdleConns < 0 {
+			return nil, fmt.Errorf("Invalid maximum idle connections %q in annotation %s: must be a non-negative integer", value, whsvr.annotationKey(signingProxyWebhookAnnotationMaxIdleConnsKey))
+		}
+
+		args = append(args, "--transport-max-idle-conns", strconv.Itoa(maxIdleConns))
+	}
+
+	return args, nil
+}
+
+// getEnvFromSecret returns the name of a Secret whose keys are exposed to the sidecar
+// as environment variables.
+func (whsvr *WebhookServer) getEnvFromSecret(podMetadata *metav1.ObjectMeta) (string, error) {
+	secret := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationEnvFromSecretKey))
+
+	if secret == "" {
+		return "", nil
+	}
+
+	if errs := validation.IsDNS1123Subdomain(secret); len(errs) > 0 {
+		return "", fmt.Errorf("Invalid Secret name %q in annotation %s: %s", secret, whsvr.annotationKey(signingProxyWebhookAnnotationEnvFromSecretKey), strings.Join(errs, ", "))
+	}
+
+	return secret, nil
+}
+
+// getProxyEnv returns the forward proxy environment variables of the sidecar, read from the
+// proxy annotations or, if InheritProxyEnv is set, inherited from the controller's environment.
+// Annotations take precedence over the inherited values.
+func (whsvr *WebhookServer) getProxyEnv(podMetadata *metav1.ObjectMeta) ([]corev1.EnvVar, error) {
+	variables := []struct {
+		name       string
+		annotation string
+		isURL      bool
+	}{
+		{"HTTP_PROXY", signingProxyWebhookAnnotationHTTPProxyKey, true},
+		{"HTTPS_PROXY", signingProxyWebhookAnnotationHTTPSProxyKey, true},
+		{"NO_PROXY", signingProxyWebhookAnnotationNoProxyKey, false},
+	}
+
+	var env []corev1.EnvVar
+
+	for _, variable := range variables {
+		source := whsvr.annotationKey(variable.annotation)
+		value := resolve(whsvr.annotation(podMetadata, variable.annotation))
+
+		if value == "" && whsvr.config.InheritProxyEnv {
+			source = "controller environment variable " + variable.name
+			value = strings.TrimSpace(os.Getenv(variable.name))
+		}
+
+		if value == "" {
+			continue
+		}
+
+		if variable.isURL {
+			if proxyURL, err := url.Parse(value); err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+				return nil, fmt.Errorf("Invalid proxy URL %q in %s: must be an absolute URL such as http://proxy.example.com:3128", value, source)
+			}
+		}
+
+		env = append(env, corev1.EnvVar{Name: variable.name, Value: value})
+	}
+
+	return env, nil
+}
+
+// getStartupProbe returns a startup probe for the sidecar when probes are enabled, so that
+// slow credential bootstrap does not cause restarts. It returns nil when probes are disabled.
+func (whsvr *WebhookServer) getStartupProbe(podMetadata *metav1.ObjectMeta) (*corev1.Probe, error) {
+	if probes, _ := strconv.ParseBool(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationProbesKey)); !probes {
+		return nil, nil
+	}
+
+	failureThreshold := int32(signingProxyWebhookStartupProbeDefaultThreshold)
+
+	if value := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationStartupThresholdKey)); value != "" {
+		threshold, err := strconv.ParseInt(value, 10, 32)
+
+		if err != nil || threshold < 1 {
+			return nil, fmt.Errorf("Invalid failure threshold %q in annotation %s: must be a positive integer", value, whsvr.annotationKey(signingProxyWebhookAnnotationStartupThresholdKey))
+		}
+
+		failureThreshold = int32(threshold)
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(8005)},
+		},
+		PeriodSeconds:    1,
+		FailureThreshold: failureThreshold,
+	}, nil
+}
+
+// getPreStopHook returns an exec preStop hook for the sidecar when enabled, delaying its
+// shutdown until the application has stopped sending requests. This is a stopgap for
+// clusters without native sidecar containers. It returns nil when the hook is disabled.
+func (whsvr *WebhookServer) getPreStopHook(podMetadata *metav1.ObjectMeta) (*corev1.LifecycleHandler, error) {
+	if preStop, _ := strconv.ParseBool(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationPreStopKey)); !preStop {
+		return nil, nil
+	}
+
+	command := preStopDefaultCommand
+
+	if value := resolve(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationPreStopCommandKey)); value != "" {
+		var err error
+
+		if command, err = parseCommand(value, whsvr.annotationKey(signingProxyWebhookAnnotationPreStopCommandKey)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &corev1.LifecycleHandler{
+		Exec: &corev1.ExecAction{Command: command},
+	}, nil
+}
+
+// getCommand returns the entrypoint override of the sidecar, or nil to use the image's
+// entrypoint. The annotation must not be empty when it is set.
+func (whsvr *WebhookServer) getCommand(podMetadata *metav1.ObjectMeta) ([]string, error) {
+	key := whsvr.annotationKey(signingProxyWebhookAnnotationCommandKey)
+	value, ok := podMetadata.GetAnnotations()[key]
+
+	if !ok {
+		return nil, nil
+	}
+
+	return parseCommand(strings.TrimSpace(value), key)
+}
+
+// parseCommand parses a command given as a JSON array of strings in the annotation key.
+func parseCommand(value string, key string) ([]string, error) {
+	var command []string
+
+	if err := json.Unmarshal([]byte(value), &command); err != nil || len(command) == 0 || strings.TrimSpace(command[0]) == "" {
+		return nil, fmt.Errorf("Invalid command %q in annotation %s: must be a non-empty JSON array of strings", value, key)
+	}
+
+	return command, nil
+}
+
+func (whsvr *WebhookServer) getProxyImage() string {
+	return whsvr.config.proxyImage()
+}
+
+func (whsvr *WebhookServer) getProxyInitImage() string {
+	return resolve(os.Getenv("AWS-SIGV4-PROXY-INIT-IMAGE"), "public.ecr.aws/eks-distro-build-tooling/eks-distro-minimal-base-iptables:latest")
+}
+
+// validateImage checks that a digest in the image reference is a well-formed sha256 digest
+// and, when digests are required, that the image is pinned by digest rather than by tag.
+func validateImage(image string, requireDigest bool) error {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		if !imageDigestPattern.MatchString(image[i+1:]) {
+			return fmt.Errorf("Invalid image %q: digest must be sha256 followed by 64 hex characters", image)
+		}
+
+		return nil
+	}
+
+	if requireDigest {
+		return fmt.Errorf("Image %q is not pinned by digest: use a reference ending in @sha256:<digest>", image)
+	}
+
+	return nil
+}
+
+// getTransparentParameters returns whether outbound traffic should be transparently
+// redirected to the sidecar and the comma separated destination ports to redirect.
+func (whsvr *WebhookServer) getTransparentParameters(podMetadata *metav1.ObjectMeta) (bool, string, error) {
+	transparent, _ := strconv.ParseBool(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationTransparentKey))
+
+	if !transparent {
+		return false, "", nil
+	}
+
+	ports := strings.ReplaceAll(whsvr.annotation(podMetadata, signingProxyWebhookAnnotationTransparentPortsKey), " ", "")
+
+	if ports == "" {
+		ports = signingProxyWebhookTransparentDefaultPorts
+	}
+
+	for _, port := range strings.Split(ports, ",") {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return false, "", fmt.Errorf("Invalid port %q in annotation %s", port, whsvr.annotationKey(signingProxyWebhookAnnotationTransparentPortsKey))
+		}
+	}
+
+	return true, ports, nil
+}
+
+// newTransparentInitContainer builds an init container that redirects outbound TCP
+// traffic on the given ports to the sidecar. Traffic from the sidecar itself is
+// excluded by its UID so the signed upstream requests are not looped back.
+func newTransparentInitContainer(image string, ports string) corev1.Container {
+	runAsUser := int64(0)
+	runAsNonRoot := false
+
+	return corev1.Container{
+		Name:            signingProxyWebhookInitContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"iptables", "-t", "nat", "-A", "OUTPUT", "-p", "tcp",
+			"-m", "multiport", "--dports", ports,
+			"-m", "owner", "!", "--uid-owner", strconv.Itoa(signingProxyWebhookTransparentProxyUID),
+			"-j", "REDIRECT", "--to-ports", "8005",
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:    &runAsUser,
+			RunAsNonRoot: &runAsNonRoot,
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+			},
+		},
+	}
+}
+
+// addToList patches items into the list at basePath, such as /spec/containers or
+// /spec/volumes. The first item of an empty list is added with the list itself, since a
+// JSON Patch cannot append to a list that does not exist.
+func addToList[T any](target, items []T, basePath string) (patch []PatchOperation) {
+	first := len(target) == 0
+
+	var value interface{}
+
+	for _, item := range items {
+		value = item
+		path := basePath
+
+		if first {
+			first = false
+			value = []T{item}
+		} else {
+			path += "/-"
+		}
+
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  path,
+			Value: value,
+		})
+	}
+
+	return patch
+}
+
+// prependContainers inserts containers before the existing ones in target, in order. The first
+// container of an empty list is added with the list itself, as in addToList.
+func prependContainers(target, containers []corev1.Container, basePath string) (patch []PatchOperation) {
+	if len(target) == 0 {
+		return addToList(target, containers, basePath)
+	}
+
+	for i, container := range containers {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("%s/%d", basePath, i),
+			Value: container,
+		})
+	}
+
+	return patch
+}
+
+// addImagePullSecrets patches in the secrets that target does not already reference.
+func addImagePullSecrets(target, secrets []corev1.LocalObjectReference, basePath string) []PatchOperation {
+	var missing []corev1.LocalObjectReference
+	for _, secret := range secrets {
+		if !containsImagePullSecret(target, secret.Name) {
+			missing = append(missing, secret)
+		}
+	}
+
+	return addToList(target, missing, basePath)
+}
+
+func containsImagePullSecret(target []corev1.LocalObjectReference, name string) bool {
+	for _, secret := range target {
+		if secret.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateMetadata patches values into the string map at basePath, which is one of
+// /metadata/annotations or /metadata/labels.
+func updateMetadata(target map[string]string, values map[string]string, basePath string) (patch []PatchOperation) {
+	for key, value := range values {
+		op := "replace"
+		if target == nil || target[key] == "" {
+			op = "add"
+		}
+		patch = append(patch, PatchOperation{
+			Op:    op,
+			Path:  basePath + "/" + escapeJSONPointer(key),
+			Value: value,
+		})
+	}
+
+	return patch
+}
+
+// escapeJSONPointer escapes a JSON Pointer reference token per RFC 6901. "~" must be
+// escaped before "/" so that the "~" introduced by "~1" is not escaped again.
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
 }
@@ -17,13 +17,105 @@ package controller
 
 import (
 	"aws-signingproxy-admissioncontroller/controller/mocks"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
+func newNamespaceClient(labels map[string]string) *mocks.KubernetesNamespaceClient {
+	namespaceClient := &mocks.KubernetesNamespaceClient{}
+
+	namespaceClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+		&corev1.Namespace{TypeMeta: metav1.TypeMeta{}, ObjectMeta: metav1.ObjectMeta{
+			Labels: labels,
+		}}, nil)
+
+	return namespaceClient
+}
+
+func newAdmissionReview(t *testing.T, pod *corev1.Pod) *v1beta1.AdmissionReview {
+	raw, err := json.Marshal(pod)
+	assert.Nil(t, err, "Should marshal pod")
+
+	return &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "test-uid",
+			Namespace: "testNamespace",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+// findPatchValue decodes the value of the first patch operation at the given path into out.
+func findPatchValue(t *testing.T, patch []PatchOperation, path string, out interface{}) bool {
+	for _, operation := range patch {
+		if operation.Path != path {
+			continue
+		}
+
+		raw, err := json.Marshal(operation.Value)
+		assert.Nil(t, err, "Should marshal patch value")
+		assert.Nil(t, json.Unmarshal(raw, out), "Should unmarshal patch value")
+
+		return true
+	}
+
+	return false
+}
+
+// argValue returns the value following flag in args, or an empty string if flag is absent.
+func argValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
+
+func decodePatch(t *testing.T, response *v1beta1.AdmissionResponse) []PatchOperation {
+	var patch []PatchOperation
+
+	if len(response.Patch) == 0 {
+		return patch
+	}
+
+	assert.Nil(t, json.Unmarshal(response.Patch, &patch), "Should unmarshal patch")
+
+	return patch
+}
+
 func TestWebhookServer_describeNamespace(t *testing.T) {
 	mockKubernetesClient := &mocks.KubernetesNamespaceClient{}
 	labels := map[string]string{"Key": "Value"}
@@ -38,7 +130,7 @@ func TestWebhookServer_describeNamespace(t *testing.T) {
 			server:          nil,
 			namespaceClient: mockKubernetesClient,
 		}
-		l, err := whsvr.describeNamespace(nil, "testNamespace")
+		l, err := whsvr.describeNamespace(context.Background(), "testNamespace")
 		assert.Nil(t, err, "Should succeed")
 		assert.Equal(t, l, labels, "Labels should match")
 	})
@@ -50,7 +142,7 @@ func TestWebhookServer_describeNamespace(t *testing.T) {
 			server:          nil,
 			namespaceClient: mockKubernetesClient,
 		}
-		l, err := whsvr.describeNamespace(nil, "testNamespace")
+		l, err := whsvr.describeNamespace(context.Background(), "testNamespace")
 		assert.Nil(t, err, "Should succeed")
 		assert.NotEqual(t, l, wrongLabels, "Labels should not match")
 	})
@@ -68,12 +160,97 @@ func TestWebhookServer_describeNamespace(t *testing.T) {
 			server:          nil,
 			namespaceClient: emptyKubernetesClient,
 		}
-		l, err := whsvr.describeNamespace(nil, "testNamespace")
+		l, err := whsvr.describeNamespace(context.Background(), "testNamespace")
 		assert.Nil(t, err, "Should succeed")
 		assert.Empty(t, l, "Labels should be empty")
 	})
 }
 
+func TestWebhookServer_describeNamespaceRetry(t *testing.T) {
+	labels := map[string]string{"Key": "Value"}
+
+	t.Run("TestTransientErrorsRetried", func(t *testing.T) {
+		flakyKubernetesClient := &mocks.KubernetesNamespaceClient{}
+
+		flakyKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+			nil, k8serrors.NewServiceUnavailable("API server unavailable")).Twice()
+		flakyKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Labels: labels}}, nil).Once()
+
+		whsvr := &WebhookServer{
+			namespaceClient: flakyKubernetesClient,
+			config:          Config{NamespaceRetryAttempts: 3, NamespaceRetryBaseDelay: metav1.Duration{Duration: time.Millisecond}},
+		}
+
+		l, err := whsvr.describeNamespace(context.Background(), "testNamespace")
+		assert.Nil(t, err, "Should succeed after retrying")
+		assert.Equal(t, labels, l, "Labels should match")
+		flakyKubernetesClient.AssertNumberOfCalls(t, "Get", 3)
+	})
+
+	t.Run("TestAttemptsExhausted", func(t *testing.T) {
+		failingKubernetesClient := &mocks.KubernetesNamespaceClient{}
+
+		failingKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+			nil, k8serrors.NewServiceUnavailable("API server unavailable"))
+
+		whsvr := &WebhookServer{
+			namespaceClient: failingKubernetesClient,
+			config:          Config{NamespaceRetryAttempts: 2, NamespaceRetryBaseDelay: metav1.Duration{Duration: time.Millisecond}},
+		}
+
+		_, err := whsvr.describeNamespace(context.Background(), "testNamespace")
+		assert.NotNil(t, err, "Should fail once the attempts are exhausted")
+		failingKubernetesClient.AssertNumberOfCalls(t, "Get", 2)
+	})
+
+	t.Run("TestNotFoundNotRetried", func(t *testing.T) {
+		missingKubernetesClient := &mocks.KubernetesNamespaceClient{}
+
+		missingKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+			nil, k8serrors.NewNotFound(corev1.Resource("namespaces"), "testNamespace"))
+
+		whsvr := &WebhookServer{
+			namespaceClient: missingKubernetesClient,
+			config:          Config{NamespaceRetryAttempts: 3, NamespaceRetryBaseDelay: metav1.Duration{Duration: time.Millisecond}},
+		}
+
+		_, err := whsvr.describeNamespace(context.Background(), "testNamespace")
+		assert.NotNil(t, err, "Should fail")
+		missingKubernetesClient.AssertNumberOfCalls(t, "Get", 1)
+	})
+}
+
+func TestWebhookServer_describeNamespaceTimeout(t *testing.T) {
+	slowKubernetesClient := &mocks.KubernetesNamespaceClient{}
+
+	slowKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(
+		nil, func(ctx context.Context, name string, opts metav1.GetOptions) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil
+			}
+		})
+
+	whsvr := &WebhookServer{
+		namespaceClient: slowKubernetesClient,
+		config: Config{
+			NamespaceRetryAttempts:  3,
+			NamespaceRetryBaseDelay: metav1.Duration{Duration: time.Millisecond},
+			NamespaceGetTimeout:     metav1.Duration{Duration: 50 * time.Millisecond},
+		},
+	}
+
+	start := time.Now()
+	_, err := whsvr.describeNamespace(context.Background(), "testNamespace")
+
+	assert.NotNil(t, err, "Should fail once the timeout fires")
+	assert.Less(t, time.Since(start), time.Second, "Should not wait for the slow client")
+	slowKubernetesClient.AssertNumberOfCalls(t, "Get", 1)
+}
+
 func TestWebhookServer_shouldMutate(t *testing.T) {
 	var positiveTestCases = []struct {
 		name          string
@@ -302,7 +479,8 @@ func TestWebhookServer_getUpstreamEndpointParameters(t *testing.T) {
 				namespaceClient: nil,
 			}
 
-			a, b, c, d, e := whsvr.getUpstreamEndpointParameters(tc.labels, tc.podObjectMeta)
+			a, b, c, d, e, err := whsvr.getUpstreamEndpointParameters(tc.labels, tc.podObjectMeta)
+			assert.Nil(t, err, "Should succeed")
 			assert.Equal(t, tc.expected[0], a, tc.errorMessages[0])
 			assert.Equal(t, tc.expected[1], b, tc.errorMessages[1])
 			assert.Equal(t, tc.expected[2], c, tc.errorMessages[2])
@@ -360,8 +538,5823 @@ func TestWebhookServer_getRoleArn(t *testing.T) {
 				namespaceClient: nil,
 			}
 
-			r := whsvr.getRoleArn(tc.labels, tc.podObjectMeta)
+			r := whsvr.getRoleArn("testNamespace", tc.labels, tc.podObjectMeta)
+			assert.Equal(t, tc.expected, r, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_getRoleArnNamespaceDefault(t *testing.T) {
+	config := Config{NamespaceRoleArns: map[string]string{
+		"dev":  "arn:aws:iam::123456789:role/dev",
+		"prod": "arn:aws:iam::123456789:role/prod",
+	}}
+
+	var testCases = []struct {
+		name          string
+		namespace     string
+		podObjectMeta *metav1.ObjectMeta
+		labels        map[string]string
+		expected      string
+		errorMessage  string
+	}{
+		{
+			name:          "TestNamespaceDefault",
+			namespace:     "prod",
+			podObjectMeta: &metav1.ObjectMeta{},
+			labels:        map[string]string{},
+			expected:      "arn:aws:iam::123456789:role/prod",
+			errorMessage:  "Should fall back to the default role of the namespace",
+		},
+		{
+			name:      "TestAnnotationOverridesNamespaceDefault",
+			namespace: "prod",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationRoleArnKey: "arn:aws:iam::123456789:annotation/assume-role-test",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelRoleArnKey: "arn:aws:iam::123456789:label/assume-role-test",
+			},
+			expected:     "arn:aws:iam::123456789:annotation/assume-role-test",
+			errorMessage: "Should prefer the annotation over the label and the namespace default",
+		},
+		{
+			name:          "TestLabelOverridesNamespaceDefault",
+			namespace:     "dev",
+			podObjectMeta: &metav1.ObjectMeta{},
+			labels: map[string]string{
+				signingProxyWebhookLabelRoleArnKey: "arn:aws:iam::123456789:label/assume-role-test",
+			},
+			expected:     "arn:aws:iam::123456789:label/assume-role-test",
+			errorMessage: "Should prefer the label over the namespace default",
+		},
+		{
+			name:          "TestNamespaceWithoutDefault",
+			namespace:     "staging",
+			podObjectMeta: &metav1.ObjectMeta{},
+			labels:        map[string]string{},
+			expected:      "",
+			errorMessage:  "Should return an empty role-arn for namespaces without a default",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+				config:          config,
+			}
+
+			r := whsvr.getRoleArn(tc.namespace, tc.labels, tc.podObjectMeta)
+			assert.Equal(t, tc.expected, r, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_getImagePullSecret(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podObjectMeta *metav1.ObjectMeta
+		labels        map[string]string
+		expected      string
+		errorMessage  string
+	}{
+		{
+			name: "TestSidecarImagePullSecretAnnotationPresent",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationImagePullSecretKey: "annotation-secret",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelImagePullSecretKey: "label-secret",
+			},
+			expected:     "annotation-secret",
+			errorMessage: "Should return image-pull-secret annotation value",
+		},
+		{
+			name: "TestSidecarImagePullSecretLabelPresent",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelImagePullSecretKey: "label-secret",
+			},
+			expected:     "label-secret",
+			errorMessage: "Should return image-pull-secret label value",
+		},
+		{
+			name: "TestSidecarNoImagePullSecretPresent",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{},
+			},
+			labels:       map[string]string{},
+			expected:     "",
+			errorMessage: "Should return empty image-pull-secret since there is no annotation or label",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			r := whsvr.getImagePullSecret(tc.labels, tc.podObjectMeta)
 			assert.Equal(t, tc.expected, r, tc.errorMessage)
 		})
 	}
 }
+
+func TestAddImagePullSecrets(t *testing.T) {
+	secrets := []corev1.LocalObjectReference{{Name: "sidecar-secret"}}
+
+	t.Run("TestAddImagePullSecretsToEmptyList", func(t *testing.T) {
+		patch := addImagePullSecrets(nil, secrets, "/spec/imagePullSecrets")
+		assert.Equal(t, []PatchOperation{{
+			Op:    "add",
+			Path:  "/spec/imagePullSecrets",
+			Value: []corev1.LocalObjectReference{{Name: "sidecar-secret"}},
+		}}, patch, "Should create the imagePullSecrets list")
+	})
+
+	t.Run("TestAddImagePullSecretsToNonEmptyList", func(t *testing.T) {
+		target := []corev1.LocalObjectReference{{Name: "app-secret"}}
+		patch := addImagePullSecrets(target, secrets, "/spec/imagePullSecrets")
+		assert.Equal(t, []PatchOperation{{
+			Op:    "add",
+			Path:  "/spec/imagePullSecrets/-",
+			Value: corev1.LocalObjectReference{Name: "sidecar-secret"},
+		}}, patch, "Should append to the existing imagePullSecrets list")
+	})
+
+	t.Run("TestAddImagePullSecretsAlreadyPresent", func(t *testing.T) {
+		target := []corev1.LocalObjectReference{{Name: "sidecar-secret"}}
+		patch := addImagePullSecrets(target, secrets, "/spec/imagePullSecrets")
+		assert.Empty(t, patch, "Should not add a duplicate imagePullSecret")
+	})
+}
+
+func TestWebhookServer_mutateEvents(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podObjectMeta metav1.ObjectMeta
+		expected      string
+		errorMessage  string
+	}{
+		{
+			name: "TestSidecarInjectedEvent",
+			podObjectMeta: metav1.ObjectMeta{
+				Name: "test-pod",
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				},
+			},
+			expected:     "Normal " + signingProxyWebhookEventReasonInjected,
+			errorMessage: "Should record a SidecarInjected event",
+		},
+		{
+			name: "TestSidecarSkippedEvent",
+			podObjectMeta: metav1.ObjectMeta{
+				Name: "test-pod",
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "false",
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				},
+			},
+			expected:     "Normal " + signingProxyWebhookEventReasonSkipped,
+			errorMessage: "Should record a SidecarSkipped event",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				recorder:        recorder,
+			}
+
+			_, err := whsvr.mutate(context.Background(), newAdmissionReview(t, &corev1.Pod{ObjectMeta: tc.podObjectMeta}))
+			assert.Nil(t, err, "Should succeed")
+
+			assert.Len(t, recorder.Events, 1, "Should record exactly one event")
+			event := <-recorder.Events
+			assert.Contains(t, event, tc.expected, tc.errorMessage)
+			assert.Contains(t, event, "test-pod", "Event should reference the pod")
+		})
+	}
+}
+
+// countingReader produces an endless stream of bytes and counts how many were read.
+type countingReader struct {
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestWebhookServer_HandlerMaxRequestBytes(t *testing.T) {
+	var maxRequestBytes int64 = 1024
+
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: nil,
+		config:          Config{MaxRequestBytes: maxRequestBytes},
+	}
+
+	body := &countingReader{}
+	request := httptest.NewRequest(http.MethodPost, "/mutate", body)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+
+	whsvr.Handler(recorder, request)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code, "Should reject oversized body")
+	assert.LessOrEqual(t, body.read, 2*maxRequestBytes, "Should stop reading the body once the limit is exceeded")
+}
+
+func TestWebhookServer_HandlerErrorResponse(t *testing.T) {
+	errorKubernetesClient := &mocks.KubernetesNamespaceClient{}
+	errorKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("connection refused"))
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	malformed, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind":       "AdmissionReview",
+		"request": map[string]interface{}{
+			"uid":       "test-uid",
+			"namespace": "testNamespace",
+			"operation": "CREATE",
+			"object":    map[string]interface{}{"spec": "not a pod spec"},
+		},
+	})
+	assert.Nil(t, err, "Should marshal AdmissionReview")
+
+	var testCases = []struct {
+		name            string
+		namespaceClient KubernetesNamespaceClient
+		body            []byte
+		code            int32
+		errorMessage    string
+	}{
+		{
+			name:            "TestInternalError",
+			namespaceClient: errorKubernetesClient,
+			body:            newAdmissionReviewBody(t, "admission.k8s.io/v1", pod),
+			code:            http.StatusInternalServerError,
+			errorMessage:    "Should return the InternalError status of a failed namespace lookup",
+		},
+		{
+			name:            "TestMalformedPod",
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			body:            malformed,
+			code:            http.StatusBadRequest,
+			errorMessage:    "Should return the BadRequest status of a malformed pod",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: tc.namespaceClient,
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(tc.body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+
+			whsvr.Handler(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code, "Should answer with an AdmissionReview")
+
+			var review admissionv1.AdmissionReview
+			assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review), "Should decode the AdmissionReview")
+			assert.Equal(t, "test-uid", string(review.Response.UID), "Should answer the request")
+			assert.False(t, review.Response.Allowed, "Should not allow the pod")
+			assert.Equal(t, tc.code, review.Response.Result.Code, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_HandlerContentType(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		contentType  string
+		expected     int
+		errorMessage string
+	}{
+		{name: "TestJSON", contentType: "application/json", expected: http.StatusOK, errorMessage: "Should accept application/json"},
+		{name: "TestJSONCharset", contentType: "application/json; charset=utf-8", expected: http.StatusOK, errorMessage: "Should accept application/json with a charset"},
+		{name: "TestJSONCase", contentType: "Application/JSON", expected: http.StatusOK, errorMessage: "Should match the media type case-insensitively"},
+		{name: "TestYAML", contentType: "application/yaml", expected: http.StatusUnsupportedMediaType, errorMessage: "Should reject other media types"},
+		{name: "TestMalformed", contentType: "application/json; charset", expected: http.StatusUnsupportedMediaType, errorMessage: "Should reject a malformed Content-Type"},
+		{name: "TestMissing", contentType: "", expected: http.StatusUnsupportedMediaType, errorMessage: "Should reject a missing Content-Type"},
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	body := newAdmissionReviewBody(t, "admission.k8s.io/v1", pod)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+			request.Header.Set("Content-Type", tc.contentType)
+			recorder := httptest.NewRecorder()
+
+			whsvr.Handler(recorder, request)
+
+			assert.Equal(t, tc.expected, recorder.Code, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateCABundle(t *testing.T) {
+	annotations := map[string]string{
+		signingProxyWebhookAnnotationInjectKey: "true",
+		signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+	}
+
+	t.Run("TestCABundleVolumeMountAndArg", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		podAnnotations := map[string]string{
+			signingProxyWebhookAnnotationCABundleConfigMapKey: "private-ca",
+			signingProxyWebhookAnnotationCABundlePathKey:      "/etc/private-ca/bundle.pem",
+		}
+		for k, v := range annotations {
+			podAnnotations[k] = v
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		patch := decodePatch(t, response)
+
+		var volumes []corev1.Volume
+		assert.True(t, findPatchValue(t, patch, "/spec/volumes", &volumes), "Should create the volumes list")
+		assert.Len(t, volumes, 1)
+		assert.Equal(t, signingProxyWebhookCABundleVolumeName, volumes[0].Name)
+		assert.Equal(t, "private-ca", volumes[0].ConfigMap.Name, "Volume should reference the ConfigMap")
+		assert.Equal(t, "bundle.pem", volumes[0].ConfigMap.Items[0].Key)
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Equal(t, []corev1.VolumeMount{{
+			Name:      signingProxyWebhookCABundleVolumeName,
+			MountPath: "/etc/private-ca/bundle.pem",
+			SubPath:   "bundle.pem",
+			ReadOnly:  true,
+		}}, container.VolumeMounts, "Sidecar should mount only the CA bundle file")
+		assert.Equal(t, "/etc/private-ca/bundle.pem", argValue(container.Args, "--ca-bundle"))
+	})
+
+	t.Run("TestCABundleAppendsToExistingVolumes", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		podAnnotations := map[string]string{
+			signingProxyWebhookAnnotationCABundleConfigMapKey: "private-ca",
+		}
+		for k, v := range annotations {
+			podAnnotations[k] = v
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+				Volumes:    []corev1.Volume{{Name: "data"}},
+			},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		patch := decodePatch(t, response)
+
+		var volume corev1.Volume
+		assert.True(t, findPatchValue(t, patch, "/spec/volumes/-", &volume), "Should append to the volumes list")
+		assert.Equal(t, signingProxyWebhookCABundleVolumeName, volume.Name)
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Equal(t, signingProxyWebhookCABundleDefaultPath, container.VolumeMounts[0].MountPath, "Should mount at the default path")
+		assert.Equal(t, signingProxyWebhookCABundleDefaultPath, argValue(container.Args, "--ca-bundle"))
+	})
+
+	t.Run("TestCABundleKey", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		podAnnotations := map[string]string{
+			signingProxyWebhookAnnotationCABundleConfigMapKey: "private-ca",
+			signingProxyWebhookAnnotationCABundlePathKey:      "/etc/ssl/certs/private-ca.pem",
+			signingProxyWebhookAnnotationCABundleKeyKey:       "ca.crt",
+		}
+		for k, v := range annotations {
+			podAnnotations[k] = v
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		patch := decodePatch(t, response)
+
+		var volumes []corev1.Volume
+		assert.True(t, findPatchValue(t, patch, "/spec/volumes", &volumes), "Should create the volumes list")
+		assert.Equal(t, []corev1.KeyToPath{{Key: "ca.crt", Path: "private-ca.pem"}}, volumes[0].ConfigMap.Items, "Should project the configured key to the file name")
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Equal(t, "/etc/ssl/certs/private-ca.pem", container.VolumeMounts[0].MountPath, "Should mount the file without hiding its directory")
+		assert.Equal(t, "private-ca.pem", container.VolumeMounts[0].SubPath)
+	})
+
+	t.Run("TestNoCABundle", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		patch := decodePatch(t, response)
+
+		var volumes []corev1.Volume
+		assert.False(t, findPatchValue(t, patch, "/spec/volumes", &volumes), "Should not add volumes")
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Empty(t, container.VolumeMounts, "Sidecar should not mount anything")
+		assert.NotContains(t, container.Args, "--ca-bundle")
+	})
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersInvalidHost(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		host         string
+		errorMessage string
+	}{
+		{
+			name:         "TestSidecarSingleLabelHost",
+			host:         "localhost",
+			errorMessage: "Should reject a host without dots",
+		},
+		{
+			name:         "TestSidecarTwoLabelHost",
+			host:         "amazonaws.com",
+			errorMessage: "Should reject a host without a region label",
+		},
+		{
+			name:         "TestSidecarEmptyHost",
+			host:         " ",
+			errorMessage: "Should reject an empty host",
+		},
+		{
+			name:         "TestSidecarTrailingDotSingleLabelHost",
+			host:         "localhost.",
+			errorMessage: "Should reject a single label host with a trailing dot",
+		},
+		{
+			name:         "TestSidecarEmptyLabelHost",
+			host:         "aps..amazonaws.com",
+			errorMessage: "Should reject a host with an empty label",
+		},
+		{
+			name:         "TestSidecarZeroPort",
+			host:         "aps.us-west-2.amazonaws.com:0",
+			errorMessage: "Should reject a port out of range",
+		},
+		{
+			name:         "TestSidecarNonNumericPort",
+			host:         "aps.us-west-2.amazonaws.com:https",
+			errorMessage: "Should reject a named port",
+		},
+		{
+			name:         "TestSidecarEmptyPort",
+			host:         "aps.us-west-2.amazonaws.com:",
+			errorMessage: "Should reject an empty port",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			podObjectMeta := &metav1.ObjectMeta{
+				Annotations: map[string]string{signingProxyWebhookAnnotationHostKey: tc.host},
+			}
+
+			_, _, _, _, _, err := whsvr.getUpstreamEndpointParameters(map[string]string{}, podObjectMeta)
+			assert.NotNil(t, err, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestSidecarTrailingDotHost", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: nil,
+		}
+
+		podObjectMeta := &metav1.ObjectMeta{
+			Annotations: map[string]string{signingProxyWebhookAnnotationHostKey: "aps.us-west-2.amazonaws.com."},
+		}
+
+		host, name, region, _, _, err := whsvr.getUpstreamEndpointParameters(map[string]string{}, podObjectMeta)
+		assert.Nil(t, err, "Should accept a fully qualified host")
+		assert.Equal(t, "aps.us-west-2.amazonaws.com", host, "Should strip the trailing dot")
+		assert.Equal(t, "aps", name, "Should return name from host")
+		assert.Equal(t, "us-west-2", region, "Should return region from host")
+	})
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersPartitions(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		host           string
+		valid          bool
+		expectedName   string
+		expectedRegion string
+		errorMessage   string
+	}{
+		{name: "TestChina", host: "aps-workspaces.cn-north-1.amazonaws.com.cn", valid: true, expectedName: "aps-workspaces", expectedRegion: "cn-north-1", errorMessage: "Should parse a China endpoint"},
+		{name: "TestChinaDualStack", host: "s3.dualstack.cn-northwest-1.amazonaws.com.cn", valid: true, expectedName: "s3", expectedRegion: "cn-northwest-1", errorMessage: "Should find the region after other labels"},
+		{name: "TestGovCloud", host: "logs.us-gov-west-1.amazonaws.com", valid: true, expectedName: "logs", expectedRegion: "us-gov-west-1", errorMessage: "Should parse a GovCloud endpoint"},
+		{name: "TestGovCloudDomain", host: "es.us-gov-west-1.amazonaws-us-gov.com", valid: true, expectedName: "es", expectedRegion: "us-gov-west-1", errorMessage: "Should parse a GovCloud endpoint under its own domain"},
+		{name: "TestCommercialDualStack", host: "s3.dualstack.us-west-2.amazonaws.com", valid: true, expectedName: "s3", expectedRegion: "us-west-2", errorMessage: "Should find the region after other labels"},
+		{name: "TestChinaRegionCommercialDomain", host: "s3.cn-north-1.amazonaws.com", valid: false, errorMessage: "Should reject a China region outside the China domain"},
+		{name: "TestCommercialRegionChinaDomain", host: "s3.us-west-2.amazonaws.com.cn", valid: false, errorMessage: "Should reject a commercial region in the China domain"},
+		{name: "TestCommercialRegionGovCloudDomain", host: "s3.us-west-2.amazonaws-us-gov.com", valid: false, errorMessage: "Should reject a commercial region in the GovCloud domain"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			podObjectMeta := &metav1.ObjectMeta{
+				Annotations: map[string]string{signingProxyWebhookAnnotationHostKey: tc.host},
+			}
+
+			host, name, region, _, _, err := whsvr.getUpstreamEndpointParameters(map[string]string{}, podObjectMeta)
+
+			if !tc.valid {
+				assert.NotNil(t, err, tc.errorMessage)
+				return
+			}
+
+			assert.Nil(t, err, tc.errorMessage)
+			assert.Equal(t, tc.host, host, tc.errorMessage)
+			assert.Equal(t, tc.expectedName, name, tc.errorMessage)
+			assert.Equal(t, tc.expectedRegion, region, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateInvalidHost(t *testing.T) {
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "localhost",
+			},
+		},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should not return an error")
+	assert.False(t, response.Allowed, "Should deny the pod")
+	assert.Contains(t, response.Result.Message, "localhost", "Should explain the denial")
+	assert.Empty(t, response.Patch, "Should not patch the pod")
+}
+
+func TestWebhookServer_getRoleAssumeParameters(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podObjectMeta *metav1.ObjectMeta
+		labels        map[string]string
+		expected      []string
+		errorMessages []string
+	}{
+		{
+			name: "TestSidecarRoleAssumeAnnotationsPresent",
+			podObjectMeta: &metav1.ObjectMeta{
+				GenerateName: "sleep-5d8f9c7b6-",
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationRoleExternalIdKey:  "annotation-external-id",
+					signingProxyWebhookAnnotationRoleSessionNameKey: "annotation-session",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelRoleExternalIdKey:  "label-external-id",
+				signingProxyWebhookLabelRoleSessionNameKey: "label-session",
+			},
+			expected:      []string{"annotation-external-id", "annotation-session"},
+			errorMessages: []string{"Should return external id annotation value", "Should return session name annotation value"},
+		},
+		{
+			name: "TestSidecarRoleAssumeLabelsPresent",
+			podObjectMeta: &metav1.ObjectMeta{
+				GenerateName: "sleep-5d8f9c7b6-",
+				Annotations:  map[string]string{},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelRoleExternalIdKey:  "label-external-id",
+				signingProxyWebhookLabelRoleSessionNameKey: "label-session",
+			},
+			expected:      []string{"label-external-id", "label-session"},
+			errorMessages: []string{"Should return external id label value", "Should return session name label value"},
+		},
+		{
+			name: "TestSidecarRoleSessionNameFromGenerateName",
+			podObjectMeta: &metav1.ObjectMeta{
+				GenerateName: "sleep-5d8f9c7b6-",
+				Annotations:  map[string]string{},
+			},
+			labels:        map[string]string{},
+			expected:      []string{"", "sleep-5d8f9c7b6"},
+			errorMessages: []string{"Should return empty external id", "Should derive session name from generateName"},
+		},
+		{
+			name: "TestSidecarRoleSessionNameFromName",
+			podObjectMeta: &metav1.ObjectMeta{
+				Name:        "sleep",
+				Annotations: map[string]string{},
+			},
+			labels:        map[string]string{},
+			expected:      []string{"", "sleep"},
+			errorMessages: []string{"Should return empty external id", "Should derive session name from name"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			externalId, sessionName := whsvr.getRoleAssumeParameters(tc.labels, tc.podObjectMeta)
+			assert.Equal(t, tc.expected[0], externalId, tc.errorMessages[0])
+			assert.Equal(t, tc.expected[1], sessionName, tc.errorMessages[1])
+		})
+	}
+}
+
+func TestWebhookServer_mutateRoleAssumeArgs(t *testing.T) {
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "sleep-5d8f9c7b6-",
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey:         "true",
+				signingProxyWebhookAnnotationHostKey:           "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationRoleArnKey:        "arn:aws:iam::123456789:role/assume-role-test",
+				signingProxyWebhookAnnotationRoleExternalIdKey: "external-id",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should succeed")
+
+	var container corev1.Container
+	assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+	assert.Equal(t, "external-id", argValue(container.Args, "--role-external-id"), "Should pass through the external id")
+	assert.Equal(t, "sleep-5d8f9c7b6", argValue(container.Args, "--role-session-name"), "Should default the session name")
+}
+
+func TestWebhookServer_mutateFailOpen(t *testing.T) {
+	errorKubernetesClient := &mocks.KubernetesNamespaceClient{}
+	errorKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("connection refused"))
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+		},
+	}
+
+	t.Run("TestFailOpen", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: errorKubernetesClient,
+			config:          Config{FailOpen: true},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.True(t, response.Allowed, "Should allow the pod")
+		assert.Empty(t, response.Patch, "Should not patch the pod")
+	})
+
+	t.Run("TestFailClosed", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: errorKubernetesClient,
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.NotNil(t, err, "Should return an error")
+		assert.False(t, response.Allowed, "Should not allow the pod")
+	})
+}
+
+func TestWebhookServer_mutateTransparent(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		transparent  bool
+		ports        string
+		errorMessage string
+	}{
+		{
+			name: "TestTransparentModeEnabled",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationTransparentKey: "true",
+			},
+			transparent:  true,
+			ports:        "80",
+			errorMessage: "Should inject the iptables init container in transparent mode",
+		},
+		{
+			name: "TestTransparentModeCustomPorts",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationTransparentKey:      "true",
+				signingProxyWebhookAnnotationTransparentPortsKey: "80, 8080",
+			},
+			transparent:  true,
+			ports:        "80,8080",
+			errorMessage: "Should redirect the configured ports in transparent mode",
+		},
+		{
+			name:         "TestTransparentModeDisabled",
+			annotations:  map[string]string{},
+			transparent:  false,
+			errorMessage: "Should not inject the iptables init container outside transparent mode",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.annotations {
+				annotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			patch := decodePatch(t, response)
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should add the sidecar")
+
+			var initContainers []corev1.Container
+			found := findPatchValue(t, patch, "/spec/initContainers", &initContainers)
+			assert.Equal(t, tc.transparent, found, tc.errorMessage)
+
+			if !tc.transparent {
+				assert.Nil(t, container.SecurityContext, "Sidecar should keep its default security context")
+				return
+			}
+
+			assert.Len(t, initContainers, 1)
+			assert.Contains(t, initContainers[0].SecurityContext.Capabilities.Add, corev1.Capability("NET_ADMIN"), "Init container should have NET_ADMIN")
+			assert.Equal(t, tc.ports, argValue(initContainers[0].Command, "--dports"), "Should redirect the configured ports")
+			assert.Equal(t, "8005", argValue(initContainers[0].Command, "--to-ports"), "Should redirect to the sidecar")
+			assert.Equal(t, int64(signingProxyWebhookTransparentProxyUID), *container.SecurityContext.RunAsUser, "Sidecar should run as the excluded UID")
+			assert.Nil(t, container.SecurityContext.Capabilities, "Sidecar should not have NET_ADMIN")
+		})
+	}
+
+	t.Run("TestTransparentModeInvalidPorts", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey:           "true",
+				signingProxyWebhookAnnotationHostKey:             "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationTransparentKey:      "true",
+				signingProxyWebhookAnnotationTransparentPortsKey: "http",
+			}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should deny the pod")
+	})
+}
+
+func TestWebhookServer_getResourceRequirements(t *testing.T) {
+	defaultResources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}
+
+	var testCases = []struct {
+		name             string
+		podObjectMeta    *metav1.ObjectMeta
+		defaultResources corev1.ResourceRequirements
+		expected         *corev1.ResourceRequirements
+		errorMessage     string
+	}{
+		{
+			name: "TestSidecarNoResourcesOrDefaults",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{},
+			},
+			expected:     nil,
+			errorMessage: "Should return no resources without annotations or defaults",
+		},
+		{
+			name: "TestSidecarDefaultResources",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{},
+			},
+			defaultResources: defaultResources,
+			expected:         &defaultResources,
+			errorMessage:     "Should return default resources without annotations",
+		},
+		{
+			name: "TestSidecarResourceAnnotationsOverrideDefaults",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationCPURequestKey:  "200m",
+					signingProxyWebhookAnnotationMemoryLimitKey: "256Mi",
+				},
+			},
+			defaultResources: defaultResources,
+			expected: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("200m"),
+					corev1.ResourceMemory: resource.MustParse("64Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+			},
+			errorMessage: "Should override defaults with annotations",
+		},
+		{
+			name: "TestSidecarResourceAnnotationsWithoutDefaults",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationCPULimitKey:      "1",
+					signingProxyWebhookAnnotationMemoryRequestKey: "32Mi",
+				},
+			},
+			expected: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("32Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("1"),
+				},
+			},
+			errorMessage: "Should return annotation resources",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+				config:          Config{DefaultResources: tc.defaultResources},
+			}
+
+			r, err := whsvr.getResourceRequirements(tc.podObjectMeta)
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.expected, r, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestSidecarDefaultResourcesNotModified", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: nil,
+			config:          Config{DefaultResources: *defaultResources.DeepCopy()},
+		}
+
+		_, err := whsvr.getResourceRequirements(&metav1.ObjectMeta{
+			Annotations: map[string]string{signingProxyWebhookAnnotationCPURequestKey: "200m"},
+		})
+		assert.Nil(t, err, "Should succeed")
+		assert.Equal(t, defaultResources, whsvr.config.DefaultResources, "Should not modify the defaults")
+	})
+}
+
+func TestWebhookServer_getResourceRequirementsInvalidQuantity(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		annotation string
+		value      string
+	}{
+		{name: "TestSidecarInvalidCPURequest", annotation: signingProxyWebhookAnnotationCPURequestKey, value: "abc"},
+		{name: "TestSidecarInvalidCPULimit", annotation: signingProxyWebhookAnnotationCPULimitKey, value: "1 core"},
+		{name: "TestSidecarInvalidMemoryRequest", annotation: signingProxyWebhookAnnotationMemoryRequestKey, value: "200mi"},
+		{name: "TestSidecarInvalidMemoryLimit", annotation: signingProxyWebhookAnnotationMemoryLimitKey, value: "128MB"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			podObjectMeta := metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+					tc.annotation:                          tc.value,
+				},
+			}
+
+			r, err := whsvr.getResourceRequirements(&podObjectMeta)
+			assert.Nil(t, r, "Should not return resources")
+			assert.NotNil(t, err, "Should return an error")
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, &corev1.Pod{ObjectMeta: podObjectMeta}))
+			assert.Nil(t, err, "Should not return an error")
+			assert.False(t, response.Allowed, "Should deny the pod")
+			assert.Contains(t, response.Result.Message, tc.annotation, "Should name the offending annotation")
+		})
+	}
+}
+
+func TestWebhookServer_getResourceRequirementsRequestAboveLimit(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		annotations      map[string]string
+		defaultResources corev1.ResourceRequirements
+		errorMessage     string
+	}{
+		{
+			name:         "TestAnnotations",
+			annotations:  map[string]string{signingProxyWebhookAnnotationCPURequestKey: "500m", signingProxyWebhookAnnotationCPULimitKey: "200m"},
+			errorMessage: "Should reject a CPU request above the CPU limit",
+		},
+		{
+			name:        "TestRequestAboveDefaultLimit",
+			annotations: map[string]string{signingProxyWebhookAnnotationMemoryRequestKey: "512Mi"},
+			defaultResources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			},
+			errorMessage: "Should reject a memory request above the default memory limit",
+		},
+		{
+			name:        "TestLimitBelowDefaultRequest",
+			annotations: map[string]string{signingProxyWebhookAnnotationCPULimitKey: "50m"},
+			defaultResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			},
+			errorMessage: "Should reject a CPU limit below the default CPU request",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{DefaultResources: tc.defaultResources},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			podObjectMeta := metav1.ObjectMeta{Annotations: annotations}
+
+			r, err := whsvr.getResourceRequirements(&podObjectMeta)
+			assert.Nil(t, r, "Should not return resources")
+			assert.NotNil(t, err, tc.errorMessage)
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, &corev1.Pod{ObjectMeta: podObjectMeta}))
+			assert.Nil(t, err, "Should not return an error")
+			assert.False(t, response.Allowed, tc.errorMessage)
+			assert.Contains(t, response.Result.Message, "exceeds limit", "Should explain why the resources are invalid")
+		})
+	}
+}
+
+func TestWebhookServer_mutateDryRun(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+		config:          Config{DryRun: true},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should succeed")
+	assert.True(t, response.Allowed, "Should allow the pod")
+	assert.Empty(t, response.Patch, "Should not return a patch")
+	assert.Nil(t, response.PatchType, "Should not return a patch type")
+	assert.Contains(t, logs.String(), "sidecar-aws-sigv4-proxy", "Should log the computed sidecar container")
+}
+
+func TestWebhookServer_mutateDryRunRedactsRoleArn(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	roleArn := "arn:aws:iam::123456789012:role/secret-role"
+
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+		config:          Config{DryRun: true},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey:  "true",
+				signingProxyWebhookAnnotationHostKey:    "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationRoleArnKey: roleArn,
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should succeed")
+	assert.True(t, response.Allowed, "Should allow the pod")
+	assert.Contains(t, logs.String(), "--role-arn", "Should log the patch")
+	assert.Contains(t, logs.String(), "<redacted role ARN>", "Should redact the role ARN in the logged patch")
+	assert.NotContains(t, logs.String(), roleArn, "Should not log the role ARN")
+}
+
+// decodeLoggedPatch returns the last base64 encoded patch logged at the debug level.
+func decodeLoggedPatch(t *testing.T, logs string) string {
+	const marker = "Admission Response patch (base64): "
+
+	i := strings.LastIndex(logs, marker)
+	if !assert.True(t, i >= 0, "Should log the patch") {
+		return ""
+	}
+
+	encoded := strings.TrimSpace(strings.SplitN(logs[i+len(marker):], "\n", 2)[0])
+	patch, err := base64.StdEncoding.DecodeString(encoded)
+	assert.Nil(t, err, "Should log the patch base64 encoded")
+
+	return string(patch)
+}
+
+func TestWebhookServer_mutateLogLevel(t *testing.T) {
+	roleArn := "arn:aws:iam::123456789012:role/secret-role"
+
+	var testCases = []struct {
+		name     string
+		logLevel string
+		debug    bool
+	}{
+		{name: "TestDefault", logLevel: "", debug: false},
+		{name: "TestInfo", logLevel: LogLevelInfo, debug: false},
+		{name: "TestDebug", logLevel: LogLevelDebug, debug: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{LogLevel: tc.logLevel},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-pod",
+					Annotations: map[string]string{
+						signingProxyWebhookAnnotationInjectKey:  "true",
+						signingProxyWebhookAnnotationHostKey:    "aps-workspaces.us-west-2.amazonaws.com",
+						signingProxyWebhookAnnotationRoleArnKey: roleArn,
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			assert.Contains(t, logs.String(), "Admission Response: injecting sidecar", "Should log the decision")
+			assert.Contains(t, logs.String(), "app-pod", "Should name the pod in the decision")
+			assert.NotContains(t, logs.String(), roleArn, "Should not log the role ARN in plain text")
+
+			if tc.debug {
+				assert.Contains(t, decodeLoggedPatch(t, logs.String()), roleArn, "Should log the full patch at the debug level")
+			} else {
+				assert.NotContains(t, logs.String(), "patch (base64)", "Should not log the patch at the info level")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_getStartupProbe(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podObjectMeta *metav1.ObjectMeta
+		expected      *int32
+		errorMessage  string
+	}{
+		{
+			name: "TestSidecarProbesDisabled",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationStartupThresholdKey: "10",
+				},
+			},
+			expected:     nil,
+			errorMessage: "Should not add a startup probe when probes are disabled",
+		},
+		{
+			name: "TestSidecarProbesEnabledDefaultThreshold",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationProbesKey: "true",
+				},
+			},
+			expected:     func() *int32 { i := int32(signingProxyWebhookStartupProbeDefaultThreshold); return &i }(),
+			errorMessage: "Should add a startup probe with the default threshold",
+		},
+		{
+			name: "TestSidecarProbesEnabledConfiguredThreshold",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationProbesKey:           "true",
+					signingProxyWebhookAnnotationStartupThresholdKey: "60",
+				},
+			},
+			expected:     func() *int32 { i := int32(60); return &i }(),
+			errorMessage: "Should add a startup probe with the configured threshold",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			probe, err := whsvr.getStartupProbe(tc.podObjectMeta)
+			assert.Nil(t, err, "Should succeed")
+
+			if tc.expected == nil {
+				assert.Nil(t, probe, tc.errorMessage)
+				return
+			}
+
+			assert.NotNil(t, probe, tc.errorMessage)
+			assert.Equal(t, *tc.expected, probe.FailureThreshold, tc.errorMessage)
+			assert.Equal(t, 8005, probe.TCPSocket.Port.IntValue(), "Should probe the proxy port")
+		})
+	}
+
+	t.Run("TestSidecarProbesInvalidThreshold", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: nil,
+		}
+
+		_, err := whsvr.getStartupProbe(&metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationProbesKey:           "true",
+				signingProxyWebhookAnnotationStartupThresholdKey: "-1",
+			},
+		})
+		assert.NotNil(t, err, "Should reject a negative threshold")
+	})
+}
+
+func TestWebhookServer_mutateAllowedHosts(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		allowedHosts []string
+		host         string
+		allowed      bool
+		errorMessage string
+	}{
+		{
+			name:         "TestAllowedHost",
+			allowedHosts: []string{"aps-workspaces.*.amazonaws.com", "*.us-east-1.es.amazonaws.com"},
+			host:         "search-domain.us-east-1.es.amazonaws.com",
+			allowed:      true,
+			errorMessage: "Should inject the sidecar for an allowed host",
+		},
+		{
+			name:         "TestDisallowedHost",
+			allowedHosts: []string{"*.us-east-1.es.amazonaws.com"},
+			host:         "exfiltrate.attacker.example.com",
+			allowed:      false,
+			errorMessage: "Should deny a host that matches no pattern",
+		},
+		{
+			name:         "TestAllowedHostWithPort",
+			allowedHosts: []string{"*.us-east-1.es.amazonaws.com"},
+			host:         "search-domain.us-east-1.es.amazonaws.com:8443",
+			allowed:      true,
+			errorMessage: "Should match the hostname regardless of the port",
+		},
+		{
+			name:         "TestEmptyAllowedHosts",
+			allowedHosts: nil,
+			host:         "exfiltrate.attacker.example.com",
+			allowed:      true,
+			errorMessage: "Should allow any host when the allowlist is empty",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{AllowedHosts: tc.allowedHosts},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						signingProxyWebhookAnnotationInjectKey: "true",
+						signingProxyWebhookAnnotationHostKey:   tc.host,
+					},
+				},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+			assert.Equal(t, tc.allowed, len(response.Patch) > 0, tc.errorMessage)
+		})
+	}
+}
+
+func TestValidateHostPatterns(t *testing.T) {
+	assert.Nil(t, ValidateHostPatterns([]string{"*.us-east-1.es.amazonaws.com"}), "Should accept a valid pattern")
+	assert.NotNil(t, ValidateHostPatterns([]string{"[.amazonaws.com"}), "Should reject a malformed pattern")
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersRegionPrecedence(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podObjectMeta *metav1.ObjectMeta
+		labels        map[string]string
+		defaultRegion string
+		expected      string
+		errorMessage  string
+	}{
+		{
+			name: "TestRegionFromAnnotation",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationRegionKey: "eu-west-1",
+				},
+			},
+			labels:        map[string]string{},
+			defaultRegion: "ap-south-1",
+			expected:      "eu-west-1",
+			errorMessage:  "Should prefer the region annotation",
+		},
+		{
+			name: "TestRegionFromLabel",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookLabelRegionKey: "eu-west-1",
+			},
+			defaultRegion: "ap-south-1",
+			expected:      "eu-west-1",
+			errorMessage:  "Should prefer the region label over the host",
+		},
+		{
+			name: "TestRegionFromHost",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-west-2.amazonaws.com",
+				},
+			},
+			labels:        map[string]string{},
+			defaultRegion: "ap-south-1",
+			expected:      "us-west-2",
+			errorMessage:  "Should prefer the region derived from the host over the default",
+		},
+		{
+			name: "TestRegionFromDefault",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey: "search.internal.example.com",
+				},
+			},
+			labels:        map[string]string{},
+			defaultRegion: "ap-south-1",
+			expected:      "ap-south-1",
+			errorMessage:  "Should fall back to the default region when the host has none",
+		},
+		{
+			name: "TestRegionWithoutDefault",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey: "search.internal.example.com",
+				},
+			},
+			labels:       map[string]string{},
+			expected:     "internal",
+			errorMessage: "Should derive the region from the host when there is no default",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+				config:          Config{DefaultRegion: tc.defaultRegion},
+			}
+
+			_, _, region, _, _, err := whsvr.getUpstreamEndpointParameters(tc.labels, tc.podObjectMeta)
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.expected, region, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateSigningArgs(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		annotations     map[string]string
+		unsignedPayload bool
+		signHeaders     string
+		errorMessage    string
+	}{
+		{
+			name:         "TestNoSigningArgs",
+			annotations:  map[string]string{},
+			errorMessage: "Should not append signing args by default",
+		},
+		{
+			name: "TestUnsignedPayloadOnly",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationUnsignedPayloadKey: "true",
+			},
+			unsignedPayload: true,
+			errorMessage:    "Should append only --unsigned-payload",
+		},
+		{
+			name: "TestSignHeaderOnly",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationSignHeaderKey: "X-Amz-Expected-Bucket-Owner=123456789",
+			},
+			signHeaders:  "X-Amz-Expected-Bucket-Owner=123456789",
+			errorMessage: "Should append only --custom-headers",
+		},
+		{
+			name: "TestUnsignedPayloadAndSignHeader",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationUnsignedPayloadKey: "true",
+				signingProxyWebhookAnnotationSignHeaderKey:      "X-Team=observability, X-Env=prod",
+			},
+			unsignedPayload: true,
+			signHeaders:     "X-Team=observability,X-Env=prod",
+			errorMessage:    "Should append both signing args",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "s3.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.annotations {
+				annotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+
+			if tc.unsignedPayload {
+				assert.Contains(t, container.Args, "--unsigned-payload", tc.errorMessage)
+			} else {
+				assert.NotContains(t, container.Args, "--unsigned-payload", tc.errorMessage)
+			}
+
+			assert.Equal(t, tc.signHeaders, argValue(container.Args, "--custom-headers"), tc.errorMessage)
+			assert.Equal(t, "https", argValue(container.Args, "--upstream-url-scheme"), "Should keep the upstream scheme")
+		})
+	}
+
+	t.Run("TestInvalidSignHeader", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: nil,
+		}
+
+		_, err := whsvr.getSignHeaders(&metav1.ObjectMeta{
+			Annotations: map[string]string{signingProxyWebhookAnnotationSignHeaderKey: "X-Team"},
+		})
+		assert.NotNil(t, err, "Should reject a header without a value")
+	})
+}
+
+func TestWebhookServer_mutateExistingSidecar(t *testing.T) {
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app"},
+			{Name: signingProxyWebhookContainerName},
+		}},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should succeed")
+	assert.True(t, response.Allowed, "Should allow the pod")
+	assert.Empty(t, response.Patch, "Should not inject a duplicate sidecar")
+}
+
+func TestEscapeJSONPointer(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{name: "TestEscapeNeither", token: "status", expected: "status"},
+		{name: "TestEscapeSlash", token: "sidecar.aws.signing-proxy/status", expected: "sidecar.aws.signing-proxy~1status"},
+		{name: "TestEscapeTilde", token: "team~status", expected: "team~0status"},
+		{name: "TestEscapeSlashAndTilde", token: "example.com/~1", expected: "example.com~1~01"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, escapeJSONPointer(tc.token))
+		})
+	}
+}
+
+func TestUpdateAnnotations(t *testing.T) {
+	patch := updateMetadata(map[string]string{"example.com/~team": "old"}, map[string]string{"example.com/~team": "new"}, "/metadata/annotations")
+	assert.Equal(t, []PatchOperation{{
+		Op:    "replace",
+		Path:  "/metadata/annotations/example.com~1~0team",
+		Value: "new",
+	}}, patch, "Should escape the annotation key")
+}
+
+func TestWebhookServer_mutateNilAnnotations(t *testing.T) {
+	whsvr := &WebhookServer{
+		server: nil,
+		namespaceClient: newNamespaceClient(map[string]string{
+			"sidecar-inject":                "true",
+			signingProxyWebhookLabelHostKey: "aps-workspaces.us-west-2.amazonaws.com",
+		}),
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+	assert.Nil(t, err, "Should succeed")
+
+	patch := decodePatch(t, response)
+
+	parentIndex, keyIndex := -1, -1
+	for i, operation := range patch {
+		switch operation.Path {
+		case "/metadata/annotations":
+			parentIndex = i
+			assert.Equal(t, "add", operation.Op, "Should add the annotations object")
+			assert.Equal(t, map[string]interface{}{}, operation.Value, "Should add an empty annotations object")
+		case "/metadata/annotations/" + escapeJSONPointer(signingProxyWebhookAnnotationStatusKey):
+			keyIndex = i
+		}
+	}
+
+	assert.NotEqual(t, -1, parentIndex, "Should create the annotations object")
+	assert.NotEqual(t, -1, keyIndex, "Should add the status annotation")
+	assert.Less(t, parentIndex, keyIndex, "Should create the annotations object before adding keys")
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersScheme(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		scheme       string
+		expected     string
+		errorMessage string
+	}{
+		{name: "TestSchemeDefault", scheme: "", expected: "https", errorMessage: "Should default to https"},
+		{name: "TestSchemeHttps", scheme: "https", expected: "https", errorMessage: "Should return https"},
+		{name: "TestSchemeHttp", scheme: "HTTP", expected: "http", errorMessage: "Should return http"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			podObjectMeta := &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey:   "search.us-west-2.es.amazonaws.com",
+					signingProxyWebhookAnnotationSchemeKey: tc.scheme,
+				},
+			}
+
+			_, _, _, _, scheme, err := whsvr.getUpstreamEndpointParameters(map[string]string{}, podObjectMeta)
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.expected, scheme, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestSchemeInvalid", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   "search.us-west-2.es.amazonaws.com",
+					signingProxyWebhookAnnotationSchemeKey: "ftp",
+				},
+			},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should deny an invalid scheme")
+		assert.Contains(t, response.Result.Message, "ftp", "Should name the invalid scheme")
+	})
+}
+
+func TestWebhookServer_mutateHostHeader(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		hostHeader   string
+		expected     string
+		errorMessage string
+	}{
+		{
+			name:         "TestHostHeaderDecoupled",
+			hostHeader:   "aps-workspaces.us-west-2.amazonaws.com",
+			expected:     "aps-workspaces.us-west-2.amazonaws.com",
+			errorMessage: "Should sign for the host header while connecting to the VPC endpoint",
+		},
+		{
+			name:         "TestHostHeaderNotSet",
+			hostHeader:   "",
+			expected:     "",
+			errorMessage: "Should not override the signed host by default",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						signingProxyWebhookAnnotationInjectKey:     "true",
+						signingProxyWebhookAnnotationHostKey:       "vpce-0123.aps-workspaces.us-west-2.vpce.amazonaws.com",
+						signingProxyWebhookAnnotationNameKey:       "aps",
+						signingProxyWebhookAnnotationRegionKey:     "us-west-2",
+						signingProxyWebhookAnnotationHostHeaderKey: tc.hostHeader,
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+			assert.Equal(t, "vpce-0123.aps-workspaces.us-west-2.vpce.amazonaws.com", argValue(container.Args, "--host"), "Should connect to the upstream host")
+			assert.Equal(t, tc.expected, argValue(container.Args, "--sign-host"), tc.errorMessage)
+		})
+	}
+
+	t.Run("TestHostHeaderInvalid", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: nil,
+		}
+
+		_, err := whsvr.getHostHeader(&metav1.ObjectMeta{
+			Annotations: map[string]string{signingProxyWebhookAnnotationHostHeaderKey: "https://aps.us-west-2.amazonaws.com"},
+		})
+		assert.NotNil(t, err, "Should reject a host header that is not a hostname")
+	})
+}
+
+func TestWebhookServer_mutateEnvFromSecret(t *testing.T) {
+	annotations := func(secret string) map[string]string {
+		return map[string]string{
+			signingProxyWebhookAnnotationInjectKey:        "true",
+			signingProxyWebhookAnnotationHostKey:          "aps-workspaces.us-west-2.amazonaws.com",
+			signingProxyWebhookAnnotationEnvFromSecretKey: secret,
+		}
+	}
+
+	t.Run("TestEnvFromSecret", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations("proxy-credentials")},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Equal(t, []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-credentials"},
+			},
+		}}, container.EnvFrom, "Should reference the Secret")
+	})
+
+	t.Run("TestEnvFromSecretNotSet", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations("")},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+		assert.Empty(t, container.EnvFrom, "Should not reference a Secret")
+	})
+
+	t.Run("TestEnvFromSecretInvalidName", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations("Proxy_Credentials")},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should deny an invalid Secret name")
+		assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationEnvFromSecretKey, "Should name the offending annotation")
+	})
+}
+
+func TestWebhookServer_shouldMutateObjectSelector(t *testing.T) {
+	objectSelector, err := labels.Parse("app in (api,worker),team=observability")
+	assert.Nil(t, err, "Should parse the selector")
+
+	var testCases = []struct {
+		name          string
+		podObjectMeta *metav1.ObjectMeta
+		labels        map[string]string
+		expected      bool
+		errorMessage  string
+	}{
+		{
+			name: "TestObjectSelectorMatchesAnnotation",
+			podObjectMeta: &metav1.ObjectMeta{
+				Labels:      map[string]string{"app": "api", "team": "observability"},
+				Annotations: map[string]string{signingProxyWebhookAnnotationInjectKey: "true", signingProxyWebhookAnnotationHostKey: "random"},
+			},
+			labels:       map[string]string{},
+			expected:     true,
+			errorMessage: "Should inject sidecar - pod matches the object selector",
+		},
+		{
+			name: "TestObjectSelectorMatchesNamespaceLabel",
+			podObjectMeta: &metav1.ObjectMeta{
+				Labels:      map[string]string{"app": "worker", "team": "observability"},
+				Annotations: map[string]string{signingProxyWebhookAnnotationHostKey: "random"},
+			},
+			labels:       map[string]string{"sidecar-inject": "true"},
+			expected:     true,
+			errorMessage: "Should inject sidecar - pod in injected namespace matches the object selector",
+		},
+		{
+			name: "TestObjectSelectorMismatch",
+			podObjectMeta: &metav1.ObjectMeta{
+				Labels:      map[string]string{"app": "frontend", "team": "observability"},
+				Annotations: map[string]string{signingProxyWebhookAnnotationInjectKey: "true", signingProxyWebhookAnnotationHostKey: "random"},
+			},
+			labels:       map[string]string{"sidecar-inject": "true"},
+			expected:     false,
+			errorMessage: "Should not inject sidecar - pod does not match the object selector",
+		},
+		{
+			name: "TestObjectSelectorNoPodLabels",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{signingProxyWebhookAnnotationInjectKey: "true", signingProxyWebhookAnnotationHostKey: "random"},
+			},
+			labels:       map[string]string{},
+			expected:     false,
+			errorMessage: "Should not inject sidecar - pod has no labels",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+				config:          Config{ObjectSelector: objectSelector},
+			}
+
+			b := whsvr.shouldMutate(tc.labels, tc.podObjectMeta)
+			assert.Equal(t, tc.expected, b, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutatePreStopHook(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podAnnotation map[string]string
+		expected      *corev1.Lifecycle
+		errorMessage  string
+	}{
+		{
+			name:          "TestPreStopDisabled",
+			podAnnotation: map[string]string{},
+			expected:      nil,
+			errorMessage:  "Should not add a preStop hook by default",
+		},
+		{
+			name: "TestPreStopDefaultCommand",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationPreStopKey: "true",
+			},
+			expected: &corev1.Lifecycle{PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: []string{"sleep", "5"}},
+			}},
+			errorMessage: "Should add a preStop hook sleeping briefly",
+		},
+		{
+			name: "TestPreStopConfiguredCommand",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationPreStopKey:        "true",
+				signingProxyWebhookAnnotationPreStopCommandKey: `["/bin/sh", "-c", "sleep 15"]`,
+			},
+			expected: &corev1.Lifecycle{PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", "sleep 15"}},
+			}},
+			errorMessage: "Should add a preStop hook running the configured command",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+			assert.Equal(t, tc.expected, container.Lifecycle, tc.errorMessage)
+		})
+	}
+
+	for _, command := range []string{"[]", "sleep 5"} {
+		t.Run("TestPreStopInvalidCommand", func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey:         "true",
+					signingProxyWebhookAnnotationHostKey:           "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationPreStopKey:        "true",
+					signingProxyWebhookAnnotationPreStopCommandKey: command,
+				}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.False(t, response.Allowed, "Should deny an invalid preStop command")
+			assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationPreStopCommandKey, "Should name the offending annotation")
+		})
+	}
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersRegionValidation(t *testing.T) {
+	var testCases = []struct {
+		name                string
+		host                string
+		region              string
+		allowUnknownRegions bool
+		valid               bool
+		errorMessage        string
+	}{
+		{name: "TestKnownRegion", host: "aps-workspaces.eu-west-1.amazonaws.com", region: "", valid: true, errorMessage: "Should accept a known region"},
+		{name: "TestKnownGovCloudRegion", host: "aps-workspaces.us-gov-west-1.amazonaws.com", region: "", valid: true, errorMessage: "Should accept a known GovCloud region"},
+		{name: "TestMalformedRegion", host: "aps-workspaces.us-west-2.amazonaws.com", region: "us-east-11a", valid: false, errorMessage: "Should reject a malformed region"},
+		{name: "TestRegionMissingFromHost", host: "search.internal.example.com", region: "", valid: false, errorMessage: "Should reject a host without a region when there is no default"},
+		{name: "TestUnknownRegion", host: "aps-workspaces.us-west-2.amazonaws.com", region: "us-east-11", valid: false, errorMessage: "Should reject a well-formed region missing from the list"},
+		{name: "TestUnknownRegionAllowed", host: "aps-workspaces.us-west-2.amazonaws.com", region: "us-east-11", allowUnknownRegions: true, valid: true, errorMessage: "Should accept a well-formed unknown region when allowed"},
+		{name: "TestMalformedRegionAllowUnknown", host: "aps-workspaces.us-west-2.amazonaws.com", region: "useast1", allowUnknownRegions: true, valid: false, errorMessage: "Should reject a malformed region even when unknown regions are allowed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+				config:          Config{ValidateRegions: true, AllowUnknownRegions: tc.allowUnknownRegions},
+			}
+
+			podObjectMeta := &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey:   tc.host,
+					signingProxyWebhookAnnotationRegionKey: tc.region,
+				},
+			}
+
+			_, _, _, _, _, err := whsvr.getUpstreamEndpointParameters(map[string]string{}, podObjectMeta)
+			assert.Equal(t, tc.valid, err == nil, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateSharedVolume(t *testing.T) {
+	annotations := map[string]string{
+		signingProxyWebhookAnnotationInjectKey:           "true",
+		signingProxyWebhookAnnotationHostKey:             "aps-workspaces.us-west-2.amazonaws.com",
+		signingProxyWebhookAnnotationSharedVolumeKey:     "app",
+		signingProxyWebhookAnnotationSharedVolumePathKey: "/var/run/credentials",
+	}
+
+	t.Run("TestSharedVolume", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "init-helper"},
+				{Name: "app", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}},
+			}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		patch := decodePatch(t, response)
+		expectedMount := corev1.VolumeMount{Name: signingProxyWebhookSharedVolumeName, MountPath: "/var/run/credentials"}
+
+		var volumes []corev1.Volume
+		assert.True(t, findPatchValue(t, patch, "/spec/volumes", &volumes), "Should add the shared volume")
+		assert.Equal(t, []corev1.Volume{{
+			Name:         signingProxyWebhookSharedVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}}, volumes, "Should add an emptyDir volume")
+
+		var sidecar corev1.Container
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &sidecar), "Should add the sidecar")
+		assert.Equal(t, []corev1.VolumeMount{expectedMount}, sidecar.VolumeMounts, "Should mount the shared volume in the sidecar")
+
+		var appMount corev1.VolumeMount
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/1/volumeMounts/-", &appMount), "Should append to the app container's volume mounts")
+		assert.Equal(t, expectedMount, appMount, "Should mount the shared volume in the app container")
+	})
+
+	t.Run("TestSharedVolumeFirstMount", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		var appMounts []corev1.VolumeMount
+		assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/0/volumeMounts", &appMounts), "Should create the app container's volume mounts")
+		assert.Equal(t, []corev1.VolumeMount{{Name: signingProxyWebhookSharedVolumeName, MountPath: "/var/run/credentials"}}, appMounts, "Should mount the shared volume in the app container")
+	})
+
+	t.Run("TestSharedVolumeMissingContainer", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "worker"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should deny when the app container is not found")
+		assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationSharedVolumeKey, "Should name the offending annotation")
+	})
+}
+
+func TestWebhookServer_mutateStatusAnnotation(t *testing.T) {
+	statusAnnotation := "staging.example.com/sigv4-proxy-status"
+
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		containers   []corev1.Container
+		injected     bool
+		errorMessage string
+	}{
+		{
+			name:         "TestNotInjected",
+			annotations:  map[string]string{},
+			injected:     true,
+			errorMessage: "Should inject a pod without the configured status annotation",
+		},
+		{
+			name:         "TestInjectedByConfiguredController",
+			annotations:  map[string]string{statusAnnotation: "injected"},
+			injected:     false,
+			errorMessage: "Should skip a pod carrying the configured status annotation",
+		},
+		{
+			name:         "TestInjectedByDefaultController",
+			annotations:  map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"},
+			injected:     true,
+			errorMessage: "Should ignore the default status annotation of another controller",
+		},
+		{
+			name:         "TestSidecarOfAnotherController",
+			annotations:  map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"},
+			containers:   []corev1.Container{{Name: signingProxyWebhookContainerName}},
+			injected:     false,
+			errorMessage: "Should skip a pod running the sidecar container whatever its status annotation",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{StatusAnnotation: statusAnnotation},
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.annotations {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: append([]corev1.Container{{Name: "app"}}, tc.containers...)},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			patch := decodePatch(t, response)
+
+			var status string
+			assert.Equal(t, tc.injected, findPatchValue(t, patch, "/metadata/annotations/"+escapeJSONPointer(statusAnnotation), &status), tc.errorMessage)
+			assert.False(t, findPatchValue(t, patch, "/metadata/annotations/"+escapeJSONPointer(signingProxyWebhookAnnotationStatusKey), &status), "Should not write the default status annotation")
+		})
+	}
+}
+
+func TestNewWebhookServer(t *testing.T) {
+	var nilClientset *kubernetes.Clientset
+
+	var testCases = []struct {
+		name         string
+		client       kubernetes.Interface
+		valid        bool
+		errorMessage string
+	}{
+		{name: "TestNilClient", client: nil, valid: false, errorMessage: "Should return an error for a nil client"},
+		{name: "TestNilClientset", client: nilClientset, valid: false, errorMessage: "Should return an error for a nil *Clientset"},
+		{name: "TestClient", client: fake.NewSimpleClientset(), valid: true, errorMessage: "Should create the webhook server"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr, err := NewWebhookServer(nil, tc.client, Config{})
+			assert.Equal(t, tc.valid, err == nil, tc.errorMessage)
+			assert.Equal(t, tc.valid, whsvr != nil, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersMixedSources(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podObjectMeta *metav1.ObjectMeta
+		labels        map[string]string
+		expected      []string
+		errorMessage  string
+	}{
+		{
+			name: "TestHostAnnotationRegionLabel",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey: "search.internal.example.com",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelRegionKey: "eu-west-1",
+			},
+			expected:     []string{"search.internal.example.com", "search", "eu-west-1", "", "https"},
+			errorMessage: "Should take the region from the label when the host comes from an annotation",
+		},
+		{
+			name: "TestHostLabelNameAnnotation",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationNameKey: "aps",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookLabelNameKey:   "labelName",
+				signingProxyWebhookLabelRegionKey: "us-east-1",
+			},
+			expected:     []string{"aps-workspaces.us-west-2.amazonaws.com", "aps", "us-east-1", "", "https"},
+			errorMessage: "Should take the name from the annotation when the host comes from a label",
+		},
+		{
+			name: "TestSchemeAndPayloadFromLabels",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationRegionKey: "us-west-1",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelRegionKey:          "us-east-1",
+				signingProxyWebhookLabelUnsignedPayloadKey: "true",
+				signingProxyWebhookLabelSchemeKey:          "http",
+			},
+			expected:     []string{"aps-workspaces.us-west-2.amazonaws.com", "aps-workspaces", "us-west-1", "true", "http"},
+			errorMessage: "Should prefer annotations and fall back to labels per parameter",
+		},
+		{
+			name: "TestBlankAnnotationFallsBackToLabel",
+			podObjectMeta: &metav1.ObjectMeta{
+				Annotations: map[string]string{
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationRegionKey: " ",
+				},
+			},
+			labels: map[string]string{
+				signingProxyWebhookLabelRegionKey: "us-east-1",
+			},
+			expected:     []string{"aps-workspaces.us-west-2.amazonaws.com", "aps-workspaces", "us-east-1", "", "https"},
+			errorMessage: "Should ignore blank annotations",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			host, name, region, unsignedPayload, scheme, err := whsvr.getUpstreamEndpointParameters(tc.labels, tc.podObjectMeta)
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.expected, []string{host, name, region, unsignedPayload, scheme}, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateAnnotationPrefix(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		annotationPrefix string
+		annotations      map[string]string
+		injected         bool
+		statusAnnotation string
+		errorMessage     string
+	}{
+		{
+			name:             "TestCustomPrefix",
+			annotationPrefix: "sigv4.example.com",
+			annotations: map[string]string{
+				"sigv4.example.com/inject": "true",
+				"sigv4.example.com/host":   "aps-workspaces.us-west-2.amazonaws.com",
+				"sigv4.example.com/name":   "aps",
+			},
+			injected:         true,
+			statusAnnotation: "sigv4.example.com/status",
+			errorMessage:     "Should inject using annotations with the custom prefix",
+		},
+		{
+			name:             "TestCustomPrefixIgnoresDefault",
+			annotationPrefix: "sigv4.example.com",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+			injected:     false,
+			errorMessage: "Should ignore annotations with the default prefix",
+		},
+		{
+			name:             "TestDefaultPrefix",
+			annotationPrefix: "",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationNameKey:   "aps",
+			},
+			injected:         true,
+			statusAnnotation: signingProxyWebhookAnnotationStatusKey,
+			errorMessage:     "Should inject using annotations with the default prefix",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{AnnotationPrefix: tc.annotationPrefix},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			patch := decodePatch(t, response)
+
+			var container corev1.Container
+			assert.Equal(t, tc.injected, findPatchValue(t, patch, "/spec/containers/-", &container), tc.errorMessage)
+
+			if tc.injected {
+				var status string
+				assert.Equal(t, "aps", argValue(container.Args, "--name"), "Should read the name annotation")
+				assert.True(t, findPatchValue(t, patch, "/metadata/annotations/"+escapeJSONPointer(tc.statusAnnotation), &status), "Should write the status annotation with the prefix")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateCommand(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podAnnotation map[string]string
+		allowed       bool
+		expected      []string
+		errorMessage  string
+	}{
+		{
+			name:          "TestCommandNotSet",
+			podAnnotation: map[string]string{},
+			allowed:       true,
+			expected:      nil,
+			errorMessage:  "Should keep the image entrypoint",
+		},
+		{
+			name: "TestCommand",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationCommandKey: `["/usr/local/bin/aws-sigv4-proxy-fips"]`,
+			},
+			allowed:      true,
+			expected:     []string{"/usr/local/bin/aws-sigv4-proxy-fips"},
+			errorMessage: "Should set the command from the annotation",
+		},
+		{
+			name: "TestCommandEmpty",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationCommandKey: " ",
+			},
+			allowed:      false,
+			errorMessage: "Should deny an empty command",
+		},
+		{
+			name: "TestCommandEmptyArray",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationCommandKey: `[""]`,
+			},
+			allowed:      false,
+			errorMessage: "Should deny a command without an executable",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if tc.allowed {
+				var container corev1.Container
+				assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+				assert.Equal(t, tc.expected, container.Command, tc.errorMessage)
+				assert.NotEmpty(t, container.Args, "Should keep the sidecar arguments")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_HandlerMaxConcurrentRequests(t *testing.T) {
+	maxConcurrentRequests := 2
+
+	whsvr, err := NewWebhookServer(nil, fake.NewSimpleClientset(), Config{MaxConcurrentRequests: maxConcurrentRequests})
+	assert.Nil(t, err, "Should create the webhook server")
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	namespaceClient := &mocks.KubernetesNamespaceClient{}
+	namespaceClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		entered <- struct{}{}
+		<-release
+	}).Return(&corev1.Namespace{}, nil)
+	whsvr.namespaceClient = namespaceClient
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	body := newAdmissionReviewBody(t, "admission.k8s.io/v1", pod)
+
+	serve := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		whsvr.Handler(recorder, request)
+		return recorder
+	}
+
+	results := make(chan int, maxConcurrentRequests)
+	for i := 0; i < maxConcurrentRequests; i++ {
+		go func() { results <- serve().Code }()
+		<-entered
+	}
+
+	rejected := serve()
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code, "Should reject requests over the limit")
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"), "Should ask the caller to retry")
+
+	close(release)
+	for i := 0; i < maxConcurrentRequests; i++ {
+		assert.Equal(t, http.StatusOK, <-results, "Should serve requests within the limit")
+	}
+
+	go func() { <-entered }()
+	assert.Equal(t, http.StatusOK, serve().Code, "Should accept requests once capacity is released")
+}
+
+func TestWebhookServer_mutateRequireDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+
+	var testCases = []struct {
+		name          string
+		image         string
+		requireDigest bool
+		allowed       bool
+		errorMessage  string
+	}{
+		{name: "TestDigestRequired", image: "public.ecr.aws/aws-observability/aws-sigv4-proxy@" + digest, requireDigest: true, allowed: true, errorMessage: "Should accept a digest-pinned image"},
+		{name: "TestTagAndDigestRequired", image: "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8@" + digest, requireDigest: true, allowed: true, errorMessage: "Should accept a tagged image pinned by digest"},
+		{name: "TestTagRequireDigest", image: "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8", requireDigest: true, allowed: false, errorMessage: "Should reject a tag-only image when digests are required"},
+		{name: "TestTagDigestNotRequired", image: "public.ecr.aws/aws-observability/aws-sigv4-proxy:1.8", requireDigest: false, allowed: true, errorMessage: "Should allow a tag-only image when digests are not required"},
+		{name: "TestMalformedDigest", image: "public.ecr.aws/aws-observability/aws-sigv4-proxy@sha256:abc", requireDigest: false, allowed: false, errorMessage: "Should reject a malformed digest"},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := Config{Image: tc.image, RequireDigest: tc.requireDigest}
+			err := config.Validate()
+			assert.Equal(t, tc.allowed, err == nil, tc.errorMessage)
+
+			if !tc.allowed {
+				return
+			}
+
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          config,
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+			assert.Equal(t, tc.image, container.Image, "Should pass the image through unchanged")
+		})
+	}
+
+	t.Run("TestTransparentInitImage", func(t *testing.T) {
+		t.Setenv("AWS-SIGV4-PROXY-INIT-IMAGE", "")
+
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			config:          Config{Image: "public.ecr.aws/aws-observability/aws-sigv4-proxy@" + digest, RequireDigest: true},
+		}
+
+		transparent := pod.DeepCopy()
+		transparent.Annotations[signingProxyWebhookAnnotationTransparentKey] = "true"
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, transparent))
+		assert.Nil(t, err, "Should not return an error")
+		assert.False(t, response.Allowed, "Should reject a transparent pod whose init image is not pinned by digest")
+	})
+}
+
+func TestWebhookServer_mutateNamespaceDefaults(t *testing.T) {
+	var testCases = []struct {
+		name              string
+		namespaceDefaults map[string]string
+		podAnnotation     map[string]string
+		allowed           bool
+		internal          bool
+		expectedRegion    string
+		errorMessage      string
+	}{
+		{
+			name:              "TestInheritRegion",
+			namespaceDefaults: map[string]string{"testNamespace": "region: eu-west-1\n"},
+			podAnnotation:     map[string]string{},
+			allowed:           true,
+			expectedRegion:    "eu-west-1",
+			errorMessage:      "Should inherit the region from the namespace defaults",
+		},
+		{
+			name:              "TestOverrideRegion",
+			namespaceDefaults: map[string]string{"testNamespace": "region: eu-west-1\n"},
+			podAnnotation:     map[string]string{signingProxyWebhookAnnotationRegionKey: "us-east-1"},
+			allowed:           true,
+			expectedRegion:    "us-east-1",
+			errorMessage:      "Should prefer the pod annotation over the namespace defaults",
+		},
+		{
+			name:              "TestOtherNamespace",
+			namespaceDefaults: map[string]string{"otherNamespace": "region: eu-west-1\n"},
+			podAnnotation:     map[string]string{},
+			allowed:           true,
+			expectedRegion:    "us-west-2",
+			errorMessage:      "Should ignore the defaults of other namespaces",
+		},
+		{
+			name:              "TestMalformedDefaults",
+			namespaceDefaults: map[string]string{"testNamespace": "region: [eu-west-1]\n"},
+			podAnnotation:     map[string]string{},
+			allowed:           false,
+			internal:          true,
+			errorMessage:      "Should fail pods with an internal error when the namespace defaults are malformed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			assert.Nil(t, indexer.Add(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "sigv4-proxy-defaults"},
+				Data:       tc.namespaceDefaults,
+			}), "Should add the ConfigMap")
+
+			whsvr := &WebhookServer{
+				server:                  nil,
+				namespaceClient:         newNamespaceClient(map[string]string{}),
+				config:                  Config{NamespaceDefaultsConfigMap: "kube-system/sigv4-proxy-defaults"},
+				namespaceDefaultsLister: corelisters.NewConfigMapLister(indexer).ConfigMaps("kube-system"),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if tc.internal {
+				assert.NotNil(t, err, tc.errorMessage)
+				assert.Equal(t, int32(http.StatusInternalServerError), response.Result.Code, tc.errorMessage)
+			} else {
+				assert.Nil(t, err, "Should not return an error")
+			}
+
+			if tc.allowed {
+				patch := decodePatch(t, response)
+
+				var container corev1.Container
+				assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should add the sidecar")
+				assert.Equal(t, tc.expectedRegion, argValue(container.Args, "--region"), tc.errorMessage)
+
+				var region string
+				assert.False(t, findPatchValue(t, patch, "/metadata/annotations/"+escapeJSONPointer(signingProxyWebhookAnnotationRegionKey), &region), "Should not write the defaults to the pod")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_StartNamespaceDefaults(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "sigv4-proxy-defaults"},
+		Data:       map[string]string{"testNamespace": "region: eu-west-1\n"},
+	})
+
+	whsvr, err := NewWebhookServer(nil, k8sClient, Config{NamespaceDefaultsConfigMap: "kube-system/sigv4-proxy-defaults"})
+	assert.Nil(t, err, "Should create the webhook server")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.Nil(t, whsvr.Start(stopCh), "Should sync the ConfigMap informer")
+
+	podMetadata, err := whsvr.applyNamespaceDefaults("testNamespace", &metav1.ObjectMeta{})
+	assert.Nil(t, err, "Should read the namespace defaults")
+	assert.Equal(t, "eu-west-1", podMetadata.Annotations[signingProxyWebhookAnnotationRegionKey], "Should read the ConfigMap from the informer cache")
+
+	_, err = NewWebhookServer(nil, k8sClient, Config{NamespaceDefaultsConfigMap: "sigv4-proxy-defaults"})
+	assert.NotNil(t, err, "Should reject a ConfigMap reference without a namespace")
+}
+
+func TestWebhookServer_mutateNoStatusAnnotation(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		containers   []corev1.Container
+		injected     bool
+		status       bool
+		errorMessage string
+	}{
+		{
+			name:         "TestStatusAnnotation",
+			annotations:  map[string]string{},
+			containers:   []corev1.Container{{Name: "app"}},
+			injected:     true,
+			status:       true,
+			errorMessage: "Should mark the pod with the status annotation by default",
+		},
+		{
+			name:         "TestNoStatusAnnotation",
+			annotations:  map[string]string{signingProxyWebhookAnnotationNoStatusKey: "true"},
+			containers:   []corev1.Container{{Name: "app"}},
+			injected:     true,
+			status:       false,
+			errorMessage: "Should not mark the pod when the status annotation is opted out of",
+		},
+		{
+			name:         "TestNoStatusAnnotationFalse",
+			annotations:  map[string]string{signingProxyWebhookAnnotationNoStatusKey: "false"},
+			containers:   []corev1.Container{{Name: "app"}},
+			injected:     true,
+			status:       true,
+			errorMessage: "Should mark the pod when the opt-out is disabled",
+		},
+		{
+			name:         "TestReinjectWithoutStatusAnnotation",
+			annotations:  map[string]string{signingProxyWebhookAnnotationNoStatusKey: "true"},
+			containers:   []corev1.Container{{Name: "app"}, {Name: signingProxyWebhookContainerName}},
+			injected:     false,
+			errorMessage: "Should detect the injected container without the status annotation",
+		},
+		{
+			name:         "TestReinjectWithStatusAnnotation",
+			annotations:  map[string]string{signingProxyWebhookAnnotationStatusKey: "injected"},
+			containers:   []corev1.Container{{Name: "app"}},
+			injected:     false,
+			errorMessage: "Should detect the status annotation without the injected container",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.annotations {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: tc.containers},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			patch := decodePatch(t, response)
+
+			var container corev1.Container
+			assert.Equal(t, tc.injected, findPatchValue(t, patch, "/spec/containers/-", &container), tc.errorMessage)
+
+			var status string
+			assert.Equal(t, tc.status, findPatchValue(t, patch, "/metadata/annotations/"+escapeJSONPointer(signingProxyWebhookAnnotationStatusKey), &status), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateProxyEnv(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		podAnnotation   map[string]string
+		inheritProxyEnv bool
+		controllerEnv   map[string]string
+		allowed         bool
+		expected        []corev1.EnvVar
+		errorMessage    string
+	}{
+		{
+			name:          "TestProxyNotSet",
+			podAnnotation: map[string]string{},
+			controllerEnv: map[string]string{"HTTPS_PROXY": "http://controller-proxy:3128"},
+			allowed:       true,
+			expected:      nil,
+			errorMessage:  "Should not inherit the controller environment unless enabled",
+		},
+		{
+			name: "TestProxyAnnotations",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationHTTPProxyKey:  "http://proxy.example.com:3128",
+				signingProxyWebhookAnnotationHTTPSProxyKey: "http://proxy.example.com:3128",
+				signingProxyWebhookAnnotationNoProxyKey:    "169.254.169.254,.svc",
+			},
+			allowed: true,
+			expected: []corev1.EnvVar{
+				{Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"},
+				{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"},
+				{Name: "NO_PROXY", Value: "169.254.169.254,.svc"},
+			},
+			errorMessage: "Should set the proxy variables from the annotations",
+		},
+		{
+			name:            "TestInheritProxyEnv",
+			podAnnotation:   map[string]string{},
+			inheritProxyEnv: true,
+			controllerEnv:   map[string]string{"HTTPS_PROXY": "http://controller-proxy:3128", "NO_PROXY": ".svc"},
+			allowed:         true,
+			expected: []corev1.EnvVar{
+				{Name: "HTTPS_PROXY", Value: "http://controller-proxy:3128"},
+				{Name: "NO_PROXY", Value: ".svc"},
+			},
+			errorMessage: "Should inherit the proxy variables from the controller environment",
+		},
+		{
+			name: "TestAnnotationOverridesInheritedProxyEnv",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationHTTPSProxyKey: "http://proxy.example.com:3128",
+			},
+			inheritProxyEnv: true,
+			controllerEnv:   map[string]string{"HTTPS_PROXY": "http://controller-proxy:3128"},
+			allowed:         true,
+			expected: []corev1.EnvVar{
+				{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"},
+			},
+			errorMessage: "Should prefer the annotation over the controller environment",
+		},
+		{
+			name: "TestInvalidProxyAnnotation",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationHTTPSProxyKey: "proxy.example.com:3128",
+			},
+			allowed:      false,
+			errorMessage: "Should deny a proxy URL without a scheme",
+		},
+		{
+			name:            "TestInvalidInheritedProxyEnv",
+			podAnnotation:   map[string]string{},
+			inheritProxyEnv: true,
+			controllerEnv:   map[string]string{"HTTP_PROXY": "http://[::1"},
+			allowed:         false,
+			errorMessage:    "Should deny an unparseable inherited proxy URL",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+				t.Setenv(name, tc.controllerEnv[name])
+			}
+
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{InheritProxyEnv: tc.inheritProxyEnv},
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if tc.allowed {
+				var container corev1.Container
+				assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+				assert.Equal(t, tc.expected, container.Env, tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateResultStatus(t *testing.T) {
+	errorKubernetesClient := &mocks.KubernetesNamespaceClient{}
+	errorKubernetesClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("connection refused"))
+
+	var testCases = []struct {
+		name            string
+		namespaceClient KubernetesNamespaceClient
+		raw             []byte
+		expectError     bool
+		code            int32
+		reason          metav1.StatusReason
+		errorMessage    string
+	}{
+		{
+			name:            "TestValidationFailure",
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			code:            http.StatusBadRequest,
+			reason:          metav1.StatusReasonBadRequest,
+			errorMessage:    "Should report a malformed annotation as a bad request",
+		},
+		{
+			name:            "TestMalformedPod",
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			raw:             []byte("{"),
+			expectError:     true,
+			code:            http.StatusBadRequest,
+			reason:          metav1.StatusReasonBadRequest,
+			errorMessage:    "Should report a malformed pod as a bad request",
+		},
+		{
+			name:            "TestInternalError",
+			namespaceClient: errorKubernetesClient,
+			expectError:     true,
+			code:            http.StatusInternalServerError,
+			reason:          metav1.StatusReasonInternalError,
+			errorMessage:    "Should report a failed API call as an internal error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: tc.namespaceClient,
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey:     "true",
+					signingProxyWebhookAnnotationHostKey:       "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationCPURequestKey: "lots",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			admissionReview := newAdmissionReview(t, pod)
+
+			if tc.raw != nil {
+				admissionReview.Request.Object.Raw = tc.raw
+			}
+
+			response, err := whsvr.mutate(context.Background(), admissionReview)
+			assert.Equal(t, tc.expectError, err != nil, "Should only return an error when the request cannot be processed")
+			assert.False(t, response.Allowed, "Should not allow the pod")
+			assert.Equal(t, metav1.StatusFailure, response.Result.Status, tc.errorMessage)
+			assert.Equal(t, tc.code, response.Result.Code, tc.errorMessage)
+			assert.Equal(t, tc.reason, response.Result.Reason, tc.errorMessage)
+			assert.NotEmpty(t, response.Result.Message, "Should explain the failure")
+		})
+	}
+}
+
+func TestWebhookServer_mutateEgressLabel(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		addEgressLabel bool
+		labels         map[string]string
+		expected       []PatchOperation
+		errorMessage   string
+	}{
+		{
+			name:           "TestDisabled",
+			addEgressLabel: false,
+			labels:         map[string]string{"app": "sleep"},
+			expected:       nil,
+			errorMessage:   "Should not label the pod unless enabled",
+		},
+		{
+			name:           "TestEnabled",
+			addEgressLabel: true,
+			labels:         map[string]string{"app": "sleep"},
+			expected: []PatchOperation{
+				{Op: "add", Path: "/metadata/labels/sigv4-proxy-egress", Value: "allowed"},
+			},
+			errorMessage: "Should add the egress label",
+		},
+		{
+			name:           "TestEnabledNilLabels",
+			addEgressLabel: true,
+			labels:         nil,
+			expected: []PatchOperation{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]interface{}{}},
+				{Op: "add", Path: "/metadata/labels/sigv4-proxy-egress", Value: "allowed"},
+			},
+			errorMessage: "Should create the labels before adding the egress label",
+		},
+		{
+			name:           "TestEnabledExistingLabel",
+			addEgressLabel: true,
+			labels:         map[string]string{"sigv4-proxy-egress": "denied"},
+			expected: []PatchOperation{
+				{Op: "replace", Path: "/metadata/labels/sigv4-proxy-egress", Value: "allowed"},
+			},
+			errorMessage: "Should replace an existing egress label",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{AddEgressLabel: tc.addEgressLabel},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tc.labels,
+					Annotations: map[string]string{
+						signingProxyWebhookAnnotationInjectKey: "true",
+						signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			var labelPatch []PatchOperation
+
+			for _, operation := range decodePatch(t, response) {
+				if strings.HasPrefix(operation.Path, "/metadata/labels") {
+					labelPatch = append(labelPatch, operation)
+				}
+			}
+
+			assert.Equal(t, tc.expected, labelPatch, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateRequireIRSA(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	assert.Nil(t, indexer.Add(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "testNamespace",
+			Name:        "irsa",
+			Annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/irsa"},
+		},
+	}), "Should add the IRSA ServiceAccount")
+	assert.Nil(t, indexer.Add(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testNamespace", Name: "default"},
+	}), "Should add the default ServiceAccount")
+
+	var testCases = []struct {
+		name               string
+		requireIRSA        bool
+		serviceAccountName string
+		podAnnotation      map[string]string
+		allowed            bool
+		errorMessage       string
+	}{
+		{
+			name:               "TestIRSAServiceAccount",
+			requireIRSA:        true,
+			serviceAccountName: "irsa",
+			podAnnotation:      map[string]string{},
+			allowed:            true,
+			errorMessage:       "Should inject a pod using an IRSA-annotated ServiceAccount",
+		},
+		{
+			name:               "TestDefaultServiceAccount",
+			requireIRSA:        true,
+			serviceAccountName: "",
+			podAnnotation:      map[string]string{},
+			allowed:            false,
+			errorMessage:       "Should deny a pod using the unannotated default ServiceAccount",
+		},
+		{
+			name:               "TestMissingServiceAccount",
+			requireIRSA:        true,
+			serviceAccountName: "missing",
+			podAnnotation:      map[string]string{},
+			allowed:            false,
+			errorMessage:       "Should deny a pod using a ServiceAccount that does not exist",
+		},
+		{
+			name:               "TestRoleArn",
+			requireIRSA:        true,
+			serviceAccountName: "",
+			podAnnotation:      map[string]string{signingProxyWebhookAnnotationRoleArnKey: "arn:aws:iam::123456789012:role/proxy"},
+			allowed:            true,
+			errorMessage:       "Should not check the ServiceAccount when a role ARN is set",
+		},
+		{
+			name:               "TestNotRequired",
+			requireIRSA:        false,
+			serviceAccountName: "",
+			podAnnotation:      map[string]string{},
+			allowed:            true,
+			errorMessage:       "Should not check the ServiceAccount unless required",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:               nil,
+				namespaceClient:      newNamespaceClient(map[string]string{}),
+				config:               Config{RequireIRSA: tc.requireIRSA},
+				serviceAccountLister: corelisters.NewServiceAccountLister(indexer),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: tc.serviceAccountName,
+					Containers:         []corev1.Container{{Name: "app"}},
+				},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, "eks.amazonaws.com/role-arn", "Should explain how to fix the ServiceAccount")
+			}
+		})
+	}
+}
+
+// errorServiceAccountLister fails every ServiceAccount lookup, as a lister whose cache cannot be read.
+type errorServiceAccountLister struct {
+	corelisters.ServiceAccountLister
+}
+
+func (errorServiceAccountLister) ServiceAccounts(string) corelisters.ServiceAccountNamespaceLister {
+	return errorServiceAccountNamespaceLister{}
+}
+
+type errorServiceAccountNamespaceLister struct {
+	corelisters.ServiceAccountNamespaceLister
+}
+
+func (errorServiceAccountNamespaceLister) Get(string) (*corev1.ServiceAccount, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestWebhookServer_mutateRequireIRSAInternalError(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	var testCases = []struct {
+		name         string
+		lister       corelisters.ServiceAccountLister
+		errorMessage string
+	}{
+		{
+			name:         "TestListerError",
+			lister:       errorServiceAccountLister{},
+			errorMessage: "Should report a failed ServiceAccount lookup as an internal error",
+		},
+		{
+			name:         "TestNoLister",
+			lister:       nil,
+			errorMessage: "Should report unwatched ServiceAccounts as an internal error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:               nil,
+				namespaceClient:      newNamespaceClient(map[string]string{}),
+				config:               Config{RequireIRSA: true},
+				serviceAccountLister: tc.lister,
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.NotNil(t, err, tc.errorMessage)
+			assert.False(t, response.Allowed, "Should not allow the pod")
+			assert.Equal(t, int32(http.StatusInternalServerError), response.Result.Code, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateEphemeralContainers(t *testing.T) {
+	var testCases = []struct {
+		name                string
+		podAnnotation       map[string]string
+		ephemeralContainers []corev1.EphemeralContainer
+		volumes             []corev1.Volume
+		allowed             bool
+		injected            bool
+		path                string
+		errorMessage        string
+	}{
+		{
+			name:          "TestEmptyEphemeralContainers",
+			podAnnotation: map[string]string{},
+			allowed:       true,
+			injected:      true,
+			path:          "/spec/ephemeralContainers",
+			errorMessage:  "Should create the ephemeral container list",
+		},
+		{
+			name:                "TestExistingEphemeralContainers",
+			podAnnotation:       map[string]string{},
+			ephemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}}},
+			allowed:             true,
+			injected:            true,
+			path:                "/spec/ephemeralContainers/-",
+			errorMessage:        "Should append to the ephemeral container list",
+		},
+		{
+			name: "TestNotOptedIn",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationInjectEphemeralKey: "false",
+			},
+			allowed:      true,
+			injected:     false,
+			errorMessage: "Should not add an ephemeral sidecar to a pod that did not opt in",
+		},
+		{
+			name:                "TestEphemeralSidecarPresent",
+			podAnnotation:       map[string]string{},
+			ephemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: signingProxyWebhookEphemeralContainerName}}},
+			allowed:             true,
+			injected:            false,
+			errorMessage:        "Should not add a duplicate ephemeral sidecar",
+		},
+		{
+			name: "TestCABundleVolumePresent",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationCABundleConfigMapKey: "corporate-ca",
+			},
+			volumes:      []corev1.Volume{{Name: signingProxyWebhookCABundleVolumeName}},
+			allowed:      true,
+			injected:     true,
+			path:         "/spec/ephemeralContainers",
+			errorMessage: "Should mount a volume already defined in the pod",
+		},
+		{
+			name: "TestCABundleVolumeMissing",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationCABundleConfigMapKey: "corporate-ca",
+			},
+			allowed:      false,
+			errorMessage: "Should deny mounting a volume the pod does not define",
+		},
+		{
+			name: "TestTransparent",
+			podAnnotation: map[string]string{
+				signingProxyWebhookAnnotationTransparentKey: "true",
+			},
+			allowed:      false,
+			errorMessage: "Should deny transparent mode for ephemeral containers",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey:          "true",
+				signingProxyWebhookAnnotationHostKey:            "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationProbesKey:          "true",
+				signingProxyWebhookAnnotationStatusKey:          "injected",
+				signingProxyWebhookAnnotationInjectEphemeralKey: "true",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:          []corev1.Container{{Name: "app"}, {Name: signingProxyWebhookContainerName}},
+					EphemeralContainers: tc.ephemeralContainers,
+					Volumes:             tc.volumes,
+				},
+			}
+
+			admissionReview := newAdmissionReview(t, pod)
+			admissionReview.Request.Operation = v1beta1.Update
+			admissionReview.Request.SubResource = "ephemeralcontainers"
+
+			response, err := whsvr.mutate(context.Background(), admissionReview)
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				return
+			}
+
+			patch := decodePatch(t, response)
+
+			if !tc.injected {
+				assert.Empty(t, patch, tc.errorMessage)
+				return
+			}
+
+			assert.Len(t, patch, 1, "Should only patch the ephemeral containers")
+
+			var container corev1.EphemeralContainer
+			if tc.path == "/spec/ephemeralContainers" {
+				var containers []corev1.EphemeralContainer
+				assert.True(t, findPatchValue(t, patch, tc.path, &containers), tc.errorMessage)
+				assert.Len(t, containers, 1, tc.errorMessage)
+				container = containers[0]
+			} else {
+				assert.True(t, findPatchValue(t, patch, tc.path, &container), tc.errorMessage)
+			}
+
+			assert.Equal(t, signingProxyWebhookEphemeralContainerName, container.Name, "Should not reuse the sidecar container name")
+			assert.Equal(t, ":8006", argValue(container.Args, "--port"), "Should not listen on the port of the sidecar container")
+			assert.Empty(t, container.Ports, "Should not set ports on an ephemeral container")
+			assert.Nil(t, container.StartupProbe, "Should not set probes on an ephemeral container")
+		})
+	}
+}
+
+func TestWebhookServer_HandlerResponseHeaders(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		host         string
+		allowed      bool
+		errorMessage string
+	}{
+		{
+			name:         "TestInjected",
+			host:         "aps-workspaces.us-west-2.amazonaws.com",
+			allowed:      true,
+			errorMessage: "Should answer an injected pod with a JSON AdmissionReview",
+		},
+		{
+			name:         "TestDenied",
+			host:         "localhost",
+			allowed:      false,
+			errorMessage: "Should answer a denied pod with a JSON AdmissionReview",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   tc.host,
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(newAdmissionReviewBody(t, "admission.k8s.io/v1", pod)))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+
+			whsvr.Handler(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code, tc.errorMessage)
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), tc.errorMessage)
+
+			var review v1beta1.AdmissionReview
+			assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review), "Should unmarshal AdmissionReview")
+			assert.Equal(t, tc.allowed, review.Response.Allowed, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutatePortName(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		podAnnotation map[string]string
+		allowed       bool
+		expected      string
+		errorMessage  string
+	}{
+		{
+			name:          "TestDefaultPortName",
+			podAnnotation: map[string]string{},
+			allowed:       true,
+			expected:      "sigv4-proxy",
+			errorMessage:  "Should name the port sigv4-proxy by default",
+		},
+		{
+			name:          "TestPortName",
+			podAnnotation: map[string]string{signingProxyWebhookAnnotationPortNameKey: "aws-signing"},
+			allowed:       true,
+			expected:      "aws-signing",
+			errorMessage:  "Should name the port from the annotation",
+		},
+		{
+			name:          "TestPortNameTooLong",
+			podAnnotation: map[string]string{signingProxyWebhookAnnotationPortNameKey: "aws-sigv4-signing-proxy"},
+			allowed:       false,
+			errorMessage:  "Should deny a port name longer than 15 characters",
+		},
+		{
+			name:          "TestPortNameInvalid",
+			podAnnotation: map[string]string{signingProxyWebhookAnnotationPortNameKey: "Sigv4_Proxy"},
+			allowed:       false,
+			errorMessage:  "Should deny a port name that is not an IANA service name",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.podAnnotation {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if tc.allowed {
+				var container corev1.Container
+				assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should add the sidecar")
+				assert.Equal(t, []corev1.ContainerPort{{Name: tc.expected, ContainerPort: 8005}}, container.Ports, tc.errorMessage)
+			} else {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationPortNameKey, "Should name the offending annotation")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateNilRequest(t *testing.T) {
+	whsvr := &WebhookServer{
+		server:          nil,
+		namespaceClient: newNamespaceClient(map[string]string{}),
+	}
+
+	t.Run("TestMutate", func(t *testing.T) {
+		response, err := whsvr.mutate(context.Background(), &v1beta1.AdmissionReview{})
+		assert.NotNil(t, err, "Should return an error")
+		assert.False(t, response.Allowed, "Should not allow the request")
+		assert.Equal(t, int32(http.StatusBadRequest), response.Result.Code, "Should report a bad request")
+	})
+
+	t.Run("TestHandler", func(t *testing.T) {
+		body := []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`)
+		request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+
+		assert.NotPanics(t, func() { whsvr.Handler(recorder, request) }, "Should not panic")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "Should reject the AdmissionReview")
+	})
+}
+
+func TestWebhookServer_mutateNativeSidecars(t *testing.T) {
+	var testCases = []struct {
+		name                   string
+		nativeSidecars         bool
+		transparent            bool
+		initContainers         []corev1.Container
+		expectedInitContainers []string
+		errorMessage           string
+	}{
+		{
+			name:           "TestLegacySidecar",
+			nativeSidecars: false,
+			errorMessage:   "Should add the sidecar to the containers without a restartPolicy",
+		},
+		{
+			name:                   "TestNativeSidecar",
+			nativeSidecars:         true,
+			expectedInitContainers: []string{signingProxyWebhookContainerName},
+			errorMessage:           "Should add the sidecar to the init containers with restartPolicy Always",
+		},
+		{
+			name:                   "TestNativeSidecarExistingInitContainers",
+			nativeSidecars:         true,
+			initContainers:         []corev1.Container{{Name: "migrate"}},
+			expectedInitContainers: []string{signingProxyWebhookContainerName, "migrate"},
+			errorMessage:           "Should start the sidecar before the existing init containers",
+		},
+		{
+			name:                   "TestNativeSidecarTransparent",
+			nativeSidecars:         true,
+			transparent:            true,
+			expectedInitContainers: []string{signingProxyWebhookInitContainerName, signingProxyWebhookContainerName},
+			errorMessage:           "Should start the sidecar after the transparent init container",
+		},
+		{
+			name:                   "TestNativeSidecarTransparentExistingInitContainers",
+			nativeSidecars:         true,
+			transparent:            true,
+			initContainers:         []corev1.Container{{Name: "migrate"}},
+			expectedInitContainers: []string{signingProxyWebhookInitContainerName, signingProxyWebhookContainerName, "migrate"},
+			errorMessage:           "Should start the sidecar between the transparent and the existing init containers",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{NativeSidecars: tc.nativeSidecars},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey:      "true",
+					signingProxyWebhookAnnotationHostKey:        "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationTransparentKey: strconv.FormatBool(tc.transparent),
+				}},
+				Spec: corev1.PodSpec{
+					InitContainers: tc.initContainers,
+					Containers:     []corev1.Container{{Name: "app"}},
+				},
+			}
+
+			review := newAdmissionReview(t, pod)
+
+			response, err := whsvr.mutate(context.Background(), review)
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			patch := decodePatch(t, response)
+
+			var container corev1.Container
+
+			if !tc.nativeSidecars {
+				assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), tc.errorMessage)
+				assert.Nil(t, container.RestartPolicy, tc.errorMessage)
+				assert.False(t, findPatchValue(t, patch, "/spec/initContainers", &[]corev1.Container{}), "Should not patch the init containers")
+
+				raw, err := json.Marshal(container)
+				assert.Nil(t, err, "Should marshal the container")
+				assert.NotContains(t, string(raw), "restartPolicy", "Should omit the restartPolicy field")
+				return
+			}
+
+			assert.False(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should not add the sidecar to the containers")
+
+			jsonPatch, err := jsonpatch.DecodePatch(response.Patch)
+			assert.Nil(t, err, "Should decode the patch")
+
+			patched, err := jsonPatch.Apply(review.Request.Object.Raw)
+			assert.Nil(t, err, "Should apply the patch")
+
+			var patchedPod corev1.Pod
+			assert.Nil(t, json.Unmarshal(patched, &patchedPod), "Should decode the patched pod")
+
+			var order []string
+
+			for _, initContainer := range patchedPod.Spec.InitContainers {
+				order = append(order, initContainer.Name)
+
+				if initContainer.Name == signingProxyWebhookContainerName {
+					assert.Equal(t, corev1.ContainerRestartPolicyAlways, *initContainer.RestartPolicy, tc.errorMessage)
+				} else {
+					assert.Nil(t, initContainer.RestartPolicy, "Should keep the other init containers regular init containers")
+				}
+			}
+
+			assert.Equal(t, tc.expectedInitContainers, order, tc.errorMessage)
+		})
+	}
+}
+
+func TestCheckNativeSidecarSupport(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		gitVersion   string
+		valid        bool
+		errorMessage string
+	}{
+		{
+			name:         "TestSupported",
+			gitVersion:   "v1.29.2-eks-7f9249a",
+			valid:        true,
+			errorMessage: "Should accept Kubernetes 1.29",
+		},
+		{
+			name:         "TestUnsupported",
+			gitVersion:   "v1.28.6",
+			valid:        false,
+			errorMessage: "Should reject Kubernetes 1.28",
+		},
+		{
+			name:         "TestUnparseable",
+			gitVersion:   "unknown",
+			valid:        false,
+			errorMessage: "Should reject an unparseable version",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &k8sversion.Info{GitVersion: tc.gitVersion}
+
+			err := CheckNativeSidecarSupport(client.Discovery())
+
+			if tc.valid {
+				assert.Nil(t, err, tc.errorMessage)
+			} else {
+				assert.NotNil(t, err, tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateNamespaceFailOpen(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		nsLabels     map[string]string
+		allowed      bool
+		errorMessage string
+	}{
+		{
+			name:         "TestFailOpen",
+			nsLabels:     map[string]string{"sidecar-fail-open": "true"},
+			allowed:      true,
+			errorMessage: "Should allow the pod unmodified when the namespace fails open",
+		},
+		{
+			name:         "TestFailClosed",
+			nsLabels:     map[string]string{"sidecar-fail-open": "false"},
+			allowed:      false,
+			errorMessage: "Should deny the pod when the namespace fails closed",
+		},
+		{
+			name:         "TestLabelAbsent",
+			nsLabels:     map[string]string{},
+			allowed:      false,
+			errorMessage: "Should deny the pod by default",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(tc.nsLabels),
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey:     "true",
+					signingProxyWebhookAnnotationHostKey:       "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationCPURequestKey: "lots",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+			assert.Empty(t, response.Patch, "Should not modify the pod")
+
+			if tc.allowed {
+				assert.Len(t, response.Warnings, 1, "Should warn that the sidecar was not injected")
+				assert.Contains(t, response.Warnings[0], signingProxyWebhookAnnotationCPURequestKey, "Should explain why the sidecar was not injected")
+			} else {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationCPURequestKey, "Should explain the denial")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersService(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		annotations    map[string]string
+		nsLabels       map[string]string
+		defaultRegion  string
+		valid          bool
+		expectedHost   string
+		expectedName   string
+		expectedRegion string
+		errorMessage   string
+	}{
+		{
+			name: "TestAPSWorkspaces",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "aps-workspaces",
+				signingProxyWebhookAnnotationRegionKey:  "us-west-2",
+			},
+			valid:          true,
+			expectedHost:   "aps-workspaces.us-west-2.amazonaws.com",
+			expectedName:   "aps",
+			expectedRegion: "us-west-2",
+			errorMessage:   "Should sign Amazon Managed Prometheus workspace requests as aps",
+		},
+		{
+			name:           "TestOpenSearchNamespaceLabels",
+			nsLabels:       map[string]string{"sidecar-service": "es", "sidecar-region": "eu-west-1"},
+			valid:          true,
+			expectedHost:   "es.eu-west-1.amazonaws.com",
+			expectedName:   "es",
+			expectedRegion: "eu-west-1",
+			errorMessage:   "Should read the service from the namespace labels",
+		},
+		{
+			name: "TestChinaRegion",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "s3",
+				signingProxyWebhookAnnotationRegionKey:  "cn-north-1",
+			},
+			valid:          true,
+			expectedHost:   "s3.cn-north-1.amazonaws.com.cn",
+			expectedName:   "s3",
+			expectedRegion: "cn-north-1",
+			errorMessage:   "Should use the China partition domain",
+		},
+		{
+			name: "TestGovCloudRegion",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "logs",
+				signingProxyWebhookAnnotationRegionKey:  "us-gov-west-1",
+			},
+			valid:          true,
+			expectedHost:   "logs.us-gov-west-1.amazonaws.com",
+			expectedName:   "logs",
+			expectedRegion: "us-gov-west-1",
+			errorMessage:   "Should use the regional GovCloud domain",
+		},
+		{
+			name: "TestDefaultRegion",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "logs",
+			},
+			defaultRegion:  "us-east-1",
+			valid:          true,
+			expectedHost:   "logs.us-east-1.amazonaws.com",
+			expectedName:   "logs",
+			expectedRegion: "us-east-1",
+			errorMessage:   "Should fall back to the default region",
+		},
+		{
+			name: "TestHostOverridesService",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "s3",
+				signingProxyWebhookAnnotationHostKey:    "aps-workspaces.us-east-1.amazonaws.com",
+			},
+			valid:          true,
+			expectedHost:   "aps-workspaces.us-east-1.amazonaws.com",
+			expectedName:   "aps-workspaces",
+			expectedRegion: "us-east-1",
+			errorMessage:   "Should prefer an explicit host over the service",
+		},
+		{
+			name: "TestUnknownService",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "route66",
+				signingProxyWebhookAnnotationRegionKey:  "us-west-2",
+			},
+			valid:        false,
+			errorMessage: "Should reject a service missing from the catalog",
+		},
+		{
+			name: "TestServiceWithoutRegion",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationServiceKey: "sqs",
+			},
+			valid:        false,
+			errorMessage: "Should reject a service without a region",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+				config:          Config{DefaultRegion: tc.defaultRegion},
+			}
+
+			host, name, region, _, _, err := whsvr.getUpstreamEndpointParameters(tc.nsLabels, &metav1.ObjectMeta{Annotations: tc.annotations})
+
+			if !tc.valid {
+				assert.NotNil(t, err, tc.errorMessage)
+				return
+			}
+
+			assert.Nil(t, err, tc.errorMessage)
+			assert.Equal(t, tc.expectedHost, host, tc.errorMessage)
+			assert.Equal(t, tc.expectedName, name, tc.errorMessage)
+			assert.Equal(t, tc.expectedRegion, region, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateResolvedConfig(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		annotate     bool
+		errorMessage string
+	}{
+		{
+			name:         "TestAnnotateResolvedConfig",
+			annotate:     true,
+			errorMessage: "Should write the resolved config annotation",
+		},
+		{
+			name:         "TestDisabled",
+			annotate:     false,
+			errorMessage: "Should not write the resolved config annotation unless enabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("AWS-SIGV4-PROXY-IMAGE", "")
+
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{AnnotateResolvedConfig: tc.annotate},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey:  "true",
+					signingProxyWebhookAnnotationHostKey:    "aps-workspaces.us-west-2.amazonaws.com",
+					signingProxyWebhookAnnotationRoleArnKey: "arn:aws:iam::123456789012:role/proxy",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			var value string
+			found := findPatchValue(t, decodePatch(t, response), "/metadata/annotations/"+escapeJSONPointer(signingProxyWebhookAnnotationResolvedConfigKey), &value)
+			assert.Equal(t, tc.annotate, found, tc.errorMessage)
+
+			if tc.annotate {
+				var resolved resolvedConfig
+				assert.Nil(t, json.Unmarshal([]byte(value), &resolved), "Should write JSON")
+				assert.Equal(t, resolvedConfig{
+					Host:              "aps-workspaces.us-west-2.amazonaws.com",
+					Name:              "aps-workspaces",
+					Region:            "us-west-2",
+					UpstreamURLScheme: "https",
+					RoleArn:           "arn:aws:iam::123456789012:role/proxy",
+					Image:             whsvr.getProxyImage(),
+				}, resolved, tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutatePreset(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		annotations    map[string]string
+		nsLabels       map[string]string
+		allowed        bool
+		expectedName   string
+		expectedRegion string
+		errorMessage   string
+	}{
+		{
+			name: "TestAPSRemoteWrite",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationPresetKey: "aps-remote-write",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+			allowed:        true,
+			expectedName:   "aps",
+			expectedRegion: "us-west-2",
+			errorMessage:   "Should sign remote-write requests as aps",
+		},
+		{
+			name: "TestAPSRemoteWriteVPCEndpoint",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationPresetKey: "aps-remote-write",
+				signingProxyWebhookAnnotationHostKey:   "vpce-0123456789abcdef0-abcdefgh.aps-workspaces.eu-west-1.vpce.amazonaws.com",
+			},
+			allowed:        true,
+			expectedName:   "aps",
+			expectedRegion: "eu-west-1",
+			errorMessage:   "Should find the region anywhere in the host",
+		},
+		{
+			name: "TestAPSRemoteWriteNamespaceLabel",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-east-1.amazonaws.com",
+			},
+			nsLabels:       map[string]string{"sidecar-preset": "aps-remote-write"},
+			allowed:        true,
+			expectedName:   "aps",
+			expectedRegion: "us-east-1",
+			errorMessage:   "Should read the preset from the namespace labels",
+		},
+		{
+			name: "TestExplicitParametersOverridePreset",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationPresetKey: "aps-remote-write",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationNameKey:   "aps-workspaces",
+				signingProxyWebhookAnnotationRegionKey: "us-east-2",
+			},
+			allowed:        true,
+			expectedName:   "aps-workspaces",
+			expectedRegion: "us-east-2",
+			errorMessage:   "Should prefer the name and region annotations over the preset",
+		},
+		{
+			name: "TestUnknownPreset",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationPresetKey: "aps-query",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+			allowed:      false,
+			errorMessage: "Should reject an unknown preset",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(tc.nsLabels),
+			}
+
+			annotations := map[string]string{signingProxyWebhookAnnotationInjectKey: "true"}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				return
+			}
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), tc.errorMessage)
+			assert.Equal(t, tc.expectedName, argValue(container.Args, "--name"), tc.errorMessage)
+			assert.Equal(t, tc.expectedRegion, argValue(container.Args, "--region"), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateStrictInject(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		strict       bool
+		annotations  map[string]string
+		nsLabels     map[string]string
+		allowed      bool
+		injected     bool
+		errorMessage string
+	}{
+		{
+			name:         "TestStrictMissingHost",
+			strict:       true,
+			annotations:  map[string]string{signingProxyWebhookAnnotationInjectKey: "true"},
+			allowed:      false,
+			errorMessage: "Should deny a pod requesting injection without a host",
+		},
+		{
+			name:         "TestNonStrictMissingHost",
+			strict:       false,
+			annotations:  map[string]string{signingProxyWebhookAnnotationInjectKey: "true"},
+			allowed:      true,
+			injected:     false,
+			errorMessage: "Should skip a pod requesting injection without a host",
+		},
+		{
+			name:         "TestStrictNamespaceHost",
+			strict:       true,
+			annotations:  map[string]string{signingProxyWebhookAnnotationInjectKey: "true"},
+			nsLabels:     map[string]string{"sidecar-host": "aps-workspaces.us-west-2.amazonaws.com"},
+			allowed:      true,
+			injected:     true,
+			errorMessage: "Should inject with the host from the namespace label",
+		},
+		{
+			name:         "TestStrictNoInjectAnnotation",
+			strict:       true,
+			annotations:  map[string]string{},
+			allowed:      true,
+			injected:     false,
+			errorMessage: "Should skip a pod not requesting injection",
+		},
+		{
+			name:         "TestStrictNamespaceFailOpen",
+			strict:       true,
+			annotations:  map[string]string{signingProxyWebhookAnnotationInjectKey: "true"},
+			nsLabels:     map[string]string{"sidecar-fail-open": "true"},
+			allowed:      true,
+			injected:     false,
+			errorMessage: "Should allow the pod in a namespace failing open",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(tc.nsLabels),
+				config:          Config{StrictInject: tc.strict},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationHostKey, "Should name the missing annotation")
+				return
+			}
+
+			var container corev1.Container
+			assert.Equal(t, tc.injected, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateLimitRangeDefaults(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	assert.Nil(t, indexer.Add(&corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testNamespace", Name: "limits"},
+		Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{
+			{
+				Type: corev1.LimitTypePod,
+				Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+			},
+			{
+				Type:           corev1.LimitTypeContainer,
+				Min:            corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+				DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				Default: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+			},
+		}},
+	}), "Should add the LimitRange")
+
+	var testCases = []struct {
+		name              string
+		annotations       map[string]string
+		defaultResources  corev1.ResourceRequirements
+		expectedResources corev1.ResourceRequirements
+		errorMessage      string
+	}{
+		{
+			name: "TestLimitRangeDefaults",
+			expectedResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+			},
+			errorMessage: "Should default the requests and limits from the LimitRange",
+		},
+		{
+			name:        "TestAnnotationsTakePrecedence",
+			annotations: map[string]string{signingProxyWebhookAnnotationCPURequestKey: "200m"},
+			expectedResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+			},
+			errorMessage: "Should not apply the LimitRange to a sidecar with resource annotations",
+		},
+		{
+			name: "TestDefaultResourcesTakePrecedence",
+			defaultResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+			expectedResources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+			errorMessage: "Should not apply the LimitRange to a sidecar with default resources",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:           nil,
+				namespaceClient:  newNamespaceClient(map[string]string{}),
+				config:           Config{LimitRangeDefaults: true, DefaultResources: tc.defaultResources},
+				limitRangeLister: corelisters.NewLimitRangeLister(indexer),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+			assert.True(t, apiequality.Semantic.DeepEqual(tc.expectedResources, container.Resources), "%s: got %v", tc.errorMessage, container.Resources)
+		})
+	}
+
+	t.Run("TestNoLimitRange", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			limitRangeLister: corelisters.NewLimitRangeLister(indexer),
+		}
+
+		resources, err := whsvr.getLimitRangeResources("other")
+		assert.Nil(t, err, "Should succeed")
+		assert.Nil(t, resources, "Should not set resources without a LimitRange")
+	})
+}
+
+func TestWebhookServer_mutateResourceQuota(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	assert.Nil(t, indexer.Add(&corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testNamespace", Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:  resource.MustParse("2"),
+				corev1.ResourceLimitsMemory: resource.MustParse("1Gi"),
+			},
+			Used: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:  resource.MustParse("1500m"),
+				corev1.ResourceLimitsMemory: resource.MustParse("512Mi"),
+			},
+		},
+	}), "Should add the ResourceQuota")
+	assert.Nil(t, indexer.Add(&corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testNamespace", Name: "best-effort"},
+		Spec:       corev1.ResourceQuotaSpec{Scopes: []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("0")},
+		},
+	}), "Should add the scoped ResourceQuota")
+
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		appResources corev1.ResourceRequirements
+		allowed      bool
+		errorMessage string
+	}{
+		{
+			name:         "TestWithinQuota",
+			annotations:  map[string]string{signingProxyWebhookAnnotationCPURequestKey: "100m", signingProxyWebhookAnnotationMemoryLimitKey: "128Mi"},
+			appResources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")}},
+			allowed:      true,
+			errorMessage: "Should allow a sidecar within the quota",
+		},
+		{
+			name:         "TestCPUOverQuota",
+			annotations:  map[string]string{signingProxyWebhookAnnotationCPURequestKey: "400m"},
+			appResources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")}},
+			allowed:      false,
+			errorMessage: "Should deny a sidecar pushing the pod over the CPU quota",
+		},
+		{
+			name:         "TestMemoryLimitOverQuota",
+			annotations:  map[string]string{signingProxyWebhookAnnotationMemoryLimitKey: "1Gi"},
+			allowed:      false,
+			errorMessage: "Should deny a sidecar pushing the pod over the memory limit quota",
+		},
+		{
+			name:         "TestPodOverQuota",
+			annotations:  map[string]string{signingProxyWebhookAnnotationCPURequestKey: "100m"},
+			appResources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+			allowed:      true,
+			errorMessage: "Should leave a pod over the quota without the sidecar to the API server",
+		},
+		{
+			name:         "TestNoResources",
+			appResources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}},
+			allowed:      true,
+			errorMessage: "Should allow a sidecar without resources",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:              nil,
+				namespaceClient:     newNamespaceClient(map[string]string{}),
+				config:              Config{CheckResourceQuota: true},
+				resourceQuotaLister: corelisters.NewResourceQuotaLister(indexer),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: tc.appResources}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, "exceeds ResourceQuota compute", tc.errorMessage)
+			}
+		})
+	}
+
+	t.Run("TestWorkloadTemplate", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:              nil,
+			namespaceClient:     newNamespaceClient(map[string]string{}),
+			config:              Config{CheckResourceQuota: true},
+			resourceQuotaLister: corelisters.NewResourceQuotaLister(indexer),
+		}
+
+		template := newPodTemplate(map[string]string{
+			signingProxyWebhookAnnotationInjectKey:     "true",
+			signingProxyWebhookAnnotationHostKey:       "aps-workspaces.us-west-2.amazonaws.com",
+			signingProxyWebhookAnnotationCPURequestKey: "400m",
+		})
+		template.Spec.Containers[0].Resources = corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")}}
+
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "collector", Namespace: "testNamespace"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newWorkloadAdmissionReview(t, "deployments", deployment))
+		assert.Nil(t, err, "Should not return an error")
+		assert.True(t, response.Allowed, "Should not check a workload template against the quota")
+		assert.NotEmpty(t, response.Patch, "Should inject the sidecar into the template")
+	})
+}
+
+func TestWebhookServer_getUpstreamEndpointParametersPort(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		annotations    map[string]string
+		expectedHost   string
+		expectedName   string
+		expectedRegion string
+		errorMessage   string
+	}{
+		{
+			name: "TestHostWithPort",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationHostKey:   "myservice.example.com:8443",
+				signingProxyWebhookAnnotationRegionKey: "us-west-2",
+			},
+			expectedHost:   "myservice.example.com:8443",
+			expectedName:   "myservice",
+			expectedRegion: "us-west-2",
+			errorMessage:   "Should keep the port in the host",
+		},
+		{
+			name: "TestRegionalHostWithPort",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-east-1.amazonaws.com:443",
+			},
+			expectedHost:   "aps-workspaces.us-east-1.amazonaws.com:443",
+			expectedName:   "aps-workspaces",
+			expectedRegion: "us-east-1",
+			errorMessage:   "Should derive the name and region from the hostname only",
+		},
+		{
+			name: "TestTrailingDotHostWithPort",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-east-1.amazonaws.com.:8443",
+			},
+			expectedHost:   "aps-workspaces.us-east-1.amazonaws.com:8443",
+			expectedName:   "aps-workspaces",
+			expectedRegion: "us-east-1",
+			errorMessage:   "Should strip the trailing dot of the hostname",
+		},
+		{
+			name: "TestHostWithoutPort",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-east-1.amazonaws.com",
+			},
+			expectedHost:   "aps-workspaces.us-east-1.amazonaws.com",
+			expectedName:   "aps-workspaces",
+			expectedRegion: "us-east-1",
+			errorMessage:   "Should leave a host without a port unchanged",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: nil,
+			}
+
+			host, name, region, _, _, err := whsvr.getUpstreamEndpointParameters(map[string]string{}, &metav1.ObjectMeta{Annotations: tc.annotations})
+			assert.Nil(t, err, tc.errorMessage)
+			assert.Equal(t, tc.expectedHost, host, tc.errorMessage)
+			assert.Equal(t, tc.expectedName, name, tc.errorMessage)
+			assert.Equal(t, tc.expectedRegion, region, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestHostArgKeepsPort", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "myservice.example.com:8443",
+				signingProxyWebhookAnnotationRegionKey: "us-west-2",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+
+		var container corev1.Container
+		assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+		assert.Equal(t, "myservice.example.com:8443", argValue(container.Args, "--host"), "Should pass the port to the proxy")
+		assert.Equal(t, "myservice", argValue(container.Args, "--name"), "Should derive the name from the hostname")
+	})
+}
+
+func TestWebhookServer_mutateMeshExclusion(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		meshes       []string
+		annotations  map[string]string
+		expected     map[string]string
+		errorMessage string
+	}{
+		{
+			name:   "TestIstio",
+			meshes: []string{"istio"},
+			expected: map[string]string{
+				"traffic.sidecar.istio.io/excludeInboundPorts":  "8005",
+				"traffic.sidecar.istio.io/excludeOutboundPorts": "8005",
+			},
+			errorMessage: "Should exclude the proxy port from Istio",
+		},
+		{
+			name:   "TestLinkerdExistingPorts",
+			meshes: []string{"linkerd"},
+			annotations: map[string]string{
+				"config.linkerd.io/skip-outbound-ports": "3306, 8005",
+				"config.linkerd.io/skip-inbound-ports":  "9090",
+			},
+			expected: map[string]string{
+				"config.linkerd.io/skip-inbound-ports":  "9090,8005",
+				"config.linkerd.io/skip-outbound-ports": "3306, 8005",
+			},
+			errorMessage: "Should add the proxy port to the ports the pod already skips",
+		},
+		{
+			name:         "TestDisabled",
+			meshes:       nil,
+			expected:     map[string]string{},
+			errorMessage: "Should not add mesh annotations unless enabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{MeshExclusion: tc.meshes},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			patched := map[string]string{}
+
+			for _, operation := range decodePatch(t, response) {
+				key := strings.NewReplacer("~1", "/", "~0", "~").Replace(strings.TrimPrefix(operation.Path, "/metadata/annotations/"))
+
+				if strings.Contains(key, "istio.io") || strings.Contains(key, "linkerd.io") {
+					patched[key] = operation.Value.(string)
+				}
+			}
+
+			assert.Equal(t, tc.expected, patched, tc.errorMessage)
+		})
+	}
+}
+
+func TestValidateMeshExclusion(t *testing.T) {
+	assert.Nil(t, ValidateMeshExclusion([]string{"istio", "linkerd"}), "Should accept the supported meshes")
+	assert.NotNil(t, ValidateMeshExclusion([]string{"consul"}), "Should reject an unsupported mesh")
+}
+
+func TestWebhookServer_BuildPatch(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		nsLabels     map[string]string
+		injected     bool
+		valid        bool
+		errorMessage string
+	}{
+		{
+			name: "TestInject",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			},
+			injected:     true,
+			valid:        true,
+			errorMessage: "Should inject a pod with the inject annotation",
+		},
+		{
+			name:         "TestInjectNamespaceLabels",
+			nsLabels:     map[string]string{"sidecar-inject": "true", "sidecar-host": "aps-workspaces.us-west-2.amazonaws.com"},
+			injected:     true,
+			valid:        true,
+			errorMessage: "Should inject a pod in a labeled namespace",
+		},
+		{
+			name:         "TestSkipWithoutAnnotations",
+			injected:     false,
+			valid:        true,
+			errorMessage: "Should skip a pod without annotations",
+		},
+		{
+			name: "TestSkipInjected",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationStatusKey: "injected",
+			},
+			injected:     false,
+			valid:        true,
+			errorMessage: "Should skip a pod that is already injected",
+		},
+		{
+			name: "TestInvalidHost",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "localhost",
+			},
+			valid:        false,
+			errorMessage: "Should return an error for an invalid host",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{}
+
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "sidecar", Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			patch, err := whsvr.BuildPatch(pod, tc.nsLabels)
+
+			if !tc.valid {
+				assert.NotNil(t, err, tc.errorMessage)
+				return
+			}
+
+			assert.Nil(t, err, tc.errorMessage)
+
+			var container corev1.Container
+			assert.Equal(t, tc.injected, findPatchValue(t, patch, "/spec/containers/-", &container), tc.errorMessage)
+
+			if tc.injected {
+				assert.Equal(t, signingProxyWebhookContainerName, container.Name, tc.errorMessage)
+				assert.Equal(t, "aps-workspaces.us-west-2.amazonaws.com", argValue(container.Args, "--host"), tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateGOMAXPROCS(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		enabled      bool
+		cpuLimit     string
+		expected     string
+		errorMessage string
+	}{
+		{
+			name:         "TestFractionalLimit",
+			enabled:      true,
+			cpuLimit:     "400m",
+			expected:     "1",
+			errorMessage: "Should round a limit below one core up to 1",
+		},
+		{
+			name:         "TestTwoCores",
+			enabled:      true,
+			cpuLimit:     "2",
+			expected:     "2",
+			errorMessage: "Should set GOMAXPROCS to the number of cores",
+		},
+		{
+			name:         "TestRoundedDown",
+			enabled:      true,
+			cpuLimit:     "2500m",
+			expected:     "2",
+			errorMessage: "Should round down to whole cores",
+		},
+		{
+			name:         "TestNoLimit",
+			enabled:      true,
+			expected:     "",
+			errorMessage: "Should not set GOMAXPROCS without a CPU limit",
+		},
+		{
+			name:         "TestDisabled",
+			enabled:      false,
+			cpuLimit:     "2",
+			expected:     "",
+			errorMessage: "Should not set GOMAXPROCS unless enabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{SetGOMAXPROCS: tc.enabled},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			if tc.cpuLimit != "" {
+				annotations[signingProxyWebhookAnnotationCPULimitKey] = tc.cpuLimit
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+
+			var gomaxprocs string
+
+			for _, env := range container.Env {
+				if env.Name == "GOMAXPROCS" {
+					gomaxprocs = env.Value
+				}
+			}
+
+			assert.Equal(t, tc.expected, gomaxprocs, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateAnnotationEnv(t *testing.T) {
+	annotationEnv := map[string]string{
+		"team.example.com/team":  "SIDECAR_TEAM",
+		"app.kubernetes.io/name": "SIDECAR_APP",
+	}
+
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		expected     map[string]string
+		errorMessage string
+	}{
+		{
+			name: "TestMappedAnnotations",
+			annotations: map[string]string{
+				"team.example.com/team":  "observability",
+				"app.kubernetes.io/name": "collector",
+			},
+			expected:     map[string]string{"SIDECAR_TEAM": "observability", "SIDECAR_APP": "collector"},
+			errorMessage: "Should set an env var for each mapped annotation",
+		},
+		{
+			name:         "TestMissingAnnotation",
+			annotations:  map[string]string{"team.example.com/team": "observability"},
+			expected:     map[string]string{"SIDECAR_TEAM": "observability"},
+			errorMessage: "Should skip mapped annotations the pod does not have",
+		},
+		{
+			name:         "TestUnmappedAnnotation",
+			annotations:  map[string]string{"team.example.com/owner": "someone"},
+			expected:     map[string]string{},
+			errorMessage: "Should not copy unmapped annotations",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{AnnotationEnv: annotationEnv},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+
+			actual := map[string]string{}
+
+			for _, env := range container.Env {
+				if strings.HasPrefix(env.Name, "SIDECAR_") {
+					actual[env.Name] = env.Value
+				}
+			}
+
+			assert.Equal(t, tc.expected, actual, tc.errorMessage)
+		})
+	}
+}
+
+func TestParseAnnotationEnv(t *testing.T) {
+	annotationEnv, err := ParseAnnotationEnv("team.example.com/team=SIDECAR_TEAM, app.kubernetes.io/name=SIDECAR_APP,")
+	assert.Nil(t, err, "Should parse the mappings")
+	assert.Equal(t, map[string]string{"team.example.com/team": "SIDECAR_TEAM", "app.kubernetes.io/name": "SIDECAR_APP"}, annotationEnv)
+
+	_, err = ParseAnnotationEnv("team.example.com/team")
+	assert.NotNil(t, err, "Should reject a mapping without an env var")
+
+	_, err = ParseAnnotationEnv("team.example.com/team=1TEAM")
+	assert.NotNil(t, err, "Should reject an invalid env var name")
+
+	_, err = ParseAnnotationEnv("not a key=SIDECAR_TEAM")
+	assert.NotNil(t, err, "Should reject an invalid annotation key")
+}
+
+func TestWebhookServer_mutateProxyLogLevel(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		logLevel     string
+		allowed      bool
+		expected     []string
+		errorMessage string
+	}{
+		{name: "TestDefault", allowed: true, errorMessage: "Should not add log flags without the annotation"},
+		{name: "TestInfo", logLevel: "info", allowed: true, errorMessage: "Should not add log flags at the info level"},
+		{name: "TestDebug", logLevel: "debug", allowed: true, expected: []string{"--log-failed-requests", "--log-signing-process"}, errorMessage: "Should log failed requests and signing at the debug level"},
+		{name: "TestTrace", logLevel: "TRACE", allowed: true, expected: []string{"--verbose"}, errorMessage: "Should enable verbose logs at the trace level, ignoring case"},
+		{name: "TestInvalid", logLevel: "loud", allowed: false, errorMessage: "Should reject an unknown log level"},
+	}
+
+	logFlags := []string{"--log-failed-requests", "--log-signing-process", "--verbose"}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			if tc.logLevel != "" {
+				annotations[signingProxyWebhookAnnotationProxyLogLevelKey] = tc.logLevel
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationProxyLogLevelKey, "Should name the offending annotation")
+				return
+			}
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+
+			var actual []string
+
+			for _, arg := range container.Args {
+				for _, flag := range logFlags {
+					if arg == flag {
+						actual = append(actual, arg)
+					}
+				}
+			}
+
+			assert.Equal(t, tc.expected, actual, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateUnixSocket(t *testing.T) {
+	t.Run("TestUnixSocket", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+			config:          Config{MeshExclusion: []string{"istio"}, UnixSocketFlag: "--unix-socket"},
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey:       "true",
+				signingProxyWebhookAnnotationHostKey:         "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationSharedVolumeKey: "app",
+				signingProxyWebhookAnnotationUnixSocketKey:   "proxy.sock",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+		assert.Nil(t, err, "Should succeed")
+		assert.True(t, response.Allowed, "Should allow the pod")
+
+		patch := decodePatch(t, response)
+		expectedMount := corev1.VolumeMount{Name: signingProxyWebhookSharedVolumeName, MountPath: signingProxyWebhookSharedVolumeDefaultPath}
+
+		var volumes []corev1.Volume
+		assert.True(t, findPatchValue(t, patch, "/spec/volumes", &volumes), "Should add the shared volume")
+		assert.Equal(t, []corev1.Volume{{
+			Name:         signingProxyWebhookSharedVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}}, volumes, "Should add an emptyDir volume for the socket")
+
+		var sidecar corev1.Container
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &sidecar), "Should add the sidecar")
+		assert.Equal(t, []corev1.VolumeMount{expectedMount}, sidecar.VolumeMounts, "Should mount the socket volume in the sidecar")
+		assert.Equal(t, signingProxyWebhookSharedVolumeDefaultPath+"/proxy.sock", argValue(sidecar.Args, "--unix-socket"), "Should listen on the socket")
+		assert.NotContains(t, sidecar.Args, "--port", "Should not pass the socket as a port")
+		assert.Empty(t, sidecar.Ports, "Should not expose a TCP port")
+
+		var appMounts []corev1.VolumeMount
+		assert.True(t, findPatchValue(t, patch, "/spec/containers/0/volumeMounts", &appMounts), "Should mount the socket volume in the app container")
+		assert.Equal(t, []corev1.VolumeMount{expectedMount}, appMounts)
+
+		var annotations map[string]string
+		if findPatchValue(t, patch, "/metadata/annotations", &annotations) {
+			assert.NotContains(t, annotations, "traffic.sidecar.istio.io/excludeInboundPorts", "Should not exclude a port from the mesh")
+		}
+	})
+
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		config       Config
+		errorMessage string
+	}{
+		{
+			name: "TestWithoutSocketFlag",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationSharedVolumeKey: "app",
+				signingProxyWebhookAnnotationUnixSocketKey:   "proxy.sock",
+			},
+			config:       Config{},
+			errorMessage: "Should reject a socket the proxy image cannot listen on",
+		},
+		{
+			name:         "TestWithoutSharedVolume",
+			annotations:  map[string]string{signingProxyWebhookAnnotationUnixSocketKey: "proxy.sock"},
+			config:       Config{UnixSocketFlag: "--unix-socket"},
+			errorMessage: "Should require a shared volume",
+		},
+		{
+			name: "TestSocketPath",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationSharedVolumeKey: "app",
+				signingProxyWebhookAnnotationUnixSocketKey:   "../proxy.sock",
+			},
+			config:       Config{UnixSocketFlag: "--unix-socket"},
+			errorMessage: "Should reject a socket name containing a path",
+		},
+		{
+			name: "TestWithProbes",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationSharedVolumeKey: "app",
+				signingProxyWebhookAnnotationUnixSocketKey:   "proxy.sock",
+				signingProxyWebhookAnnotationProbesKey:       "true",
+			},
+			config:       Config{UnixSocketFlag: "--unix-socket"},
+			errorMessage: "Should reject TCP probes of a unix socket",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          tc.config,
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.False(t, response.Allowed, tc.errorMessage)
+			assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationUnixSocketKey, "Should name the offending annotation")
+		})
+	}
+}
+
+func TestWebhookServer_mutateExposePort(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		exposePort   string
+		expected     []corev1.ContainerPort
+		errorMessage string
+	}{
+		{
+			name:         "TestDefault",
+			expected:     []corev1.ContainerPort{{Name: signingProxyWebhookPortDefaultName, ContainerPort: 8005}},
+			errorMessage: "Should declare the proxy port by default",
+		},
+		{
+			name:         "TestEnabled",
+			exposePort:   "true",
+			expected:     []corev1.ContainerPort{{Name: signingProxyWebhookPortDefaultName, ContainerPort: 8005}},
+			errorMessage: "Should declare the proxy port when enabled",
+		},
+		{
+			name:         "TestDisabled",
+			exposePort:   "false",
+			expected:     nil,
+			errorMessage: "Should omit the proxy port when disabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			if tc.exposePort != "" {
+				annotations[signingProxyWebhookAnnotationExposePortKey] = tc.exposePort
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+			assert.Equal(t, tc.expected, container.Ports, tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateDeprecationWarnings(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		nsLabels     map[string]string
+		expected     []string
+		errorMessage string
+	}{
+		{
+			name:         "TestAnnotations",
+			annotations:  map[string]string{signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-west-2.amazonaws.com"},
+			nsLabels:     map[string]string{},
+			expected:     nil,
+			errorMessage: "Should not warn about annotations",
+		},
+		{
+			name:         "TestHostLabel",
+			nsLabels:     map[string]string{signingProxyWebhookLabelHostKey: "aps-workspaces.us-west-2.amazonaws.com"},
+			expected:     []string{signingProxyWebhookLabelHostKey},
+			errorMessage: "Should warn about the host label",
+		},
+		{
+			name: "TestHostAndRegionLabels",
+			nsLabels: map[string]string{
+				signingProxyWebhookLabelHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookLabelRegionKey: "us-west-2",
+			},
+			expected:     []string{signingProxyWebhookLabelHostKey, signingProxyWebhookLabelRegionKey},
+			errorMessage: "Should warn about each deprecated label",
+		},
+		{
+			name:         "TestLabelOverridden",
+			annotations:  map[string]string{signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-west-2.amazonaws.com"},
+			nsLabels:     map[string]string{signingProxyWebhookLabelHostKey: "aps-workspaces.us-east-1.amazonaws.com"},
+			expected:     nil,
+			errorMessage: "Should not warn about labels overridden by annotations",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(tc.nsLabels),
+			}
+
+			annotations := map[string]string{signingProxyWebhookAnnotationInjectKey: "true"}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+			assert.NotEmpty(t, response.Patch, "Should inject the sidecar")
+
+			assert.Len(t, response.Warnings, len(tc.expected), tc.errorMessage)
+
+			for i, label := range tc.expected {
+				if i < len(response.Warnings) {
+					assert.Contains(t, response.Warnings[i], label, tc.errorMessage)
+					assert.Contains(t, response.Warnings[i], "deprecated", tc.errorMessage)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateTransportArgs(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		annotations  map[string]string
+		allowed      bool
+		idleTimeout  string
+		maxIdleConns string
+		errorMessage string
+	}{
+		{
+			name:         "TestDefault",
+			allowed:      true,
+			errorMessage: "Should not add transport flags without the annotations",
+		},
+		{
+			name: "TestBoth",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationIdleConnTimeoutKey: "90s",
+				signingProxyWebhookAnnotationMaxIdleConnsKey:    "200",
+			},
+			allowed:      true,
+			idleTimeout:  "1m30s",
+			maxIdleConns: "200",
+			errorMessage: "Should add both transport flags",
+		},
+		{
+			name:         "TestMaxIdleConnsOnly",
+			annotations:  map[string]string{signingProxyWebhookAnnotationMaxIdleConnsKey: "0"},
+			allowed:      true,
+			maxIdleConns: "0",
+			errorMessage: "Should add only the annotated flag",
+		},
+		{
+			name:         "TestInvalidTimeout",
+			annotations:  map[string]string{signingProxyWebhookAnnotationIdleConnTimeoutKey: "90"},
+			allowed:      false,
+			errorMessage: "Should reject a timeout without a unit",
+		},
+		{
+			name:         "TestNegativeTimeout",
+			annotations:  map[string]string{signingProxyWebhookAnnotationIdleConnTimeoutKey: "-1s"},
+			allowed:      false,
+			errorMessage: "Should reject a negative timeout",
+		},
+		{
+			name:         "TestInvalidMaxIdleConns",
+			annotations:  map[string]string{signingProxyWebhookAnnotationMaxIdleConnsKey: "many"},
+			allowed:      false,
+			errorMessage: "Should reject a non-numeric connection count",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				return
+			}
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+			assert.Equal(t, tc.idleTimeout, argValue(container.Args, "--transport-idle-conn-timeout"), tc.errorMessage)
+			assert.Equal(t, tc.maxIdleConns, argValue(container.Args, "--transport-max-idle-conns"), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateIdleConnectionTimeout(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		timeout      string
+		allowed      bool
+		expected     string
+		errorMessage string
+	}{
+		{name: "TestDefault", allowed: true, errorMessage: "Should not add the flag without the annotation"},
+		{name: "TestSeconds", timeout: "55s", allowed: true, expected: "55s", errorMessage: "Should add the parsed timeout"},
+		{name: "TestMinutes", timeout: " 2m ", allowed: true, expected: "2m0s", errorMessage: "Should add the parsed timeout"},
+		{name: "TestNoUnit", timeout: "60", allowed: false, errorMessage: "Should reject a timeout without a unit"},
+		{name: "TestNegative", timeout: "-5s", allowed: false, errorMessage: "Should reject a negative timeout"},
+		{name: "TestInvalid", timeout: "soon", allowed: false, errorMessage: "Should reject a malformed timeout"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			if tc.timeout != "" {
+				annotations[signingProxyWebhookAnnotationIdleTimeoutKey] = tc.timeout
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationIdleTimeoutKey, tc.errorMessage)
+				return
+			}
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+			assert.Equal(t, tc.expected, argValue(container.Args, "--idle-connection-timeout"), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateUpstreamPathPrefix(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		scheme         string
+		pathPrefix     string
+		allowed        bool
+		expectedScheme string
+		expectedPrefix string
+		errorMessage   string
+	}{
+		{name: "TestDefault", allowed: true, expectedScheme: "https", errorMessage: "Should not add the flag without the annotation"},
+		{name: "TestStage", scheme: "https", pathPrefix: "/prod", allowed: true, expectedScheme: "https", expectedPrefix: "/prod", errorMessage: "Should add the scheme and the path prefix"},
+		{name: "TestHTTP", scheme: "http", pathPrefix: " /v1/api/ ", allowed: true, expectedScheme: "http", expectedPrefix: "/v1/api", errorMessage: "Should add the trimmed path prefix"},
+		{name: "TestDefaultScheme", pathPrefix: "/prod", allowed: true, expectedScheme: "https", expectedPrefix: "/prod", errorMessage: "Should add the path prefix with the default scheme"},
+		{name: "TestRelative", pathPrefix: "prod", allowed: false, errorMessage: "Should reject a path prefix not starting with /"},
+		{name: "TestURL", pathPrefix: "https://example.com/prod", allowed: false, errorMessage: "Should reject a URL"},
+		{name: "TestQuery", pathPrefix: "/prod?stage=1", allowed: false, errorMessage: "Should reject a path prefix with a query"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "abcdef1234.execute-api.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationNameKey:   "execute-api",
+				signingProxyWebhookAnnotationRegionKey: "us-west-2",
+			}
+
+			if tc.scheme != "" {
+				annotations[signingProxyWebhookAnnotationSchemeKey] = tc.scheme
+			}
+
+			if tc.pathPrefix != "" {
+				annotations[signingProxyWebhookAnnotationPathPrefixKey] = tc.pathPrefix
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationPathPrefixKey, tc.errorMessage)
+				return
+			}
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+			assert.Equal(t, tc.expectedScheme, argValue(container.Args, "--upstream-url-scheme"), tc.errorMessage)
+			assert.Equal(t, tc.expectedPrefix, argValue(container.Args, "--upstream-path-prefix"), tc.errorMessage)
+		})
+	}
+}
+
+func TestWebhookServer_mutateMaxAnnotationLength(t *testing.T) {
+	longHost := "aps-workspaces.us-west-2.amazonaws.com/workspaces/ws-1234?" + strings.Repeat("query=value&", 20)
+
+	var testCases = []struct {
+		name         string
+		maxLength    int
+		annotations  map[string]string
+		allowed      bool
+		errorMessage string
+	}{
+		{
+			name:         "TestNormalHost",
+			maxLength:    256,
+			annotations:  map[string]string{signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-west-2.amazonaws.com"},
+			allowed:      true,
+			errorMessage: "Should allow a host within the limit",
+		},
+		{
+			name:         "TestLongHost",
+			maxLength:    256,
+			annotations:  map[string]string{signingProxyWebhookAnnotationHostKey: longHost},
+			allowed:      false,
+			errorMessage: "Should reject a host over the limit",
+		},
+		{
+			name:         "TestUnlimited",
+			maxLength:    0,
+			annotations:  map[string]string{signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-west-2.amazonaws.com", signingProxyWebhookAnnotationSignHeaderKey: "X-Team=" + strings.Repeat("a", 300)},
+			allowed:      true,
+			errorMessage: "Should not limit the length if not positive",
+		},
+		{
+			name:         "TestOtherAnnotations",
+			maxLength:    256,
+			annotations:  map[string]string{signingProxyWebhookAnnotationHostKey: "aps-workspaces.us-west-2.amazonaws.com", "example.com/description": strings.Repeat("a", 300)},
+			allowed:      true,
+			errorMessage: "Should only check the controller's annotations",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{MaxAnnotationLength: tc.maxLength},
+			}
+
+			annotations := map[string]string{signingProxyWebhookAnnotationInjectKey: "true"}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationHostKey, "Should name the annotation")
+				assert.Contains(t, response.Result.Message, "maximum of 256", "Should state the limit")
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateInjectedBy(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		version      string
+		annotations  map[string]string
+		injectedBy   bool
+		errorMessage string
+	}{
+		{
+			name:         "TestVersion",
+			version:      "v1.2.3",
+			annotations:  map[string]string{},
+			injectedBy:   true,
+			errorMessage: "Should record the controller version",
+		},
+		{
+			name:         "TestNoVersion",
+			annotations:  map[string]string{},
+			injectedBy:   false,
+			errorMessage: "Should not record an unknown controller version",
+		},
+		{
+			name:         "TestNoStatusAnnotation",
+			version:      "v1.2.3",
+			annotations:  map[string]string{signingProxyWebhookAnnotationNoStatusKey: "true"},
+			injectedBy:   false,
+			errorMessage: "Should not record the controller version when the status annotation is opted out of",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{ControllerVersion: tc.version},
+			}
+
+			podAnnotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+			for k, v := range tc.annotations {
+				podAnnotations[k] = v
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			var injectedBy string
+			assert.Equal(t, tc.injectedBy, findPatchValue(t, decodePatch(t, response), "/metadata/annotations/"+escapeJSONPointer(signingProxyWebhookAnnotationInjectedByKey), &injectedBy), tc.errorMessage)
+
+			if tc.injectedBy {
+				assert.Equal(t, tc.version, injectedBy, tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateProfile(t *testing.T) {
+	profiles := map[string]ProxyProfile{
+		"batch":       {TransportMaxIdleConns: intPtr(10)},
+		"low-latency": {TransportIdleConnTimeout: &metav1.Duration{Duration: time.Minute}},
+	}
+
+	var testCases = []struct {
+		name         string
+		profiles     map[string]ProxyProfile
+		annotations  map[string]string
+		allowed      bool
+		expected     map[string]string
+		errorMessage string
+	}{
+		{
+			name:         "TestNoProfile",
+			allowed:      true,
+			expected:     map[string]string{},
+			errorMessage: "Should not add transport flags without a profile",
+		},
+		{
+			name:         "TestLowLatency",
+			annotations:  map[string]string{signingProxyWebhookAnnotationProfileKey: "low-latency"},
+			allowed:      true,
+			expected:     map[string]string{"--transport-idle-conn-timeout": "5m0s", "--transport-max-idle-conns": "100"},
+			errorMessage: "Should expand the low-latency profile",
+		},
+		{
+			name:         "TestHighThroughput",
+			annotations:  map[string]string{signingProxyWebhookAnnotationProfileKey: " High-Throughput "},
+			allowed:      true,
+			expected:     map[string]string{"--idle-connection-timeout": "2m0s", "--transport-idle-conn-timeout": "1m30s", "--transport-max-idle-conns": "1000"},
+			errorMessage: "Should expand the high-throughput profile",
+		},
+		{
+			name: "TestAnnotationOverridesProfile",
+			annotations: map[string]string{
+				signingProxyWebhookAnnotationProfileKey:      "high-throughput",
+				signingProxyWebhookAnnotationMaxIdleConnsKey: "50",
+			},
+			allowed:      true,
+			expected:     map[string]string{"--idle-connection-timeout": "2m0s", "--transport-idle-conn-timeout": "1m30s", "--transport-max-idle-conns": "50"},
+			errorMessage: "Should let the transport annotations override the profile",
+		},
+		{
+			name:         "TestConfigProfile",
+			profiles:     profiles,
+			annotations:  map[string]string{signingProxyWebhookAnnotationProfileKey: "batch"},
+			allowed:      true,
+			expected:     map[string]string{"--transport-max-idle-conns": "10"},
+			errorMessage: "Should expand a profile defined in the config",
+		},
+		{
+			name:         "TestConfigReplacesBuiltin",
+			profiles:     profiles,
+			annotations:  map[string]string{signingProxyWebhookAnnotationProfileKey: "low-latency"},
+			allowed:      true,
+			expected:     map[string]string{"--transport-idle-conn-timeout": "1m0s"},
+			errorMessage: "Should replace the built-in profile of the same name",
+		},
+		{
+			name:         "TestUnknown",
+			annotations:  map[string]string{signingProxyWebhookAnnotationProfileKey: "turbo"},
+			allowed:      false,
+			errorMessage: "Should reject an unknown profile",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{Profiles: tc.profiles},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				assert.Contains(t, response.Result.Message, signingProxyWebhookAnnotationProfileKey, tc.errorMessage)
+				assert.Contains(t, response.Result.Message, "high-throughput, low-latency", "Should list the known profiles")
+				return
+			}
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, decodePatch(t, response), "/spec/containers/-", &container), "Should inject the sidecar")
+
+			for _, flag := range []string{"--idle-connection-timeout", "--transport-idle-conn-timeout", "--transport-max-idle-conns"} {
+				assert.Equal(t, tc.expected[flag], argValue(container.Args, flag), tc.errorMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookServer_mutateNoObject(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			signingProxyWebhookAnnotationInjectKey: "true",
+			signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	var testCases = []struct {
+		name         string
+		review       func() *v1beta1.AdmissionReview
+		errorMessage string
+	}{
+		{
+			name: "TestEmptyRaw",
+			review: func() *v1beta1.AdmissionReview {
+				review := newAdmissionReview(t, pod)
+				review.Request.Object.Raw = nil
+				return review
+			},
+			errorMessage: "Should allow a request without an object",
+		},
+		{
+			name: "TestNullRaw",
+			review: func() *v1beta1.AdmissionReview {
+				review := newAdmissionReview(t, pod)
+				review.Request.Object.Raw = []byte(" null ")
+				return review
+			},
+			errorMessage: "Should allow a request with a null object",
+		},
+		{
+			name: "TestDelete",
+			review: func() *v1beta1.AdmissionReview {
+				review := newAdmissionReview(t, pod)
+				review.Request.Operation = v1beta1.Delete
+				review.Request.OldObject = review.Request.Object
+				review.Request.Object.Raw = nil
+				return review
+			},
+			errorMessage: "Should allow a DELETE request",
+		},
+		{
+			name: "TestDeleteWithObject",
+			review: func() *v1beta1.AdmissionReview {
+				review := newAdmissionReview(t, pod)
+				review.Request.Operation = v1beta1.Delete
+				return review
+			},
+			errorMessage: "Should not patch on DELETE even with an object",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			namespaceClient := &mocks.KubernetesNamespaceClient{}
+
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: namespaceClient,
+			}
+
+			response, err := whsvr.mutate(context.Background(), tc.review())
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, tc.errorMessage)
+			assert.EqualValues(t, "test-uid", response.UID, "Should answer the request")
+			assert.Empty(t, response.Patch, "Should not patch")
+			namespaceClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestWebhookServer_mutateOperation(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		operation      v1beta1.Operation
+		injectOnUpdate bool
+		injected       bool
+		errorMessage   string
+	}{
+		{name: "TestCreate", operation: v1beta1.Create, injected: true, errorMessage: "Should inject on CREATE"},
+		{name: "TestUpdateDefault", operation: v1beta1.Update, injected: false, errorMessage: "Should skip UPDATE by default"},
+		{name: "TestUpdateEnabled", operation: v1beta1.Update, injectOnUpdate: true, injected: true, errorMessage: "Should inject on UPDATE when enabled"},
+		{name: "TestCreateEnabled", operation: v1beta1.Create, injectOnUpdate: true, injected: true, errorMessage: "Should still inject on CREATE when UPDATE is enabled"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{InjectOnUpdate: tc.injectOnUpdate},
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					signingProxyWebhookAnnotationInjectKey: "true",
+					signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			review := newAdmissionReview(t, pod)
+			review.Request.Operation = tc.operation
+
+			response, err := whsvr.mutate(context.Background(), review)
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+			assert.Equal(t, tc.injected, len(response.Patch) > 0, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestEphemeralUpdate", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				signingProxyWebhookAnnotationInjectKey:          "true",
+				signingProxyWebhookAnnotationHostKey:            "aps-workspaces.us-west-2.amazonaws.com",
+				signingProxyWebhookAnnotationInjectEphemeralKey: "true",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		review := newAdmissionReview(t, pod)
+		review.Request.Operation = v1beta1.Update
+		review.Request.SubResource = signingProxyWebhookEphemeralSubResource
+
+		response, err := whsvr.mutate(context.Background(), review)
+		assert.Nil(t, err, "Should succeed")
+		assert.NotEmpty(t, response.Patch, "Should add the ephemeral sidecar on UPDATE of the ephemeralcontainers subresource")
+	})
+}
+
+func TestWebhookServer_mutateProjectedToken(t *testing.T) {
+	const roleArn = "arn:aws:iam::123456789012:role/collector"
+
+	var testCases = []struct {
+		name           string
+		projectedToken bool
+		annotations    map[string]string
+		allowed        bool
+		injected       bool
+		errorMessage   string
+	}{
+		{
+			name:           "TestRoleArn",
+			projectedToken: true,
+			annotations:    map[string]string{signingProxyWebhookAnnotationRoleArnKey: roleArn, signingProxyWebhookAnnotationRoleSessionNameKey: "collector"},
+			allowed:        true,
+			injected:       true,
+			errorMessage:   "Should add the projected token with a role ARN",
+		},
+		{
+			name:           "TestNoRoleArn",
+			projectedToken: true,
+			allowed:        true,
+			errorMessage:   "Should not add the projected token without a role ARN",
+		},
+		{
+			name:         "TestDisabled",
+			annotations:  map[string]string{signingProxyWebhookAnnotationRoleArnKey: roleArn},
+			allowed:      true,
+			errorMessage: "Should not add the projected token unless enabled",
+		},
+		{
+			name:           "TestExternalId",
+			projectedToken: true,
+			annotations:    map[string]string{signingProxyWebhookAnnotationRoleArnKey: roleArn, signingProxyWebhookAnnotationRoleExternalIdKey: "external"},
+			allowed:        false,
+			errorMessage:   "Should reject an external ID, which web identity cannot pass",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{ProjectedToken: tc.projectedToken},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			for key, value := range tc.annotations {
+				annotations[key] = value
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			response, err := whsvr.mutate(context.Background(), newAdmissionReview(t, pod))
+			assert.Nil(t, err, "Should not return an error")
+			assert.Equal(t, tc.allowed, response.Allowed, tc.errorMessage)
+
+			if !tc.allowed {
+				return
+			}
+
+			patch := decodePatch(t, response)
+
+			var container corev1.Container
+			assert.True(t, findPatchValue(t, patch, "/spec/containers/-", &container), "Should inject the sidecar")
+
+			var volumes []corev1.Volume
+			assert.Equal(t, tc.injected, findPatchValue(t, patch, "/spec/volumes", &volumes), tc.errorMessage)
+
+			env := map[string]string{}
+
+			for _, envVar := range container.Env {
+				env[envVar.Name] = envVar.Value
+			}
+
+			if !tc.injected {
+				assert.NotContains(t, env, "AWS_WEB_IDENTITY_TOKEN_FILE", tc.errorMessage)
+				assert.Empty(t, container.VolumeMounts, tc.errorMessage)
+				return
+			}
+
+			assert.Len(t, volumes, 1, tc.errorMessage)
+			assert.Equal(t, projectedTokenVolumeName, volumes[0].Name, tc.errorMessage)
+			assert.NotNil(t, volumes[0].Projected, "Should add a projected volume")
+			assert.Equal(t, projectedTokenAudience, volumes[0].Projected.Sources[0].ServiceAccountToken.Audience, "Should request a token for STS")
+
+			assert.Equal(t, []corev1.VolumeMount{{
+				Name:      projectedTokenVolumeName,
+				MountPath: "/var/run/secrets/eks.amazonaws.com/serviceaccount",
+				ReadOnly:  true,
+			}}, container.VolumeMounts, "Should mount the token into the sidecar")
+
+			assert.Equal(t, roleArn, env["AWS_ROLE_ARN"], "Should set the role ARN")
+			assert.Equal(t, "/var/run/secrets/eks.amazonaws.com/serviceaccount/token", env["AWS_WEB_IDENTITY_TOKEN_FILE"], "Should set the token file")
+			assert.Equal(t, "collector", env["AWS_ROLE_SESSION_NAME"], "Should set the role session name")
+			assert.Empty(t, argValue(container.Args, "--role-arn"), "Should not assume the role again in the proxy")
+		})
+	}
+}
+
+func TestWebhookServer_mutateSidecarPosition(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		position      string
+		containers    []corev1.Container
+		sharedVolume  bool
+		expectedOrder []string
+		errorMessage  string
+	}{
+		{
+			name:          "TestDefault",
+			containers:    []corev1.Container{{Name: "app"}, {Name: "worker"}},
+			expectedOrder: []string{"app", "worker", signingProxyWebhookContainerName},
+			errorMessage:  "Should append the sidecar by default",
+		},
+		{
+			name:          "TestLast",
+			position:      SidecarPositionLast,
+			containers:    []corev1.Container{{Name: "app"}, {Name: "worker"}},
+			expectedOrder: []string{"app", "worker", signingProxyWebhookContainerName},
+			errorMessage:  "Should append the sidecar",
+		},
+		{
+			name:          "TestFirst",
+			position:      SidecarPositionFirst,
+			containers:    []corev1.Container{{Name: "app"}, {Name: "worker"}},
+			expectedOrder: []string{signingProxyWebhookContainerName, "app", "worker"},
+			errorMessage:  "Should insert the sidecar at index 0",
+		},
+		{
+			name:          "TestFirstEmpty",
+			position:      SidecarPositionFirst,
+			expectedOrder: []string{signingProxyWebhookContainerName},
+			errorMessage:  "Should add the container list with the sidecar",
+		},
+		{
+			name:          "TestLastEmpty",
+			position:      SidecarPositionLast,
+			expectedOrder: []string{signingProxyWebhookContainerName},
+			errorMessage:  "Should add the container list with the sidecar",
+		},
+		{
+			name:          "TestFirstSharedVolume",
+			position:      SidecarPositionFirst,
+			containers:    []corev1.Container{{Name: "app"}, {Name: "worker"}},
+			sharedVolume:  true,
+			expectedOrder: []string{signingProxyWebhookContainerName, "app", "worker"},
+			errorMessage:  "Should mount the shared volume into the moved container",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+				config:          Config{SidecarPosition: tc.position},
+			}
+
+			annotations := map[string]string{
+				signingProxyWebhookAnnotationInjectKey: "true",
+				signingProxyWebhookAnnotationHostKey:   "aps-workspaces.us-west-2.amazonaws.com",
+			}
+
+			if tc.sharedVolume {
+				annotations[signingProxyWebhookAnnotationSharedVolumeKey] = "worker"
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: tc.containers},
+			}
+
+			review := newAdmissionReview(t, pod)
+
+			response, err := whsvr.mutate(context.Background(), review)
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the pod")
+
+			patch, err := jsonpatch.DecodePatch(response.Patch)
+			assert.Nil(t, err, "Should decode the patch")
+
+			patched, err := patch.Apply(review.Request.Object.Raw)
+			assert.Nil(t, err, "Should apply the patch")
+
+			var patchedPod corev1.Pod
+			assert.Nil(t, json.Unmarshal(patched, &patchedPod), "Should decode the patched pod")
+
+			var order []string
+
+			for _, container := range patchedPod.Spec.Containers {
+				order = append(order, container.Name)
+			}
+
+			assert.Equal(t, tc.expectedOrder, order, tc.errorMessage)
+
+			if tc.sharedVolume {
+				assert.Empty(t, patchedPod.Spec.Containers[1].VolumeMounts, tc.errorMessage)
+				assert.Equal(t, signingProxyWebhookSharedVolumeName, patchedPod.Spec.Containers[2].VolumeMounts[0].Name, tc.errorMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// workloadTemplatePaths maps the workload resources the webhook can be registered on to the
+// JSON Pointer of their pod template, so that the sidecar is injected into the workload's spec
+// rather than its pods.
+var workloadTemplatePaths = map[schema.GroupResource]string{
+	{Group: "apps", Resource: "deployments"}:  "/spec/template",
+	{Group: "apps", Resource: "statefulsets"}: "/spec/template",
+}
+
+// podTemplateWorkload decodes the metadata and pod template shared by the workload resources.
+type podTemplateWorkload struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Template corev1.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+// workloadTemplatePath returns the path of the pod template of resource, and false if resource
+// is not a supported workload.
+func workloadTemplatePath(resource metav1.GroupVersionResource) (string, bool) {
+	templatePath, ok := workloadTemplatePaths[schema.GroupResource{Group: resource.Group, Resource: resource.Resource}]
+	return templatePath, ok
+}
+
+// decodeWorkloadPod returns the pod template of a workload as a pod named after the workload,
+// which the role session name and events are derived from.
+func decodeWorkloadPod(raw []byte) (corev1.Pod, error) {
+	var workload podTemplateWorkload
+
+	if err := json.Unmarshal(raw, &workload); err != nil {
+		return corev1.Pod{}, err
+	}
+
+	pod := corev1.Pod{
+		ObjectMeta: workload.Spec.Template.ObjectMeta,
+		Spec:       workload.Spec.Template.Spec,
+	}
+	pod.Name = workload.Name
+	pod.Namespace = workload.Namespace
+
+	return pod, nil
+}
+
+// prefixPatch moves patch operations computed for a pod to the pod template at templatePath.
+func prefixPatch(patchOperations []PatchOperation, templatePath string) []PatchOperation {
+	prefixed := make([]PatchOperation, len(patchOperations))
+
+	for i, operation := range patchOperations {
+		operation.Path = templatePath + operation.Path
+		prefixed[i] = operation
+	}
+
+	return prefixed
+}
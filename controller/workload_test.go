@@ -0,0 +1,152 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newWorkloadAdmissionReview(t *testing.T, resource string, workload interface{}) *v1beta1.AdmissionReview {
+	raw, err := json.Marshal(workload)
+	assert.Nil(t, err, "Should marshal workload")
+
+	return &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "test-uid",
+			Namespace: "testNamespace",
+			Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: resource},
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+func newPodTemplate(annotations map[string]string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "collector"},
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+}
+
+func TestWebhookServer_mutateWorkload(t *testing.T) {
+	annotations := map[string]string{
+		signingProxyWebhookAnnotationInjectKey:  "true",
+		signingProxyWebhookAnnotationHostKey:    "aps-workspaces.us-west-2.amazonaws.com",
+		signingProxyWebhookAnnotationRoleArnKey: "arn:aws:iam::123456789012:role/collector",
+	}
+
+	var testCases = []struct {
+		name     string
+		resource string
+		workload interface{}
+	}{
+		{
+			name:     "TestDeployment",
+			resource: "deployments",
+			workload: &appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: metav1.ObjectMeta{Name: "collector", Namespace: "testNamespace"},
+				Spec:       appsv1.DeploymentSpec{Template: newPodTemplate(annotations)},
+			},
+		},
+		{
+			name:     "TestStatefulSet",
+			resource: "statefulsets",
+			workload: &appsv1.StatefulSet{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+				ObjectMeta: metav1.ObjectMeta{Name: "collector", Namespace: "testNamespace"},
+				Spec:       appsv1.StatefulSetSpec{Template: newPodTemplate(annotations)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whsvr := &WebhookServer{
+				server:          nil,
+				namespaceClient: newNamespaceClient(map[string]string{}),
+			}
+
+			admissionReview := newWorkloadAdmissionReview(t, tc.resource, tc.workload)
+
+			response, err := whsvr.mutate(context.Background(), admissionReview)
+			assert.Nil(t, err, "Should succeed")
+			assert.True(t, response.Allowed, "Should allow the workload")
+
+			patch := decodePatch(t, response)
+
+			for _, operation := range patch {
+				assert.Regexp(t, "^/spec/template/", operation.Path, "Should only patch the pod template")
+			}
+
+			var sidecar corev1.Container
+			assert.True(t, findPatchValue(t, patch, "/spec/template/spec/containers/-", &sidecar), "Should add the sidecar to the template's containers")
+			assert.Equal(t, signingProxyWebhookContainerName, sidecar.Name)
+			assert.Equal(t, "collector", argValue(sidecar.Args, "--role-session-name"), "Should name the role session after the workload")
+
+			jsonPatch, err := jsonpatch.DecodePatch(response.Patch)
+			assert.Nil(t, err, "Should decode the patch")
+
+			patched, err := jsonPatch.Apply(admissionReview.Request.Object.Raw)
+			assert.Nil(t, err, "Should apply the patch to the workload")
+
+			pod, err := decodeWorkloadPod(patched)
+			assert.Nil(t, err, "Should decode the patched workload")
+			assert.Len(t, pod.Spec.Containers, 2, "Should add the sidecar to the workload's template")
+			assert.Equal(t, "injected", pod.Annotations[signingProxyWebhookAnnotationStatusKey], "Should mark the template as injected")
+		})
+	}
+
+	t.Run("TestInjectedTemplate", func(t *testing.T) {
+		whsvr := &WebhookServer{
+			server:          nil,
+			namespaceClient: newNamespaceClient(map[string]string{}),
+		}
+
+		template := newPodTemplate(annotations)
+		template.Spec.Containers = append(template.Spec.Containers, corev1.Container{Name: signingProxyWebhookContainerName})
+
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "collector"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		}
+
+		response, err := whsvr.mutate(context.Background(), newWorkloadAdmissionReview(t, "deployments", deployment))
+		assert.Nil(t, err, "Should succeed")
+		assert.True(t, response.Allowed, "Should allow the workload")
+		assert.Empty(t, response.Patch, "Should not inject a template that already has the sidecar")
+	})
+
+	t.Run("TestUnsupportedResource", func(t *testing.T) {
+		_, ok := workloadTemplatePath(metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"})
+		assert.False(t, ok, "Should only inject the supported workloads")
+
+		_, ok = workloadTemplatePath(metav1.GroupVersionResource{Version: "v1", Resource: "pods"})
+		assert.False(t, ok, "Should inject pods directly")
+	})
+}
@@ -19,57 +19,321 @@ import (
 	"aws-signingproxy-admissioncontroller/controller"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"log"
 	"net/http"
 	"os"
 	signal "os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// version is the build version of the controller, set with
+// -ldflags "-X main.version=<version>" and recorded on the pods it injects.
+var version = "dev"
+
 type WhSvrParameters struct {
-	port           int    // Webhook server port
-	certFile       string // Path to the x509 HTTPS certificate
-	keyFile        string // Path to the x509 private key matching the certFile
+	configFile      string // Path to the YAML controller configuration
+	port            int    // Webhook server port
+	certFile        string // Path to the x509 HTTPS certificate
+	keyFile         string // Path to the x509 private key matching the certFile
+	tlsMinVersion   string // Minimum TLS version accepted by the webhook server
+	clientCAFile    string // Path to the CA bundle used to verify client certificates
+	selfBootstrap   bool   // Generate a self-signed certificate and patch it into the webhook configuration
+	webhookService  string // <namespace>/<name> of the Service the certificate is generated for
+	webhookConfig   string // Name of the MutatingWebhookConfiguration whose caBundle is patched
+	insecureListen  string // Address of a plain HTTP listener for local development
+	maxRequestBytes int64  // Maximum size of an AdmissionReview request body
+	maxAnnotation   int    // Maximum length of settings annotation values
+	maxConcurrent   int    // Maximum number of requests handled at once
+	retryAttempts   int    // Attempts at describing the namespace before giving up
+	breakerFailures int    // Consecutive namespace lookup failures tripping the circuit breaker
+	failOpen        bool   // Allow pods unmodified when the namespace cannot be described
+	dryRun          bool   // Compute and log patches without applying them
+	logLevel        string // Verbosity of the controller's logs, info or debug
+	sidecarPosition string // Position of the sidecar among the pod's containers, first or last
+	allowedHosts    string // Comma separated glob patterns of permitted upstream hosts
+	meshExclusion   string // Comma separated service meshes annotated to not intercept the sidecar port
+	annotationEnv   string // Comma separated <annotation>=<ENV_VAR> mappings of sidecar environment variables
+	defaultRegion   string // Region used when none can be resolved for the sidecar
+	requireDigest   bool   // Reject sidecar images that are not pinned by digest
+	pullPolicy      string // Default imagePullPolicy of the sidecar
+	verifyImage     string // deny or warn when the sidecar image is not found in its registry
+	inheritProxyEnv bool   // Pass the controller's proxy environment variables to the sidecar
+	addEgressLabel  bool   // Label injected pods for NetworkPolicies to allow the sidecar's egress
+	requireIRSA     bool   // Reject pods whose ServiceAccount has no IRSA role when no role ARN is set
+	restartEndpoint bool   // Serve /restart to roll Deployments with injected pods
+	restartPort     int    // Port of the separate HTTPS listener serving /restart
+	restartClientCA string // Path to the CA bundle verifying the client certificates of /restart callers
+	mutatePath      string // Path of the mutating webhook endpoint
+	nativeSidecars  bool   // Inject the sidecar as a native sidecar init container
+	annotateConfig  bool   // Write the resolved sidecar parameters to a pod annotation
+	strictInject    bool   // Reject pods requesting injection without a host or service
+	limitRanges     bool   // Default sidecar resources from the namespace's LimitRanges
+	resourceQuota   bool   // Deny pods whose sidecar resources exceed the namespace's ResourceQuotas
+	setGOMAXPROCS   bool   // Set the sidecar's GOMAXPROCS from its CPU limit
+	injectOnUpdate  bool   // Also inject pods on UPDATE requests
+	reinject        bool   // Replace outdated sidecars of workload templates on UPDATE
+	mergeArgs       bool   // Keep user added flags of replaced sidecars
+	projectedToken  bool   // Give sidecars with a role ARN a projected ServiceAccount token
+	validateRegions bool   // Reject malformed regions and regions missing from the bundled region list
+	allowUnknown    bool   // Accept well-formed regions missing from the bundled region list
+	objectSelector  string // Label selector the pod's labels must match for injection
+	statusKey       string // Annotation marking pods as injected
+	prefix          string // Prefix of the pod annotations
+	argsTemplate    string // Go template rendering the sidecar arguments
+	socketFlag      string // Flag of the proxy image taking the unix socket path to listen on
+	tracing         bool   // Export OpenTelemetry traces over OTLP
+	selfTest        bool   // Run a canned AdmissionReview through the webhook at startup
+	nsDefaults      string // <namespace>/<name> of the namespace defaults ConfigMap
+
+	defaultCPURequest    string // Default sidecar CPU request
+	defaultCPULimit      string // Default sidecar CPU limit
+	defaultMemoryRequest string // Default sidecar memory request
+	defaultMemoryLimit   string // Default sidecar memory limit
+
+	retryBaseDelay  time.Duration // Delay before the first retry of describing the namespace
+	verifyImageTTL  time.Duration // How long the result of an image check is reused
+	nsGetTimeout    time.Duration // Time allowed for describing the namespace including retries
+	breakerCooldown time.Duration // Time the tripped circuit breaker fails namespace lookups
+	chaosLatency    time.Duration // Delay deliberately added to every request
+	chaosErrorRate  float64       // Fraction of requests deliberately failed
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "inject" {
+		if err := runInject(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("Error injecting manifest: %v", err)
+		}
+
+		return
+	}
+
 	var parameters WhSvrParameters
 
+	flag.StringVar(&parameters.configFile, "config", "", "YAML file containing the controller configuration. Flags take precedence over values in the file.")
 	flag.IntVar(&parameters.port, "port", 443, "Webhook server port.")
 	flag.StringVar(&parameters.certFile, "tlsCertFile", "/etc/webhook/certs/cert.pem", "File containing the x509 Certificate for HTTPS.")
 	flag.StringVar(&parameters.keyFile, "tlsKeyFile", "/etc/webhook/certs/key.pem", "File containing the x509 private key to --tlsCertFile.")
+	flag.StringVar(&parameters.tlsMinVersion, "tls-min-version", "1.2", "Minimum TLS version accepted by the webhook server, 1.2 or 1.3.")
+	flag.StringVar(&parameters.clientCAFile, "client-ca-file", "", "File containing the CA bundle used to verify client certificates. Client certificates are not required if empty.")
+	flag.BoolVar(&parameters.selfBootstrap, "self-bootstrap-certs", false, "Generate a self-signed certificate for --webhook-service at startup, write it to --tlsCertFile and --tlsKeyFile, and set it as the caBundle of --webhook-config-name. Requires permission to get and update the MutatingWebhookConfiguration.")
+	flag.StringVar(&parameters.webhookService, "webhook-service", "kube-system/aws-sigv4-proxy-admission-controller", "<namespace>/<name> of the webhook's Service, used by --self-bootstrap-certs.")
+	flag.StringVar(&parameters.webhookConfig, "webhook-config-name", "aws-sigv4-proxy-admission-controller", "Name of the MutatingWebhookConfiguration whose caBundle is set by --self-bootstrap-certs.")
+	flag.StringVar(&parameters.insecureListen, "insecure-listen", "", "Serve the webhook over plain HTTP on this address, e.g. :8080, instead of HTTPS. For local development only, cannot be combined with TLS flags.")
+	flag.Int64Var(&parameters.maxRequestBytes, "max-request-bytes", controller.DefaultMaxRequestBytes, "Maximum size in bytes of an AdmissionReview request body.")
+	flag.IntVar(&parameters.maxAnnotation, "max-annotation-length", controller.DefaultMaxAnnotationLength, "Maximum length of the value of each sidecar.aws.signing-proxy annotation of injected pods, longer values are rejected naming the annotation. Unlimited if 0.")
+	flag.Float64Var(&parameters.chaosErrorRate, "chaos-error-rate", 0, "Fraction of AdmissionReview requests deliberately failed with a 500, for testing failurePolicy and alerting. Requires "+controller.ChaosEnableEnv+"=true.")
+	flag.DurationVar(&parameters.chaosLatency, "chaos-latency", 0, "Delay deliberately added to every AdmissionReview request, for testing webhook timeouts. Requires "+controller.ChaosEnableEnv+"=true.")
+	flag.IntVar(&parameters.maxConcurrent, "max-concurrent-requests", 0, "Maximum number of AdmissionReview requests handled at once, further requests are rejected with 429. Unlimited if 0.")
+	flag.IntVar(&parameters.retryAttempts, "namespace-retry-attempts", controller.DefaultNamespaceRetryAttempts, "Attempts at describing the namespace of a pod before giving up, retrying transient API errors.")
+	flag.DurationVar(&parameters.retryBaseDelay, "namespace-retry-base-delay", controller.DefaultNamespaceRetryBaseDelay, "Delay before the first retry of describing the namespace, doubled after each further attempt.")
+	flag.DurationVar(&parameters.nsGetTimeout, "namespace-get-timeout", controller.DefaultNamespaceGetTimeout, "Time allowed for describing the namespace of a pod, including retries. Keep it below the webhook's timeoutSeconds. Unlimited if 0.")
+	flag.IntVar(&parameters.breakerFailures, "namespace-breaker-threshold", 0, "Consecutive failures at describing namespaces after which lookups fail immediately for --namespace-breaker-cooldown, allowing or denying pods per --fail-open. Disabled if 0.")
+	flag.DurationVar(&parameters.breakerCooldown, "namespace-breaker-cooldown", controller.DefaultNamespaceBreakerCooldown, "Time namespace lookups fail without calling the API server once the circuit breaker tripped.")
+	flag.BoolVar(&parameters.failOpen, "fail-open", false, "Allow pods without injecting the sidecar when the namespace cannot be described.")
+	flag.BoolVar(&parameters.dryRun, "dry-run", false, "Log the injection decision and the patch for each pod, with role ARNs redacted, without applying the patches.")
+	flag.StringVar(&parameters.sidecarPosition, "sidecar-position", controller.SidecarPositionLast, "Position of the sidecar in the pod's containers, first or last. Use last for tooling that expects the app container at index 0.")
+	flag.StringVar(&parameters.logLevel, "log-level", controller.LogLevelInfo, "Verbosity of the controller's logs, info or debug. debug also logs the base64 encoded patch of each pod, which includes its role ARN.")
+	flag.StringVar(&parameters.allowedHosts, "allowed-hosts", "", "Comma separated glob patterns of permitted upstream hosts, e.g. *.us-east-1.es.amazonaws.com. All hosts are allowed if empty.")
+	flag.StringVar(&parameters.meshExclusion, "mesh-exclusion", "", "Comma separated service meshes, istio or linkerd, whose annotations are added to injected pods so that their sidecar does not intercept the proxy port.")
+	flag.StringVar(&parameters.annotationEnv, "annotation-env", "", "Comma separated <annotation>=<ENV_VAR> mappings setting sidecar environment variables from pod annotations, e.g. team.example.com/team=SIDECAR_TEAM.")
+	flag.StringVar(&parameters.defaultRegion, "default-region", "", "Region used when none can be resolved from annotations, namespace labels or the host. Defaults to the controller's AWS_REGION or AWS_DEFAULT_REGION environment variable.")
+	flag.StringVar(&parameters.pullPolicy, "image-pull-policy", "", "Default imagePullPolicy of the sidecar, Always, IfNotPresent or Never, overridden by the image-pull-policy annotation and label. IfNotPresent if empty.")
+	flag.BoolVar(&parameters.requireDigest, "require-digest", false, "Reject pods when the sidecar image is referenced by tag instead of pinned by @sha256 digest.")
+	flag.StringVar(&parameters.verifyImage, "verify-image-exists", "", "Check that the sidecar image exists in its registry before injecting it: deny rejects the pod and warn injects it with a warning if the image is not found. Disabled if empty.")
+	flag.DurationVar(&parameters.verifyImageTTL, "verify-image-cache-ttl", controller.DefaultVerifyImageCacheTTL, "How long the result of a --verify-image-exists check is reused before querying the registry again.")
+	flag.BoolVar(&parameters.inheritProxyEnv, "inherit-proxy-env", false, "Set the controller's HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables on sidecars that do not set them with annotations.")
+	flag.BoolVar(&parameters.addEgressLabel, "add-egress-label", false, "Label injected pods with sigv4-proxy-egress=allowed so that NetworkPolicies can allow the sidecar's egress to AWS.")
+	flag.BoolVar(&parameters.requireIRSA, "require-irsa", false, "Reject pods without a role-arn annotation or label whose ServiceAccount is not annotated with eks.amazonaws.com/role-arn.")
+	flag.BoolVar(&parameters.nativeSidecars, "native-sidecars", false, "Inject the sidecar as a native sidecar, an init container with restartPolicy Always. Requires Kubernetes 1.29 or newer.")
+	flag.BoolVar(&parameters.annotateConfig, "annotate-resolved-config", false, "Write the resolved host, name, region, role and image of the sidecar as JSON to the sidecar.aws.signing-proxy/resolved-config annotation.")
+	flag.BoolVar(&parameters.strictInject, "strict-inject", false, "Reject pods annotated with sidecar.aws.signing-proxy/inject=true when neither the pod nor its namespace sets a host or service, instead of admitting them without the sidecar.")
+	flag.BoolVar(&parameters.limitRanges, "limit-range-defaults", false, "Set the resources of sidecars without resource annotations or default resources to the container defaults of the namespace's LimitRanges. Requires permission to list and watch LimitRanges.")
+	flag.BoolVar(&parameters.resourceQuota, "check-resource-quota", false, "Deny pods whose sidecar resources would exceed the remaining ResourceQuota of their namespace, with a message naming the sidecar. Requires permission to list and watch ResourceQuotas.")
+	flag.BoolVar(&parameters.injectOnUpdate, "inject-on-update", false, "Also inject the sidecar into pods on UPDATE admission requests. By default pods are only injected on CREATE.")
+	flag.BoolVar(&parameters.reinject, "reinject-on-change", false, "Write a checksum of the sidecar to the sidecar.aws.signing-proxy/config-checksum annotation, and replace the sidecar of Deployment and StatefulSet templates on UPDATE when the checksum no longer matches, e.g. after the default proxy image changed.")
+	flag.BoolVar(&parameters.mergeArgs, "reinject-merge-args", false, "With --reinject-on-change, keep the flags that were added to a replaced sidecar's args and are not set by the controller, such as --strip, while updating the managed ones like --region.")
+	flag.BoolVar(&parameters.projectedToken, "projected-token", false, "Mount a projected ServiceAccount token into sidecars with a role ARN and set AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE, so the proxy assumes the role with web identity as with IRSA, without the EKS pod identity webhook.")
+	flag.BoolVar(&parameters.setGOMAXPROCS, "set-gomaxprocs", false, "Set the GOMAXPROCS environment variable of sidecars with a CPU limit to the limit in whole cores, at least 1, to avoid CPU throttling.")
+	flag.BoolVar(&parameters.validateRegions, "validate-regions", false, "Reject pods whose resolved region is malformed or not in the bundled list of AWS regions.")
+	flag.BoolVar(&parameters.allowUnknown, "allow-unknown-regions", false, "With --validate-regions, accept well-formed regions that are not in the bundled list of AWS regions, e.g. newly launched regions.")
+	flag.StringVar(&parameters.objectSelector, "object-selector", "", "Label selector the pod's own labels must match for the sidecar to be injected, e.g. app in (api,worker).")
+	flag.StringVar(&parameters.statusKey, "status-annotation", "", "Annotation key marking pods as injected. Defaults to sidecar.aws.signing-proxy/status.")
+	flag.StringVar(&parameters.prefix, "annotation-prefix", "", "Prefix of the pod annotations read and written by the controller, e.g. sigv4.example.com. Defaults to sidecar.aws.signing-proxy.")
+	flag.StringVar(&parameters.argsTemplate, "args-template", "", "Go template rendering the sidecar arguments instead of the built-in ones, e.g. \"--name {{.Name}} --region {{.Region}} --host {{.Host}} --port :8005\".")
+	flag.StringVar(&parameters.socketFlag, "unix-socket-flag", "", "Flag of the proxy image taking the path of the unix socket to listen on, e.g. --unix-socket. The unix-socket annotation is rejected unless this or --args-template is set, since the stock image only listens on TCP ports.")
+	flag.BoolVar(&parameters.tracing, "tracing", false, "Export OpenTelemetry traces with the OTLP gRPC exporter, configured with the standard OTEL_* environment variables.")
+	flag.StringVar(&parameters.nsDefaults, "namespace-defaults-configmap", "", "<namespace>/<name> of a ConfigMap mapping namespace names to default annotations, overridden by the pod's own annotations.")
+	flag.StringVar(&parameters.mutatePath, "mutate-path", defaultMutatePath, "Path serving the mutating webhook, e.g. /sigv4/mutate to route several webhooks behind one Service by path. Must match the path of the MutatingWebhookConfiguration.")
+	flag.BoolVar(&parameters.restartEndpoint, "enable-restart-endpoint", false, "Serve POST /restart?namespace=<namespace> on --restart-port to restart the Deployments of injected pods. Requires --restart-client-ca-file.")
+	flag.IntVar(&parameters.restartPort, "restart-port", 8444, "Port of the separate HTTPS listener serving --enable-restart-endpoint.")
+	flag.StringVar(&parameters.restartClientCA, "restart-client-ca-file", "", "File containing the CA bundle verifying the client certificates of --enable-restart-endpoint callers. Use a CA that only issues certificates to administrators.")
+	flag.BoolVar(&parameters.selfTest, "self-test", false, "Run a canned AdmissionReview through the webhook at startup and exit if the sidecar is not injected.")
+	flag.StringVar(&parameters.defaultCPURequest, "default-cpu-request", "", "Default CPU request of the sidecar when the pod has no resource annotations.")
+	flag.StringVar(&parameters.defaultCPULimit, "default-cpu-limit", "", "Default CPU limit of the sidecar when the pod has no resource annotations.")
+	flag.StringVar(&parameters.defaultMemoryRequest, "default-memory-request", "", "Default memory request of the sidecar when the pod has no resource annotations.")
+	flag.StringVar(&parameters.defaultMemoryLimit, "default-memory-limit", "", "Default memory limit of the sidecar when the pod has no resource annotations.")
 	flag.Parse()
 
-	keyPair, err := tls.LoadX509KeyPair(parameters.certFile, parameters.keyFile)
+	config, err := controller.LoadConfig(parameters.configFile)
+
 	if err != nil {
-		fmt.Errorf("Error loading key pair: %v", err)
+		log.Fatalf("Error loading config: %v", err)
 	}
 
-	server := &http.Server{
-		Addr:      fmt.Sprintf(":%v", parameters.port),
-		TLSConfig: &tls.Config{Certificates: []tls.Certificate{keyPair}},
+	applyEnvironment(&config)
+	config.ControllerVersion = version
+
+	visited := visitedFlags(flag.CommandLine)
+
+	if err := applyParameters(&config, parameters, visited); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+
+	if err := validateMutatePath(parameters.mutatePath); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+
+	if err := validateRestartEndpoint(parameters); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+
+	var server *http.Server
+	var caBundle []byte
+
+	if parameters.insecureListen != "" {
+		if err := validateInsecureListen(parameters, visited); err != nil {
+			log.Fatalf("Error parsing flags: %v", err)
+		}
+
+		log.Printf("WARNING: serving the webhook over plain HTTP on %s without TLS or authentication, use --insecure-listen for local development only", parameters.insecureListen)
+
+		server = &http.Server{Addr: parameters.insecureListen}
+	} else {
+		if parameters.selfBootstrap {
+			caBundle, err = bootstrapCerts(parameters)
+
+			if err != nil {
+				log.Fatalf("Error bootstrapping certificates: %v", err)
+			}
+		}
+
+		server, err = loadServer(parameters)
+
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+	}
+
+	var restartServer *http.Server
+
+	if parameters.restartEndpoint {
+		restartServer, err = loadRestartServer(parameters)
+
+		if err != nil {
+			log.Fatalf("Error configuring the restart endpoint: %v", err)
+		}
 	}
 
-	client, err := newKubernetesClient()
+	if parameters.tracing {
+		tracerProvider, err := newTracerProvider(context.Background())
+
+		if err != nil {
+			log.Fatalf("Error configuring tracing: %v", err)
+		}
+
+		defer tracerProvider.Shutdown(context.Background())
+	}
+
+	// Only the local development mode runs outside a cluster, so the controller never talks to
+	// the cluster of a KUBECONFIG left in the environment of a deployment.
+	kubeconfig := ""
+
+	if parameters.insecureListen != "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+
+	client, err := newKubernetesClient(kubeconfig)
 
 	if err != nil {
-		fmt.Errorf("Error creating Kubernetes client: %v", err)
+		log.Fatalf("Error creating Kubernetes client: %v", err)
 	}
 
-	whsvr := controller.NewWebhookServer(server, client)
+	if caBundle != nil {
+		if err := controller.PatchWebhookCABundle(context.Background(), client, parameters.webhookConfig, caBundle); err != nil {
+			log.Fatalf("Error bootstrapping certificates: %v", err)
+		}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/mutate", whsvr.Handler)
-	server.Handler = mux
+		log.Printf("Set the caBundle of MutatingWebhookConfiguration %s to the self-signed certificate", parameters.webhookConfig)
+	}
+
+	if config.NativeSidecars {
+		if err := controller.CheckNativeSidecarSupport(client.Discovery()); err != nil {
+			log.Fatalf("Error enabling native sidecars: %v", err)
+		}
+	}
+
+	whsvr, err := controller.NewWebhookServer(server, client, config)
+
+	if err != nil {
+		log.Fatalf("Error creating webhook server: %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := whsvr.Start(stopCh); err != nil {
+		log.Fatalf("Error starting webhook server informers: %v", err)
+	}
+
+	if parameters.selfTest {
+		if err := whsvr.SelfTest(context.Background()); err != nil {
+			log.Fatalf("Self-test failed: %v", err)
+		}
+
+		log.Println("Self-test passed")
+	}
+
+	server.Handler = newServeMux(whsvr, parameters.mutatePath)
+
+	if restartServer != nil {
+		restartServer.Handler = newRestartServeMux(whsvr)
+
+		go func() {
+			if err := restartServer.ListenAndServeTLS("", ""); err != nil {
+				log.Printf("Error listening and serving restart endpoint: %v", err)
+			}
+		}()
+	}
 
 	go func() {
-		if err := server.ListenAndServeTLS("", ""); err != nil {
-			fmt.Errorf("Error listening and serving webhook server: %v", err)
+		var err error
+
+		if server.TLSConfig == nil {
+			err = server.ListenAndServe()
+		} else {
+			err = server.ListenAndServeTLS("", "")
+		}
+
+		if err != nil {
+			log.Printf("Error listening and serving webhook server: %v", err)
 		}
 	}()
 
@@ -79,14 +343,170 @@ func main() {
 
 	log.Println("Got OS shutdown signal, shutting down webhook server gracefully")
 
-	shutdownCtx, _ := context.WithTimeout(context.Background(), 10 * time.Second)
-	
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
 	server.Shutdown(shutdownCtx)
+
+	if restartServer != nil {
+		restartServer.Shutdown(shutdownCtx)
+	}
+}
+
+// newTracerProvider registers a global tracer provider exporting spans over OTLP gRPC. The
+// exporter endpoint, headers and service name are read from the OTEL_* environment variables.
+func newTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracegrpc.New(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating OTLP exporter: %v", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(sdkresource.Default()),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tracerProvider, nil
 }
 
-func newKubernetesClient() (*kubernetes.Clientset, error) {
+// runInject implements the inject subcommand, writing the manifest read from a file or stdin
+// to stdout with the sidecar injected into its pods and pod templates.
+func runInject(args []string, stdin io.Reader, stdout io.Writer) error {
+	flagSet := flag.NewFlagSet("inject", flag.ContinueOnError)
+	filename := flagSet.String("filename", "-", "Manifest to inject, - for stdin.")
+	configFile := flagSet.String("config", "", "YAML file containing the controller configuration.")
+	namespace := flagSet.String("namespace", "default", "Namespace of pods whose manifest sets none.")
+	namespaceLabels := flagSet.String("namespace-labels", "", "Comma separated key=value labels of the namespace, e.g. sidecar-inject=true,sidecar-host=aps-workspaces.us-west-2.amazonaws.com.")
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := controller.LoadConfig(*configFile)
+
+	if err != nil {
+		return err
+	}
+
+	config.ControllerVersion = version
+
+	nsLabels, err := labels.ConvertSelectorToLabelsMap(*namespaceLabels)
+
+	if err != nil {
+		return fmt.Errorf("Invalid namespace labels %q: %v", *namespaceLabels, err)
+	}
+
+	var manifest []byte
+
+	if *filename == "-" {
+		manifest, err = io.ReadAll(stdin)
+	} else {
+		manifest, err = os.ReadFile(*filename)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error reading manifest: %v", err)
+	}
+
+	injected, err := controller.InjectManifest(config, *namespace, nsLabels, manifest)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stdout.Write(injected)
+
+	return err
+}
+
+// defaultMutatePath is the path of the mutating webhook endpoint unless --mutate-path is set.
+const defaultMutatePath = "/mutate"
+
+// validateMutatePath ensures the mutating webhook path is absolute.
+func validateMutatePath(mutatePath string) error {
+	if !strings.HasPrefix(mutatePath, "/") || strings.ContainsAny(mutatePath, "?# ") {
+		return fmt.Errorf("Invalid --mutate-path %q: must be a path starting with /, such as %s", mutatePath, defaultMutatePath)
+	}
+
+	return nil
+}
+
+// newServeMux routes the webhook endpoints to whsvr, serving the mutating webhook at mutatePath.
+func newServeMux(whsvr *controller.WebhookServer, mutatePath string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(mutatePath, whsvr.Handler)
+
+	return mux
+}
+
+// newRestartServeMux routes /restart to whsvr on the listener of the restart endpoint.
+func newRestartServeMux(whsvr *controller.WebhookServer) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/restart", whsvr.RestartHandler)
+
+	return mux
+}
+
+// validateRestartEndpoint ensures the restart endpoint is protected by mutual TLS with a CA of its
+// own. It is served on a listener of its own, so that requiring client certificates for it
+// does not affect the API server's calls to the webhook.
+func validateRestartEndpoint(parameters WhSvrParameters) error {
+	if !parameters.restartEndpoint {
+		return nil
+	}
+
+	if parameters.restartClientCA == "" {
+		return fmt.Errorf("--enable-restart-endpoint requires --restart-client-ca-file to protect the endpoint with mutual TLS")
+	}
+
+	if parameters.insecureListen != "" {
+		return fmt.Errorf("--enable-restart-endpoint cannot be combined with --insecure-listen")
+	}
+
+	if parameters.restartPort == parameters.port {
+		return fmt.Errorf("--restart-port must differ from --port")
+	}
+
+	return nil
+}
+
+// validateInsecureListen ensures --insecure-listen is not combined with TLS flags, so a
+// production deployment cannot silently fall back to plain HTTP. A deployment relying on the
+// default flags is recognized by the Kubernetes service environment of its pod or by the
+// webhook certificate files it mounts.
+func validateInsecureListen(parameters WhSvrParameters, visited map[string]bool) error {
+	for _, name := range []string{"port", "tlsCertFile", "tlsKeyFile", "tls-min-version", "client-ca-file", "self-bootstrap-certs"} {
+		if visited[name] {
+			return fmt.Errorf("--insecure-listen cannot be combined with --%s", name)
+		}
+	}
+
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return fmt.Errorf("--insecure-listen cannot be used inside a cluster")
+	}
+
+	for _, file := range []string{parameters.certFile, parameters.keyFile} {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("--insecure-listen cannot be used with the certificate file %s present", file)
+		}
+	}
+
+	return nil
+}
+
+// newKubernetesClient uses the kubeconfig file if set, and the in-cluster configuration
+// otherwise.
+func newKubernetesClient(kubeconfig string) (*kubernetes.Clientset, error) {
 	config, err := rest.InClusterConfig()
 
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("Error initializing Kubernetes client: %v", err)
 	}
@@ -99,3 +519,422 @@ func newKubernetesClient() (*kubernetes.Clientset, error) {
 
 	return client, nil
 }
+
+// bootstrapCerts writes a self-signed certificate for the webhook's Service to the certificate
+// and key files, returning the certificate as the caBundle of the webhook configuration.
+func bootstrapCerts(parameters WhSvrParameters) ([]byte, error) {
+	namespace, name, err := controller.SplitWebhookService(parameters.webhookService)
+
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, keyPEM, err := controller.GenerateSelfSignedCert(namespace, name, controller.SelfSignedCertValidity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := controller.WriteCertFiles(parameters.certFile, parameters.keyFile, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+
+	return certPEM, nil
+}
+
+// loadServer builds the HTTPS server of the webhook from the certificate, key and client CA
+// files. It fails if the key pair cannot be loaded, rather than serving without a certificate
+// and failing every handshake.
+func loadServer(parameters WhSvrParameters) (*http.Server, error) {
+	keyPair, err := tls.LoadX509KeyPair(parameters.certFile, parameters.keyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error loading key pair %s and %s: %v", parameters.certFile, parameters.keyFile, err)
+	}
+
+	clientCAs, err := loadClientCAs(parameters.clientCAFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error loading client CA file: %v", err)
+	}
+
+	tlsConfig, err := newTLSConfig(keyPair, parameters.tlsMinVersion, clientCAs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Server{
+		Addr:      fmt.Sprintf(":%v", parameters.port),
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// loadRestartServer builds the HTTPS server of the restart endpoint, using the webhook's key pair
+// and requiring client certificates signed by --restart-client-ca-file.
+func loadRestartServer(parameters WhSvrParameters) (*http.Server, error) {
+	keyPair, err := tls.LoadX509KeyPair(parameters.certFile, parameters.keyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error loading key pair %s and %s: %v", parameters.certFile, parameters.keyFile, err)
+	}
+
+	clientCAs, err := loadClientCAs(parameters.restartClientCA)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error loading restart client CA file: %v", err)
+	}
+
+	tlsConfig, err := newTLSConfig(keyPair, parameters.tlsMinVersion, clientCAs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Server{
+		Addr:      fmt.Sprintf(":%v", parameters.restartPort),
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// loadClientCAs reads the PEM encoded CA bundle at path, returning nil if path is empty.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No PEM encoded certificates found in %q", path)
+	}
+
+	return pool, nil
+}
+
+// newTLSConfig builds the webhook server TLS configuration, rejecting TLS versions older
+// than minVersion and restricting TLS 1.2 to AEAD cipher suites with forward secrecy.
+// If clientCAs is set, callers must present a client certificate signed by one of them.
+func newTLSConfig(keyPair tls.Certificate, minVersion string, clientCAs *x509.CertPool) (*tls.Config, error) {
+	var minTLSVersion uint16
+
+	switch minVersion {
+	case "1.2":
+		minTLSVersion = tls.VersionTLS12
+	case "1.3":
+		minTLSVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("Invalid --tls-min-version %q: expected 1.2 or 1.3", minVersion)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		MinVersion:   minTLSVersion,
+		CipherSuites: []uint16{
+			// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 is required by HTTP/2.
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if clientCAs != nil {
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// visitedFlags returns the names of the flags that were explicitly set.
+func visitedFlags(flagSet *flag.FlagSet) map[string]bool {
+	visited := map[string]bool{}
+
+	flagSet.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+	})
+
+	return visited
+}
+
+// applyEnvironment seeds the config settings the file left unset from the standard AWS
+// environment variables of the controller's own pod, e.g. the region set by EKS, so that
+// they need not be configured twice. Flags applied afterwards still override them.
+func applyEnvironment(config *controller.Config) {
+	if config.DefaultRegion == "" {
+		for _, variable := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+			if region := strings.TrimSpace(os.Getenv(variable)); region != "" {
+				config.DefaultRegion = region
+				break
+			}
+		}
+	}
+}
+
+// applyParameters overrides the config with the flags that were explicitly set, so that
+// flags take precedence over the config file while unset flags keep the file values.
+func applyParameters(config *controller.Config, parameters WhSvrParameters, visited map[string]bool) error {
+	if visited["max-request-bytes"] {
+		config.MaxRequestBytes = parameters.maxRequestBytes
+	}
+
+	if visited["max-annotation-length"] {
+		config.MaxAnnotationLength = parameters.maxAnnotation
+	}
+
+	if visited["max-concurrent-requests"] {
+		config.MaxConcurrentRequests = parameters.maxConcurrent
+	}
+
+	if visited["chaos-error-rate"] {
+		config.ChaosErrorRate = parameters.chaosErrorRate
+	}
+
+	if visited["chaos-latency"] {
+		config.ChaosLatency = metav1.Duration{Duration: parameters.chaosLatency}
+	}
+
+	if visited["namespace-retry-attempts"] {
+		config.NamespaceRetryAttempts = parameters.retryAttempts
+	}
+
+	if visited["namespace-retry-base-delay"] {
+		config.NamespaceRetryBaseDelay = metav1.Duration{Duration: parameters.retryBaseDelay}
+	}
+
+	if visited["namespace-get-timeout"] {
+		config.NamespaceGetTimeout = metav1.Duration{Duration: parameters.nsGetTimeout}
+	}
+
+	if visited["namespace-breaker-threshold"] {
+		config.NamespaceBreakerThreshold = parameters.breakerFailures
+	}
+
+	if visited["namespace-breaker-cooldown"] {
+		config.NamespaceBreakerCooldown = metav1.Duration{Duration: parameters.breakerCooldown}
+	}
+
+	if visited["fail-open"] {
+		config.FailOpen = parameters.failOpen
+	}
+
+	if visited["log-level"] {
+		config.LogLevel = parameters.logLevel
+	}
+
+	if visited["sidecar-position"] {
+		config.SidecarPosition = parameters.sidecarPosition
+	}
+
+	if visited["dry-run"] {
+		config.DryRun = parameters.dryRun
+	}
+
+	if visited["allowed-hosts"] {
+		config.AllowedHosts = splitList(parameters.allowedHosts)
+	}
+
+	if visited["annotation-env"] {
+		annotationEnv, err := controller.ParseAnnotationEnv(parameters.annotationEnv)
+
+		if err != nil {
+			return err
+		}
+
+		config.AnnotationEnv = annotationEnv
+	}
+
+	if visited["mesh-exclusion"] {
+		config.MeshExclusion = splitList(parameters.meshExclusion)
+	}
+
+	if visited["default-region"] {
+		config.DefaultRegion = parameters.defaultRegion
+	}
+
+	if visited["image-pull-policy"] {
+		config.ImagePullPolicy = corev1.PullPolicy(parameters.pullPolicy)
+	}
+
+	if visited["require-digest"] {
+		config.RequireDigest = parameters.requireDigest
+	}
+
+	if visited["verify-image-exists"] {
+		config.VerifyImage = parameters.verifyImage
+	}
+
+	if visited["verify-image-cache-ttl"] {
+		config.VerifyImageCacheTTL = metav1.Duration{Duration: parameters.verifyImageTTL}
+	}
+
+	if visited["inherit-proxy-env"] {
+		config.InheritProxyEnv = parameters.inheritProxyEnv
+	}
+
+	if visited["add-egress-label"] {
+		config.AddEgressLabel = parameters.addEgressLabel
+	}
+
+	if visited["require-irsa"] {
+		config.RequireIRSA = parameters.requireIRSA
+	}
+
+	if visited["native-sidecars"] {
+		config.NativeSidecars = parameters.nativeSidecars
+	}
+
+	if visited["annotate-resolved-config"] {
+		config.AnnotateResolvedConfig = parameters.annotateConfig
+	}
+
+	if visited["strict-inject"] {
+		config.StrictInject = parameters.strictInject
+	}
+
+	if visited["limit-range-defaults"] {
+		config.LimitRangeDefaults = parameters.limitRanges
+	}
+
+	if visited["check-resource-quota"] {
+		config.CheckResourceQuota = parameters.resourceQuota
+	}
+
+	if visited["inject-on-update"] {
+		config.InjectOnUpdate = parameters.injectOnUpdate
+	}
+
+	if visited["reinject-on-change"] {
+		config.ReinjectOnChange = parameters.reinject
+	}
+
+	if visited["reinject-merge-args"] {
+		config.ReinjectMergeArgs = parameters.mergeArgs
+	}
+
+	if visited["projected-token"] {
+		config.ProjectedToken = parameters.projectedToken
+	}
+
+	if visited["set-gomaxprocs"] {
+		config.SetGOMAXPROCS = parameters.setGOMAXPROCS
+	}
+
+	if visited["validate-regions"] {
+		config.ValidateRegions = parameters.validateRegions
+	}
+
+	if visited["allow-unknown-regions"] {
+		config.AllowUnknownRegions = parameters.allowUnknown
+	}
+
+	if visited["object-selector"] && parameters.objectSelector != "" {
+		objectSelector, err := labels.Parse(parameters.objectSelector)
+
+		if err != nil {
+			return fmt.Errorf("Invalid --object-selector %q: %v", parameters.objectSelector, err)
+		}
+
+		config.ObjectSelector = objectSelector
+	}
+
+	if visited["status-annotation"] {
+		config.StatusAnnotation = parameters.statusKey
+	}
+
+	if visited["annotation-prefix"] {
+		config.AnnotationPrefix = parameters.prefix
+	}
+
+	if visited["args-template"] {
+		config.ArgsTemplate = parameters.argsTemplate
+	}
+
+	if visited["unix-socket-flag"] {
+		config.UnixSocketFlag = parameters.socketFlag
+	}
+
+	if visited["namespace-defaults-configmap"] {
+		config.NamespaceDefaultsConfigMap = parameters.nsDefaults
+	}
+
+	if err := applyResourceParameters(&config.DefaultResources, parameters, visited); err != nil {
+		return err
+	}
+
+	return config.Validate()
+}
+
+func applyResourceParameters(requirements *corev1.ResourceRequirements, parameters WhSvrParameters, visited map[string]bool) error {
+	if requirements.Requests == nil {
+		requirements.Requests = corev1.ResourceList{}
+	}
+
+	if requirements.Limits == nil {
+		requirements.Limits = corev1.ResourceList{}
+	}
+
+	quantities := []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+		flag  string
+	}{
+		{requirements.Requests, corev1.ResourceCPU, parameters.defaultCPURequest, "default-cpu-request"},
+		{requirements.Limits, corev1.ResourceCPU, parameters.defaultCPULimit, "default-cpu-limit"},
+		{requirements.Requests, corev1.ResourceMemory, parameters.defaultMemoryRequest, "default-memory-request"},
+		{requirements.Limits, corev1.ResourceMemory, parameters.defaultMemoryLimit, "default-memory-limit"},
+	}
+
+	for _, q := range quantities {
+		if !visited[q.flag] {
+			continue
+		}
+
+		if q.value == "" {
+			delete(q.list, q.name)
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(q.value)
+
+		if err != nil {
+			return fmt.Errorf("Invalid --%s %q: %v", q.flag, q.value, err)
+		}
+
+		q.list[q.name] = quantity
+	}
+
+	if len(requirements.Requests) == 0 {
+		requirements.Requests = nil
+	}
+
+	if len(requirements.Limits) == 0 {
+		requirements.Limits = nil
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,506 @@
+/*
+ * Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License").
+ * You may not use this file except in compliance with the License.
+ * A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the "license" file accompanying this file. This file is distributed
+ * on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+ * express or implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"aws-signingproxy-admissioncontroller/controller"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a certificate for localhost signed by parent, or a
+// self-signed certificate if parent is nil.
+func newTestCertificate(t *testing.T, parent *tls.Certificate, isCA bool, extKeyUsage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err, "Should generate key")
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(t, err, "Should generate serial")
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{extKeyUsage},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, interface{}(key)
+
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err, "Should create certificate")
+
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err, "Should parse certificate")
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// newTestTLSServer starts an HTTPS server with the given TLS configuration.
+func newTestTLSServer(t *testing.T, tlsConfig *tls.Config) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	serverCert := newTestCertificate(t, nil, false, x509.ExtKeyUsageServerAuth)
+
+	t.Run("TestInvalidMinVersion", func(t *testing.T) {
+		_, err := newTLSConfig(serverCert, "1.1", nil)
+		assert.NotNil(t, err, "Should reject TLS 1.1 as a minimum version")
+	})
+
+	var testCases = []struct {
+		name          string
+		minVersion    string
+		clientVersion uint16
+		accepted      bool
+		errorMessage  string
+	}{
+		{name: "TestRejectTLS11", minVersion: "1.2", clientVersion: tls.VersionTLS11, accepted: false, errorMessage: "Should reject a TLS 1.1 handshake"},
+		{name: "TestAcceptTLS12", minVersion: "1.2", clientVersion: tls.VersionTLS12, accepted: true, errorMessage: "Should accept a TLS 1.2 handshake"},
+		{name: "TestRejectTLS12WithMin13", minVersion: "1.3", clientVersion: tls.VersionTLS12, accepted: false, errorMessage: "Should reject a TLS 1.2 handshake when 1.3 is required"},
+		{name: "TestAcceptTLS13", minVersion: "1.3", clientVersion: tls.VersionTLS13, accepted: true, errorMessage: "Should accept a TLS 1.3 handshake"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tlsConfig, err := newTLSConfig(serverCert, tc.minVersion, nil)
+			assert.Nil(t, err, "Should build the TLS config")
+
+			server := newTestTLSServer(t, tlsConfig)
+
+			conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true,
+				MinVersion:         tc.clientVersion,
+				MaxVersion:         tc.clientVersion,
+			})
+
+			if conn != nil {
+				conn.Close()
+			}
+
+			assert.Equal(t, tc.accepted, err == nil, tc.errorMessage)
+		})
+	}
+}
+
+func TestLoadServer(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	certPEM, keyPEM, err := controller.GenerateSelfSignedCert("kube-system", "sigv4-webhook", time.Hour)
+	assert.Nil(t, err, "Should generate a certificate")
+	assert.Nil(t, controller.WriteCertFiles(certFile, keyFile, certPEM, keyPEM), "Should write the certificate")
+
+	t.Run("TestValidKeyPair", func(t *testing.T) {
+		server, err := loadServer(WhSvrParameters{port: 8443, certFile: certFile, keyFile: keyFile, tlsMinVersion: "1.2"})
+		assert.Nil(t, err, "Should load the key pair")
+		assert.Equal(t, ":8443", server.Addr, "Should listen on the port")
+		assert.Len(t, server.TLSConfig.Certificates, 1, "Should serve the certificate")
+		assert.NotEmpty(t, server.TLSConfig.Certificates[0].Certificate, "Should not serve an empty certificate")
+	})
+
+	t.Run("TestMissingCertFile", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing.pem")
+
+		_, err := loadServer(WhSvrParameters{port: 8443, certFile: missing, keyFile: keyFile, tlsMinVersion: "1.2"})
+		assert.NotNil(t, err, "Should fail without the certificate file")
+		assert.Contains(t, err.Error(), missing, "Should name the certificate file")
+	})
+
+	t.Run("TestMismatchedKeyPair", func(t *testing.T) {
+		_, err := loadServer(WhSvrParameters{port: 8443, certFile: keyFile, keyFile: certFile, tlsMinVersion: "1.2"})
+		assert.NotNil(t, err, "Should fail on files that are not a key pair")
+	})
+
+	t.Run("TestInvalidClientCAFile", func(t *testing.T) {
+		_, err := loadServer(WhSvrParameters{port: 8443, certFile: certFile, keyFile: keyFile, tlsMinVersion: "1.2", clientCAFile: filepath.Join(dir, "missing-ca.pem")})
+		assert.NotNil(t, err, "Should fail without the client CA file")
+	})
+}
+
+func TestRestartEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	certPEM, keyPEM, err := controller.GenerateSelfSignedCert("kube-system", "sigv4-webhook", time.Hour)
+	assert.Nil(t, err, "Should generate a certificate")
+	assert.Nil(t, controller.WriteCertFiles(certFile, keyFile, certPEM, keyPEM), "Should write the certificate")
+
+	parameters := WhSvrParameters{port: 8443, certFile: certFile, keyFile: keyFile, tlsMinVersion: "1.2", restartEndpoint: true, restartPort: 8444, restartClientCA: certFile}
+
+	t.Run("TestValidate", func(t *testing.T) {
+		assert.Nil(t, validateRestartEndpoint(parameters), "Should accept a restart CA and port")
+		assert.Nil(t, validateRestartEndpoint(WhSvrParameters{}), "Should not check a disabled endpoint")
+
+		noCA := parameters
+		noCA.restartClientCA = ""
+		assert.NotNil(t, validateRestartEndpoint(noCA), "Should require a restart CA")
+
+		samePort := parameters
+		samePort.restartPort = samePort.port
+		assert.NotNil(t, validateRestartEndpoint(samePort), "Should require a listener of its own")
+
+		insecure := parameters
+		insecure.insecureListen = "127.0.0.1:8080"
+		assert.NotNil(t, validateRestartEndpoint(insecure), "Should not serve the endpoint without TLS")
+	})
+
+	t.Run("TestSeparateListener", func(t *testing.T) {
+		webhookServer, err := loadServer(parameters)
+		assert.Nil(t, err, "Should load the webhook server")
+		assert.Equal(t, tls.NoClientCert, webhookServer.TLSConfig.ClientAuth, "Should not require client certificates from the API server")
+
+		restartServer, err := loadRestartServer(parameters)
+		assert.Nil(t, err, "Should load the restart server")
+		assert.Equal(t, ":8444", restartServer.Addr, "Should listen on the restart port")
+		assert.Equal(t, tls.RequireAndVerifyClientCert, restartServer.TLSConfig.ClientAuth, "Should require client certificates")
+	})
+}
+
+func TestApplyParameters(t *testing.T) {
+	fileConfig := func() controller.Config {
+		config := controller.DefaultConfig()
+		config.DefaultRegion = "us-west-2"
+		config.FailOpen = true
+		config.AllowedHosts = []string{"*.us-west-2.amazonaws.com"}
+		config.DefaultResources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+		}
+		return config
+	}
+
+	parameters := WhSvrParameters{
+		maxRequestBytes:   controller.DefaultMaxRequestBytes,
+		defaultRegion:     "eu-west-1",
+		allowedHosts:      "*.eu-west-1.amazonaws.com, *.eu-central-1.amazonaws.com",
+		defaultCPURequest: "250m",
+	}
+
+	t.Run("TestFlagsOverrideFile", func(t *testing.T) {
+		config := fileConfig()
+		visited := map[string]bool{"default-region": true, "allowed-hosts": true, "default-cpu-request": true}
+
+		assert.Nil(t, applyParameters(&config, parameters, visited), "Should succeed")
+		assert.Equal(t, "eu-west-1", config.DefaultRegion, "Flag should override the file region")
+		assert.Equal(t, []string{"*.eu-west-1.amazonaws.com", "*.eu-central-1.amazonaws.com"}, config.AllowedHosts, "Flag should override the file allowed hosts")
+		assert.Equal(t, resource.MustParse("250m"), config.DefaultResources.Requests[corev1.ResourceCPU], "Flag should override the file CPU request")
+		assert.Equal(t, resource.MustParse("64Mi"), config.DefaultResources.Requests[corev1.ResourceMemory], "Should keep the file memory request")
+		assert.True(t, config.FailOpen, "Should keep the file fail-open")
+	})
+
+	t.Run("TestUnsetFlagsKeepFile", func(t *testing.T) {
+		config := fileConfig()
+
+		assert.Nil(t, applyParameters(&config, parameters, map[string]bool{}), "Should succeed")
+		assert.Equal(t, fileConfig(), config, "Should keep the file values")
+	})
+
+	t.Run("TestInvalidFlag", func(t *testing.T) {
+		config := fileConfig()
+		invalid := WhSvrParameters{defaultMemoryLimit: "128MB"}
+
+		assert.NotNil(t, applyParameters(&config, invalid, map[string]bool{"default-memory-limit": true}), "Should reject an invalid quantity")
+	})
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		env          map[string]string
+		fileRegion   string
+		flagRegion   string
+		expected     string
+		errorMessage string
+	}{
+		{name: "TestUnset", expected: "", errorMessage: "Should leave the region unset"},
+		{name: "TestRegion", env: map[string]string{"AWS_REGION": "us-east-2"}, expected: "us-east-2", errorMessage: "Should default to AWS_REGION"},
+		{name: "TestDefaultRegion", env: map[string]string{"AWS_DEFAULT_REGION": "eu-west-1"}, expected: "eu-west-1", errorMessage: "Should fall back to AWS_DEFAULT_REGION"},
+		{name: "TestRegionFirst", env: map[string]string{"AWS_REGION": "us-east-2", "AWS_DEFAULT_REGION": "eu-west-1"}, expected: "us-east-2", errorMessage: "Should prefer AWS_REGION"},
+		{name: "TestFileOverrides", env: map[string]string{"AWS_REGION": "us-east-2"}, fileRegion: "us-west-2", expected: "us-west-2", errorMessage: "Should keep the file region"},
+		{name: "TestFlagOverrides", env: map[string]string{"AWS_REGION": "us-east-2"}, flagRegion: "ap-south-1", expected: "ap-south-1", errorMessage: "Should let the flag override the environment"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", tc.env["AWS_REGION"])
+			t.Setenv("AWS_DEFAULT_REGION", tc.env["AWS_DEFAULT_REGION"])
+
+			config := controller.DefaultConfig()
+			config.DefaultRegion = tc.fileRegion
+
+			applyEnvironment(&config)
+
+			visited := map[string]bool{}
+
+			if tc.flagRegion != "" {
+				visited["default-region"] = true
+			}
+
+			assert.Nil(t, applyParameters(&config, WhSvrParameters{maxRequestBytes: controller.DefaultMaxRequestBytes, defaultRegion: tc.flagRegion}, visited), "Should succeed")
+			assert.Equal(t, tc.expected, config.DefaultRegion, tc.errorMessage)
+		})
+	}
+}
+
+func TestClientCertificateVerification(t *testing.T) {
+	serverCert := newTestCertificate(t, nil, false, x509.ExtKeyUsageServerAuth)
+	trustedCA := newTestCertificate(t, nil, true, x509.ExtKeyUsageClientAuth)
+	untrustedCA := newTestCertificate(t, nil, true, x509.ExtKeyUsageClientAuth)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: trustedCA.Certificate[0]}), 0600)
+	assert.Nil(t, err, "Should write CA file")
+
+	clientCAs, err := loadClientCAs(caFile)
+	assert.Nil(t, err, "Should load CA file")
+
+	tlsConfig, err := newTLSConfig(serverCert, "1.2", clientCAs)
+	assert.Nil(t, err, "Should build the TLS config")
+
+	server := newTestTLSServer(t, tlsConfig)
+
+	var testCases = []struct {
+		name         string
+		clientCerts  []tls.Certificate
+		accepted     bool
+		errorMessage string
+	}{
+		{name: "TestTrustedClientCert", clientCerts: []tls.Certificate{newTestCertificate(t, &trustedCA, false, x509.ExtKeyUsageClientAuth)}, accepted: true, errorMessage: "Should accept a client certificate signed by the CA"},
+		{name: "TestUntrustedClientCert", clientCerts: []tls.Certificate{newTestCertificate(t, &untrustedCA, false, x509.ExtKeyUsageClientAuth)}, accepted: false, errorMessage: "Should reject a client certificate signed by another CA"},
+		{name: "TestMissingClientCert", clientCerts: nil, accepted: false, errorMessage: "Should reject a request without a client certificate"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       tc.clientCerts,
+			}}}
+
+			response, err := client.Get(server.URL)
+
+			if response != nil {
+				response.Body.Close()
+			}
+
+			assert.Equal(t, tc.accepted, err == nil, tc.errorMessage)
+		})
+	}
+
+	t.Run("TestEmptyCAFile", func(t *testing.T) {
+		emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+		assert.Nil(t, os.WriteFile(emptyFile, []byte{}, 0600), "Should write empty file")
+
+		_, err := loadClientCAs(emptyFile)
+		assert.NotNil(t, err, "Should reject a CA file without certificates")
+	})
+
+	t.Run("TestNoCAFile", func(t *testing.T) {
+		pool, err := loadClientCAs("")
+		assert.Nil(t, err, "Should not fail without a CA file")
+		assert.Nil(t, pool, "Should not require client certificates without a CA file")
+	})
+}
+
+func TestInsecureListen(t *testing.T) {
+	dir := t.TempDir()
+	parameters := WhSvrParameters{certFile: filepath.Join(dir, "cert.pem"), keyFile: filepath.Join(dir, "key.pem")}
+
+	t.Run("TestRejectTLSFlags", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+		assert.NotNil(t, validateInsecureListen(parameters, map[string]bool{"tlsCertFile": true}), "Should reject --insecure-listen with a TLS certificate")
+		assert.Nil(t, validateInsecureListen(parameters, map[string]bool{"dry-run": true}), "Should allow --insecure-listen with other flags")
+	})
+
+	t.Run("TestRejectInCluster", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+
+		assert.NotNil(t, validateInsecureListen(parameters, map[string]bool{}), "Should reject --insecure-listen inside a cluster")
+	})
+
+	t.Run("TestRejectDefaultCertificates", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+		assert.Nil(t, os.WriteFile(parameters.certFile, []byte("certificate"), 0600), "Should write the certificate file")
+		defer os.Remove(parameters.certFile)
+
+		assert.NotNil(t, validateInsecureListen(parameters, map[string]bool{}), "Should reject --insecure-listen next to webhook certificates")
+	})
+
+	t.Run("TestMutateOverHTTP", func(t *testing.T) {
+		client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+		whsvr, err := controller.NewWebhookServer(nil, client, controller.DefaultConfig())
+		assert.Nil(t, err, "Should create the webhook server")
+
+		server := httptest.NewServer(newServeMux(whsvr, defaultMutatePath))
+		t.Cleanup(server.Close)
+
+		pod, err := json.Marshal(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: map[string]string{
+				"sidecar.aws.signing-proxy/inject": "true",
+				"sidecar.aws.signing-proxy/host":   "aps-workspaces.us-west-2.amazonaws.com",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		assert.Nil(t, err, "Should marshal pod")
+
+		body, err := json.Marshal(&admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       "test-uid",
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: pod},
+			},
+		})
+		assert.Nil(t, err, "Should marshal AdmissionReview")
+
+		response, err := http.Post(server.URL+"/mutate", "application/json", bytes.NewReader(body))
+		assert.Nil(t, err, "Should reach the webhook over plain HTTP")
+		defer response.Body.Close()
+
+		var review admissionv1.AdmissionReview
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Should handle the AdmissionReview")
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&review), "Should decode the AdmissionReview")
+		assert.Equal(t, "test-uid", string(review.Response.UID), "Should answer the request")
+		assert.True(t, review.Response.Allowed, "Should allow the pod")
+		assert.NotEmpty(t, review.Response.Patch, "Should return the sidecar patch")
+	})
+}
+
+func TestMutatePath(t *testing.T) {
+	t.Run("TestValidate", func(t *testing.T) {
+		assert.Nil(t, validateMutatePath("/sigv4/mutate"), "Should accept an absolute path")
+		assert.NotNil(t, validateMutatePath("mutate"), "Should reject a relative path")
+		assert.NotNil(t, validateMutatePath("/mutate?x=1"), "Should reject a path with a query")
+		assert.Nil(t, validateMutatePath("/restart"), "Should allow /restart, which is served on its own listener")
+	})
+
+	t.Run("TestCustomPath", func(t *testing.T) {
+		client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+		whsvr, err := controller.NewWebhookServer(nil, client, controller.DefaultConfig())
+		assert.Nil(t, err, "Should create the webhook server")
+
+		server := httptest.NewServer(newServeMux(whsvr, "/sigv4/mutate"))
+		t.Cleanup(server.Close)
+
+		pod, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app"}})
+		assert.Nil(t, err, "Should marshal pod")
+
+		body, err := json.Marshal(&admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       "test-uid",
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: pod},
+			},
+		})
+		assert.Nil(t, err, "Should marshal AdmissionReview")
+
+		response, err := http.Post(server.URL+"/sigv4/mutate", "application/json", bytes.NewReader(body))
+		assert.Nil(t, err, "Should reach the webhook")
+		defer response.Body.Close()
+
+		var review admissionv1.AdmissionReview
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Should serve the webhook at the custom path")
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&review), "Should decode the AdmissionReview")
+		assert.Equal(t, "test-uid", string(review.Response.UID), "Should answer the request")
+
+		defaultResponse, err := http.Post(server.URL+defaultMutatePath, "application/json", bytes.NewReader(body))
+		assert.Nil(t, err, "Should reach the server")
+		defer defaultResponse.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, defaultResponse.StatusCode, "Should not serve the default path")
+	})
+}
+
+func TestRunInject(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  containers:
+  - name: app
+    image: app:1.0
+`
+
+	t.Run("TestStdin", func(t *testing.T) {
+		var output bytes.Buffer
+
+		err := runInject([]string{"--namespace-labels", "sidecar-inject=true,sidecar-host=aps-workspaces.us-west-2.amazonaws.com"}, strings.NewReader(manifest), &output)
+		assert.Nil(t, err, "Should succeed")
+		assert.Contains(t, output.String(), "sidecar-aws-sigv4-proxy", "Should add the proxy container")
+	})
+
+	t.Run("TestInjectedBy", func(t *testing.T) {
+		defer func(previous string) { version = previous }(version)
+		version = "v1.2.3"
+
+		var output bytes.Buffer
+
+		err := runInject([]string{"--namespace-labels", "sidecar-inject=true,sidecar-host=aps-workspaces.us-west-2.amazonaws.com"}, strings.NewReader(manifest), &output)
+		assert.Nil(t, err, "Should succeed")
+		assert.Contains(t, output.String(), "sidecar.aws.signing-proxy/injected-by: v1.2.3", "Should record the controller version")
+	})
+
+	t.Run("TestInvalidNamespaceLabels", func(t *testing.T) {
+		err := runInject([]string{"--namespace-labels", "sidecar-inject"}, strings.NewReader(manifest), &bytes.Buffer{})
+		assert.NotNil(t, err, "Should reject malformed namespace labels")
+	})
+}